@@ -3,6 +3,8 @@ package cli
 import (
 	"context"
 	"errors"
+	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 
@@ -10,18 +12,37 @@ import (
 	"t0ast.cc/tbml/internal"
 	uerror "t0ast.cc/tbml/util/error"
 	uio "t0ast.cc/tbml/util/io"
+	ulog "t0ast.cc/tbml/util/log"
 )
 
 var ErrNoConfig error = errors.New("No config file found")
 
 var CLI struct {
 	ConfigPath string `help:"Path of the configuration file to use (default: ~/.config/tbml/config.json, then /etc/tbml/config.json)" name:"config" optional:"" type:"path"`
+	Debug      bool   `help:"Print debug output and stack traces of errors (stack traces can also be enabled with TBML_TRACE=1)"`
+	LogFormat  string `help:"Format of log output (${enum})" default:"text" enum:"text,json" name:"log-format"`
+	Quiet      bool   `help:"Only print errors" short:"q" xor:"verbosity"`
+	Verbose    int    `help:"Print more output; can be repeated (-v: info, -vv: debug)" short:"v" type:"counter" xor:"verbosity"`
 
 	Open OpenCmd `cmd:"" default:"1" help:"Open a new tab (default if no arguments are given)"`
 
 	Ls LsCmd `cmd:"" help:"List profiles, profile instances and topics"`
 
 	Rm RmCmd `cmd:"" help:"Delete an instance of a profile"`
+
+	Logs LogsCmd `cmd:"" help:"Show the log of an instance"`
+
+	Downloads DownloadsCmd `cmd:"" help:"Print or open the downloads directory of a topic"`
+
+	Remote RemoteCmd `cmd:"" help:"Control the browser of an open topic through Mothership"`
+
+	Extensions ExtensionsCmd `cmd:"" help:"Show the configured extensions and which instances have outdated versions installed"`
+
+	Config ConfigCmd `cmd:"" help:"Inspect the configuration"`
+
+	Daemon DaemonCmd `cmd:"" help:"Run a daemon that starts and supervises instances, so that \"open\" returns immediately; the configuration is read again for every instance it starts"`
+
+	InstallDesktop InstallDesktopCmd `cmd:"" help:"Install (or uninstall) a desktop entry that registers tbml as a web browser" name:"install-desktop"`
 }
 
 type CommandContext struct {
@@ -31,26 +52,75 @@ type CommandContext struct {
 }
 
 func Run(args []string) error {
+	if os.Getenv(detachedForkEnvVar) != "" {
+		return forkDetached(args)
+	}
+
 	kctx, err := kong.Must(&CLI).Parse(args[1:])
 	if err != nil {
 		return uerror.WithStackTrace(err)
 	}
 
+	deprecatedOpenDebug := useDeprecatedOpenDebug(kctx)
+	configureLogging()
+	if deprecatedOpenDebug {
+		ulog.Warnf("\"open --debug\" is deprecated; use \"open --debug-shell\" for a debug shell or \"--debug open\" for debug output")
+	}
+
 	config, configDir, err := loadConfig(CLI.ConfigPath)
 	if err != nil {
-		return uerror.WithStackTrace(err)
+		return classifyError(uerror.WithStackTrace(err))
 	}
 
-	return kctx.Run(CommandContext{
+	return classifyError(kctx.Run(CommandContext{
 		Config:    config,
 		ConfigDir: configDir,
 		Context:   context.Background(),
-	})
+	}))
+}
+
+// useDeprecatedOpenDebug makes a --debug flag that is given after the
+// open command open a debug shell instead of enabling debug output, as
+// it did before the flag was renamed to --debug-shell. It returns
+// whether it did.
+func useDeprecatedOpenDebug(kctx *kong.Context) bool {
+	afterOpen := false
+	for _, path := range kctx.Path {
+		if path.Command != nil && path.Command.Name == "open" {
+			afterOpen = true
+		}
+		if afterOpen && path.Flag != nil && path.Flag.Name == "debug" {
+			CLI.Debug = false
+			CLI.Open.DebugShell = true
+			return true
+		}
+	}
+	return false
+}
+
+func configureLogging() {
+	verbosity := CLI.Verbose
+	if CLI.Quiet {
+		verbosity = -1
+	}
+	if CLI.Debug {
+		verbosity = 2
+	}
+	ulog.Default().SetLevel(ulog.LevelFromVerbosity(verbosity))
+	ulog.Default().SetFormat(ulog.Format(CLI.LogFormat))
+
+	// Processes started by tbml, like the Mothership connector in the
+	// browser, pick this up.
+	ulog.ExportToEnv()
 }
 
 func loadConfig(cliPath string) (internal.Configuration, string, error) {
 	if cliPath != "" {
-		return internal.ReadConfiguration(cliPath)
+		config, configDir, err := internal.ReadConfiguration(cliPath)
+		if errors.Is(err, fs.ErrNotExist) {
+			return internal.Configuration{}, "", userError(CodeNoConfig, fmt.Sprintf("Config file %s does not exist", cliPath), "", uerror.WithStackTrace(err))
+		}
+		return config, configDir, err
 	}
 
 	home, err := os.UserHomeDir()
@@ -75,5 +145,5 @@ func loadConfig(cliPath string) (internal.Configuration, string, error) {
 		return internal.ReadConfiguration(etcConfigFile)
 	}
 
-	return internal.Configuration{}, "", uerror.WithStackTrace(ErrNoConfig)
+	return internal.Configuration{}, "", userError(CodeNoConfig, "", fmt.Sprintf("Create %s or pass the path of a config file with --config", homeConfigFile), uerror.WithStackTrace(ErrNoConfig))
 }
@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"fmt"
+
+	"t0ast.cc/tbml/internal"
+)
+
+type ConfigCmd struct {
+	Check ConfigCheckCmd `cmd:"" help:"Check the configuration for problems, like missing files"`
+}
+
+type ConfigCheckCmd struct{}
+
+func (cmd *ConfigCheckCmd) Run(common CommandContext) error {
+	problems := internal.ValidateConfiguration(common.Config, common.ConfigDir)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		return userError(CodeInvalidConfig, fmt.Sprintf("Found %d problem(s) in the configuration", len(problems)), "", nil)
+	}
+	fmt.Println("No problems found")
+	return nil
+}
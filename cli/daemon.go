@@ -0,0 +1,62 @@
+package cli
+
+import (
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"t0ast.cc/tbml/internal"
+	uerror "t0ast.cc/tbml/util/error"
+)
+
+type DaemonCmd struct{}
+
+func (cmd *DaemonCmd) Run(common CommandContext) error {
+	ctx, stop := signal.NotifyContext(common.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return uerror.WithStackTrace(internal.RunDaemon(ctx, func() (internal.Configuration, string, error) {
+		return loadConfig(CLI.ConfigPath)
+	}))
+}
+
+// getDaemonInstances returns the instances that the daemon supervises,
+// by instance label. It returns nil if no daemon is running.
+func getDaemonInstances() (map[string]internal.DaemonInstance, error) {
+	conn, err := internal.ConnectToDaemon()
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	if conn == nil {
+		return nil, nil
+	}
+	defer conn.Close()
+
+	instances, err := internal.ListDaemonInstances(conn)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	instancesByLabel := make(map[string]internal.DaemonInstance)
+	for _, instance := range instances {
+		instancesByLabel[instance.InstanceLabel] = instance
+	}
+	return instancesByLabel, nil
+}
+
+// startThroughDaemon starts an instance through the daemon if one is
+// running. It returns false if no daemon is running.
+func startThroughDaemon(profileLabel, topic string, startURLs []*url.URL) (bool, error) {
+	conn, err := internal.ConnectToDaemon()
+	if err != nil {
+		return false, uerror.WithStackTrace(err)
+	}
+	if conn == nil {
+		return false, nil
+	}
+	defer conn.Close()
+
+	if _, err := internal.StartInstanceThroughDaemon(conn, profileLabel, topic, startURLs); err != nil {
+		return true, uerror.WithStackTrace(err)
+	}
+	return true, nil
+}
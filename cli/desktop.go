@@ -0,0 +1,49 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"t0ast.cc/tbml/internal"
+	uerror "t0ast.cc/tbml/util/error"
+)
+
+type InstallDesktopCmd struct {
+	Actions    bool `help:"Add a desktop action for every configured profile"`
+	SetDefault bool `help:"Register tbml as the desktop's default web browser" name:"set-default"`
+	Uninstall  bool `help:"Remove the desktop entry instead of installing it"`
+}
+
+func (cmd *InstallDesktopCmd) Run(common CommandContext) error {
+	if cmd.Uninstall {
+		entryPath, err := internal.UninstallDesktopEntry()
+		if err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		fmt.Println("Removed", entryPath)
+		return nil
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	command := []string{executable}
+	if CLI.ConfigPath != "" {
+		command = append(command, "--config", CLI.ConfigPath)
+	}
+
+	entryPath, err := internal.InstallDesktopEntry(common.Config, command, cmd.Actions)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	fmt.Println("Installed", entryPath)
+
+	if cmd.SetDefault {
+		if err := internal.SetDefaultBrowser(); err != nil {
+			return uerror.WithStackTrace(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,190 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"t0ast.cc/tbml/internal"
+	uerror "t0ast.cc/tbml/util/error"
+	ulog "t0ast.cc/tbml/util/log"
+)
+
+// detachedReadyFDEnvVar is set for the background process started by
+// "open --detach". It holds the file descriptor that the background
+// process signals readiness on.
+const detachedReadyFDEnvVar = "TBML_DETACHED_READY_FD"
+
+// detachedForkEnvVar is set for the intermediate process started by
+// "open --detach", which starts the background process and exits.
+const detachedForkEnvVar = "TBML_DETACHED_FORK"
+
+var errDetachedNotReady error = errors.New("Detached tbml process exited before the instance was ready")
+
+// startDetached starts tbml in the background to open the given topic
+// and waits until the instance is ready. Like a daemon, the background
+// process is started by an intermediate process in a new session
+// (see forkDetached), so it survives the caller (and its terminal)
+// exiting and can't acquire a controlling terminal.
+func startDetached(profileLabel, topic string, cmd *OpenCmd) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+
+	args := []string{}
+	if CLI.ConfigPath != "" {
+		args = append(args, "--config", CLI.ConfigPath)
+	}
+	// The session was already restored or discarded by the caller,
+	// so the detached process doesn't ask again.
+	session := cmd.Session
+	if session == "ask" {
+		session = "discard"
+	}
+	args = append(args, "open", "--topic", topic, "--profile", profileLabel, "--no-rules", "--no-daemon", "--session", session)
+	if cmd.URL != nil {
+		args = append(args, cmd.URL.String())
+	}
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	defer readyR.Close()
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	defer stderrR.Close()
+
+	detachedCmd := exec.Command(executable, args...)
+	detachedCmd.Env = append(os.Environ(), fmt.Sprint(detachedReadyFDEnvVar, "=3"), fmt.Sprint(detachedForkEnvVar, "=1"))
+	detachedCmd.ExtraFiles = []*os.File{readyW}
+	detachedCmd.Stderr = stderrW
+	detachedCmd.SysProcAttr = &syscall.SysProcAttr{
+		Setsid: true,
+	}
+	err = detachedCmd.Start()
+	readyW.Close()
+	stderrW.Close()
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+
+	stderrBuf := bytes.Buffer{}
+	stderrCopied := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(&stderrBuf, stderrR)
+		close(stderrCopied)
+	}()
+
+	// The intermediate process exits right after starting the
+	// background process.
+	forkErr := detachedCmd.Wait()
+
+	ready := make([]byte, 1)
+	if n, _ := readyR.Read(ready); n == 1 && forkErr == nil {
+		return nil
+	}
+
+	// The detached process exited before it became ready, so
+	// whatever it printed is the reason.
+	_ = stderrR.SetReadDeadline(time.Now().Add(time.Second))
+	<-stderrCopied
+	return uerror.StackTracef("%w:\n%s", errDetachedNotReady, strings.TrimSpace(stderrBuf.String()))
+}
+
+// forkDetached is run by the intermediate process of "open --detach".
+// It starts the background process with the same arguments and exits
+// without waiting for it. The background process is thereby orphaned
+// and, not being a session leader, can't acquire a controlling
+// terminal. The ready pipe and stderr are passed on to it.
+func forkDetached(args []string) error {
+	os.Unsetenv(detachedForkEnvVar)
+	executable, err := os.Executable()
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	readyFDStr := os.Getenv(detachedReadyFDEnvVar)
+	readyFD, err := strconv.Atoi(readyFDStr)
+	if err != nil {
+		return uerror.StackTracef("Invalid %s: %w", detachedReadyFDEnvVar, err)
+	}
+
+	detachedCmd := exec.Command(executable, args[1:]...)
+	detachedCmd.ExtraFiles = []*os.File{os.NewFile(uintptr(readyFD), "detached-ready")}
+	detachedCmd.Stderr = os.Stderr
+	if err := detachedCmd.Start(); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	return uerror.WithStackTrace(detachedCmd.Process.Release())
+}
+
+// getDetachedReadyNotifier returns a function that signals readiness
+// to the process that started this one through "open --detach". It
+// redirects this process' stdout, stderr and log output to the
+// instance's rotating log file before doing so. If this process wasn't
+// started detached, nil is returned.
+func getDetachedReadyNotifier(config internal.Configuration, instance internal.ProfileInstance) (notify func(), isDetached bool, err error) {
+	readyFDStr, ok := os.LookupEnv(detachedReadyFDEnvVar)
+	if !ok {
+		return nil, false, nil
+	}
+	os.Unsetenv(detachedReadyFDEnvVar)
+	readyFD, err := strconv.Atoi(readyFDStr)
+	if err != nil {
+		return nil, false, uerror.StackTracef("Invalid %s: %w", detachedReadyFDEnvVar, err)
+	}
+	// The processes that this one starts, like bindfs, must not
+	// inherit the ready pipe. The caller would wait for them to exit
+	// if this process exited before it was ready.
+	syscall.CloseOnExec(readyFD)
+	readyFile := os.NewFile(uintptr(readyFD), "detached-ready")
+
+	return func() {
+		defer readyFile.Close()
+
+		// The log file stays open until this process exits.
+		logFile, err := internal.OpenInstanceLog(config, instance)
+		if err != nil {
+			ulog.Errorf("Failed to open instance log: %v", err)
+			return
+		}
+		if err := redirectOutput(logFile); err != nil {
+			ulog.Errorf("Failed to redirect output to instance log: %v", err)
+			return
+		}
+
+		_, _ = readyFile.Write([]byte{1})
+	}, true, nil
+}
+
+// redirectOutput writes log messages to the given writer and
+// redirects stdout and stderr to it through a pipe. Since the pipe is
+// drained asynchronously, log messages are written directly instead,
+// so that they aren't lost when the process exits.
+func redirectOutput(out io.Writer) error {
+	outputR, outputW, err := os.Pipe()
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	defer outputW.Close()
+	for _, fd := range []int{1, 2} {
+		if err := syscall.Dup3(int(outputW.Fd()), fd, 0); err != nil {
+			return uerror.WithStackTrace(err)
+		}
+	}
+	go func() {
+		_, _ = io.Copy(out, outputR)
+	}()
+	ulog.Default().SetOutput(out)
+	return nil
+}
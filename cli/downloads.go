@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+
+	"t0ast.cc/tbml/internal"
+	uerror "t0ast.cc/tbml/util/error"
+	uio "t0ast.cc/tbml/util/io"
+)
+
+type DownloadsCmd struct {
+	Topic   string `help:"The topic whose downloads directory to show" long:"topic" required:"" short:"t"`
+	Profile string `help:"The profile to look up the downloads directory in if the topic is not open" long:"profile" short:"p"`
+	Open    bool   `help:"Open the directory with xdg-open instead of printing it"`
+}
+
+func (cmd *DownloadsCmd) Run(common CommandContext) error {
+	instances, err := internal.GetProfileInstances(common.Config)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+
+	var profile *internal.ProfileConfiguration
+	instance := internal.ProfileInstance{}
+	if topicInstance := internal.FindInstanceByTopic(instances, cmd.Topic); topicInstance != nil {
+		instance = *topicInstance
+		profile = internal.FindProfileByLabel(common.Config, instance.ProfileLabel)
+		if profile == nil {
+			return userError(CodeUnknownProfile, fmt.Sprintf("Profile %s of instance %s does not exist anymore", instance.ProfileLabel, instance.InstanceLabel), "", nil)
+		}
+	} else {
+		if cmd.Profile == "" {
+			return userError(CodeTopicNotOpen, fmt.Sprintf("Topic %s is not open", cmd.Topic), "Use --profile to look up the downloads directory of a topic that is not open", nil)
+		}
+		profile = internal.FindProfileByLabel(common.Config, cmd.Profile)
+		if profile == nil {
+			return userError(CodeUnknownProfile, fmt.Sprintf("Profile %s does not exist", cmd.Profile), "Run \"tbml ls\" to list the configured profiles", nil)
+		}
+		if profile.Downloads == "" || profile.Downloads == internal.DownloadsInstance {
+			return userError(CodeTopicNotOpen, fmt.Sprintf("Topic %s is not open", cmd.Topic), fmt.Sprintf("Profile %s keeps downloads per instance; use \"tbml ls\" to find the instance and look in its Downloads directory", profile.Label), nil)
+		}
+	}
+
+	downloadsDir, err := internal.GetDownloadsDir(common.Config, *profile, instance, cmd.Topic, common.ConfigDir)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+
+	if !cmd.Open {
+		fmt.Println(downloadsDir)
+		return nil
+	}
+
+	if err := os.MkdirAll(downloadsDir, uio.FileModeURWXGRWXO); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	openCmd := exec.Command("xdg-open", downloadsDir)
+	openCmd.Stdout = os.Stdout
+	openCmd.Stderr = os.Stderr
+	return uerror.WithStackTrace(openCmd.Run())
+}
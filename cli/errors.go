@@ -0,0 +1,131 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"t0ast.cc/tbml/internal"
+	uerror "t0ast.cc/tbml/util/error"
+	ulog "t0ast.cc/tbml/util/log"
+)
+
+// traceEnvVar enables printing stack traces of errors, like --debug.
+const traceEnvVar = "TBML_TRACE"
+
+// Error codes are part of tbml's interface: scripts may depend on
+// them and on the exit codes they map to, so existing codes must not
+// change. Exit codes of the browser are passed through as they are
+// and may overlap with these.
+const (
+	CodeCancelled      uerror.Code = "cancelled"
+	CodeDaemonRunning  uerror.Code = "daemon-running"
+	CodeInstanceInUse  uerror.Code = "instance-in-use"
+	CodeInvalidConfig  uerror.Code = "invalid-config"
+	CodeMissingFile    uerror.Code = "missing-file"
+	CodeNoConfig       uerror.Code = "no-config"
+	CodeTopicInUse     uerror.Code = "topic-in-use"
+	CodeTopicNotOpen   uerror.Code = "topic-not-open"
+	CodeUnknownProfile uerror.Code = "unknown-profile"
+)
+
+var exitCodes = map[uerror.Code]uint{
+	CodeNoConfig:       10,
+	CodeInvalidConfig:  11,
+	CodeMissingFile:    12,
+	CodeUnknownProfile: 13,
+	CodeCancelled:      14,
+	CodeTopicNotOpen:   15,
+	CodeTopicInUse:     16,
+	CodeInstanceInUse:  17,
+	CodeDaemonRunning:  18,
+}
+
+// knownErrors maps errors from the internal package to codes and
+// hints, for errors that are not turned into user errors where they
+// occur.
+var knownErrors = []struct {
+	code   uerror.Code
+	hint   string
+	target error
+}{
+	{
+		code:   CodeInvalidConfig,
+		hint:   "Fix the configuration file and run \"tbml config check\" to look for further problems",
+		target: internal.ErrInvalidConfiguration,
+	},
+	{
+		code:   CodeInstanceInUse,
+		hint:   "Close the browser of the instance first",
+		target: internal.ErrInstanceInUse,
+	},
+	{
+		code:   CodeTopicInUse,
+		hint:   "Run \"tbml open -t <topic>\" to open a tab in the running instance",
+		target: internal.ErrTopicInUse,
+	},
+	{
+		code:   CodeDaemonRunning,
+		hint:   "Stop the running daemon first",
+		target: internal.ErrDaemonRunning,
+	},
+}
+
+// userError creates an error with the given code and the exit code
+// that belongs to it.
+func userError(code uerror.Code, message, hint string, err error) error {
+	return uerror.WithExitCode(exitCodes[code], uerror.WithUserMessage(code, message, hint, err))
+}
+
+// classifyError turns known internal errors into user errors.
+func classifyError(err error) error {
+	if _, isUserErr := uerror.GetUserError(err); isUserErr {
+		return err
+	}
+	for _, knownErr := range knownErrors {
+		if errors.Is(err, knownErr.target) {
+			return userError(knownErr.code, "", knownErr.hint, err)
+		}
+	}
+	return err
+}
+
+// missingFileError turns errors about missing files into user errors
+// that point to "tbml config check", since referenced files are the
+// usual culprit.
+func missingFileError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return userError(CodeMissingFile, "", "Run \"tbml config check\" to find missing files referenced in the configuration", err)
+	}
+	return err
+}
+
+// ExitCode returns the exit code that tbml exits with after the error.
+func ExitCode(err error) int {
+	if exitCode, hasExitCode := uerror.GetExitCode(err); hasExitCode {
+		return int(exitCode)
+	}
+	return 1
+}
+
+// ReportError prints the error for the user. Stack traces are only
+// printed with --debug or if TBML_TRACE=1 is set.
+func ReportError(err error) {
+	msg := err.Error()
+	if userErr, isUserErr := uerror.GetUserError(err); isUserErr {
+		msg = fmt.Sprintf("Error [%s]: %s", userErr.Code, userErr.Error())
+		if userErr.Hint != "" {
+			msg = fmt.Sprintf("%s\nHint: %s", msg, userErr.Hint)
+		}
+	}
+	ulog.Errorf("%s", msg)
+
+	if stackTrace, hasStackTrace := uerror.GetStackTrace(err); hasStackTrace && isTraceEnabled() {
+		ulog.Errorf("Stack trace:\n%s", stackTrace)
+	}
+}
+
+func isTraceEnabled() bool {
+	return CLI.Debug || os.Getenv(traceEnvVar) == "1"
+}
@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"t0ast.cc/tbml/internal"
+	uerror "t0ast.cc/tbml/util/error"
+)
+
+type ExtensionsCmd struct {
+	Ls ExtensionsLsCmd `cmd:"" default:"1" help:"Show the configured extensions and which instances have outdated versions installed (default)"`
+
+	Import ExtensionsImportCmd `cmd:"" help:"Copy extensions into the extension cache and print how profiles refer to them in their Extensions"`
+}
+
+type ExtensionsLsCmd struct {
+	Profile  string `help:"Only show the extensions of this profile" long:"profile" short:"p"`
+	Outdated bool   `help:"Only show instances that don't have the configured version of an extension installed"`
+}
+
+func (cmd *ExtensionsLsCmd) Run(common CommandContext) error {
+	profiles := common.Config.Profiles
+	if cmd.Profile != "" {
+		profile := internal.FindProfileByLabel(common.Config, cmd.Profile)
+		if profile == nil {
+			return userError(CodeUnknownProfile, fmt.Sprintf("Profile %s does not exist", cmd.Profile), "Run \"tbml ls\" to list the configured profiles", nil)
+		}
+		profiles = []internal.ProfileConfiguration{*profile}
+	}
+
+	instances, err := internal.GetProfileInstances(common.Config)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+
+	sb := strings.Builder{}
+	anyOutdated := false
+	for _, profile := range profiles {
+		if len(profile.ExtensionFiles) == 0 && len(profile.Extensions) == 0 {
+			continue
+		}
+		configured, err := internal.GetConfiguredExtensions(common.Config, profile, common.ConfigDir)
+		if err != nil {
+			return uerror.WithStackTrace(err)
+		}
+
+		profileSB := strings.Builder{}
+		for i, extension := range configured {
+			extensionSB := strings.Builder{}
+			for _, instance := range instances {
+				if instance.ProfileLabel != profile.Label {
+					continue
+				}
+				status := internal.GetExtensionStatuses(configured, instance)[i]
+				if cmd.Outdated && !status.IsOutdated() {
+					continue
+				}
+				extensionSB.WriteString(fmt.Sprintf("\n    %s: ", instance.InstanceLabel))
+				if status.Installed == nil {
+					extensionSB.WriteString("not installed")
+				} else {
+					extensionSB.WriteString(formatExtensionVersion(status.Installed.Version))
+				}
+				if status.IsOutdated() {
+					extensionSB.WriteString(" (outdated)")
+					anyOutdated = true
+				}
+			}
+			if cmd.Outdated && extensionSB.Len() == 0 {
+				continue
+			}
+			profileSB.WriteString(fmt.Sprintf("\n  %s %s (%s)", extension.ID, formatExtensionVersion(extension.Version), filepath.Base(extension.File)))
+			profileSB.WriteString(extensionSB.String())
+		}
+		if profileSB.Len() > 0 {
+			if sb.Len() > 0 {
+				sb.WriteString("\n")
+			}
+			sb.WriteString(profile.Label)
+			sb.WriteString(profileSB.String())
+		}
+	}
+
+	if anyOutdated {
+		sb.WriteString("\n\nOutdated extensions are updated when their instance is started the next time")
+	}
+	if sb.Len() > 0 {
+		fmt.Println(sb.String())
+	}
+	return nil
+}
+
+func formatExtensionVersion(version string) string {
+	if version == "" {
+		return "<unknown version>"
+	}
+	return version
+}
+
+type ExtensionsImportCmd struct {
+	Files []string `arg:"" help:"The XPI files to import" type:"existingfile"`
+}
+
+func (cmd *ExtensionsImportCmd) Run(common CommandContext) error {
+	extensions := []internal.Extension{}
+	for _, file := range cmd.Files {
+		extension, err := internal.ImportExtension(common.Config, file)
+		if err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		extensions = append(extensions, extension)
+	}
+
+	extensionsBytes, err := json.MarshalIndent(extensions, "", "\t")
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	fmt.Println(string(extensionsBytes))
+	return nil
+}
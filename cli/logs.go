@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"t0ast.cc/tbml/internal"
+	uerror "t0ast.cc/tbml/util/error"
+	uio "t0ast.cc/tbml/util/io"
+)
+
+type LogsCmd struct {
+	Topic    string `help:"The topic whose instance's log to show" long:"topic" short:"t" xor:"target"`
+	Instance string `help:"The label of the instance whose log to show (also works for instances that are not running)" long:"instance" short:"i" xor:"target"`
+	Follow   bool   `help:"Keep printing output as it is appended to the log" short:"f"`
+}
+
+func (cmd *LogsCmd) Run(common CommandContext) error {
+	var instance internal.ProfileInstance
+	switch {
+	case cmd.Topic != "":
+		instances, err := internal.GetProfileInstances(common.Config)
+		if err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		topicInstance := internal.FindInstanceByTopic(instances, cmd.Topic)
+		if topicInstance == nil {
+			return userError(CodeTopicNotOpen, fmt.Sprintf("Topic %s is not open", cmd.Topic), "Use --instance to show the log of an instance that is not running", nil)
+		}
+		instance = *topicInstance
+	case cmd.Instance != "":
+		i, err := internal.GetProfileInstance(common.Config, cmd.Instance)
+		if err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		instance = i
+	default:
+		return errors.New("Either a topic or an instance is required")
+	}
+
+	logPath := internal.GetInstanceLogPath(common.Config, instance)
+
+	if cmd.Follow {
+		ctx, stop := signal.NotifyContext(common.Context, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return uerror.WithStackTrace(uio.Follow(ctx, logPath, os.Stdout, 250*time.Millisecond))
+	}
+
+	logFile, err := os.Open(logPath)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	defer logFile.Close()
+	if _, err := io.Copy(os.Stdout, logFile); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	return nil
+}
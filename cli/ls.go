@@ -10,6 +10,7 @@ import (
 
 	"t0ast.cc/tbml/internal"
 	uerror "t0ast.cc/tbml/util/error"
+	ulog "t0ast.cc/tbml/util/log"
 )
 
 type LsCmd struct{}
@@ -51,14 +52,16 @@ func (cmd *LsCmd) Run(common CommandContext) error {
 			sb.WriteString("YES")
 		}
 
-		if len(profile.ExtensionFiles) > 0 {
+		extensionNames := []string{}
+		for _, extensionFile := range profile.ExtensionFiles {
+			extensionNames = append(extensionNames, filepath.Base(extensionFile))
+		}
+		for _, extension := range profile.Extensions {
+			extensionNames = append(extensionNames, extension.ID)
+		}
+		if len(extensionNames) > 0 {
 			sb.WriteString("; ")
-			for i, extensionFile := range profile.ExtensionFiles {
-				sb.WriteString(filepath.Base(extensionFile))
-				if i < len(profile.ExtensionFiles)-1 {
-					sb.WriteString(", ")
-				}
-			}
+			sb.WriteString(strings.Join(extensionNames, ", "))
 		}
 
 		sb.WriteString(")")
@@ -74,12 +77,18 @@ func (cmd *LsCmd) Run(common CommandContext) error {
 
 		instances, ok := instancesPerProfile[profile.Label]
 		if ok {
+			configuredExtensions, err := internal.GetConfiguredExtensions(common.Config, profile, common.ConfigDir)
+			if err != nil {
+				ulog.Warnf("Can't check the extensions of profile %s: %v", profile.Label, err)
+			}
+
 			sb.WriteString("\n  │   ")
 			writeColumn("Instance", 15)
 			writeColumn("Cur. Topic", 15)
 			writeColumn("Cur. PID", 15)
 			writeColumn("Created", 20)
 			writeColumn("Last used", 20)
+			writeColumn("Extensions", 15)
 
 			for i, instance := range instances {
 				sb.WriteString("\n  ")
@@ -102,10 +111,73 @@ func (cmd *LsCmd) Run(common CommandContext) error {
 				}
 				writeColumn(instance.Created.Format(time.Stamp), 20)
 				writeColumn(instance.LastUsed.Format(time.Stamp), 20)
+				switch {
+				case len(profile.ExtensionFiles) == 0 && len(profile.Extensions) == 0:
+					writeColumn("<none>", 15)
+				case configuredExtensions == nil:
+					writeColumn("?", 15)
+				default:
+					outdated := 0
+					for _, status := range internal.GetExtensionStatuses(configuredExtensions, instance) {
+						if status.IsOutdated() {
+							outdated++
+						}
+					}
+					if outdated == 0 {
+						writeColumn("up to date", 15)
+					} else {
+						writeColumn(fmt.Sprintf("%d outdated", outdated), 15)
+					}
+				}
 			}
 		}
 	}
 
+	bundleUsages, err := internal.GetSharedBundleUsage(common.Config, instances)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	if len(bundleUsages) > 0 {
+		var saved int64
+		sb.WriteString("\n\nShared Tor Browser bundles")
+		for _, usage := range bundleUsages {
+			sb.WriteString(fmt.Sprintf("\n  %s (%s; used by %s)", usage.Name, formatSize(usage.Size), strings.Join(usage.Instances, ", ")))
+			saved += usage.SpaceSaved()
+		}
+		sb.WriteString(fmt.Sprintf("\nSharing bundles saves %s", formatSize(saved)))
+	}
+
+	daemonInstances, err := getDaemonInstances()
+	if err != nil {
+		ulog.Warnf("Can't list the instances of the tbml daemon: %v", err)
+	}
+	if daemonInstances != nil {
+		labels := make([]string, 0, len(daemonInstances))
+		for label := range daemonInstances {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		sb.WriteString(fmt.Sprintf("\n\ntbml daemon supervises %d instances", len(labels)))
+		for _, label := range labels {
+			instance := daemonInstances[label]
+			sb.WriteString(fmt.Sprintf("\n  %s (%s; topic %s; started %s)", instance.InstanceLabel, instance.ProfileLabel, instance.Topic, instance.Started.Format(time.Stamp)))
+		}
+	}
+
 	fmt.Println(sb.String())
 	return nil
 }
+
+// formatSize formats a number of bytes with a binary unit prefix.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -9,13 +9,18 @@ import (
 	"t0ast.cc/tbml/gui"
 	"t0ast.cc/tbml/internal"
 	uerror "t0ast.cc/tbml/util/error"
+	ulog "t0ast.cc/tbml/util/log"
 )
 
 type OpenCmd struct {
-	Topic   string   `help:"The topic to open the new tab in" long:"topic" short:"t"`
-	Profile string   `help:"The profile to use for opening a new topic; has no effect when not opening a new topic" long:"profile" short:"p"`
-	Debug   bool     `help:"Open a debug shell instead of a browser tab"`
-	URL     *url.URL `arg:"" help:"A URL to load instead of the new tab page" name:"url" optional:""`
+	Topic      string   `help:"The topic to open the new tab in" long:"topic" short:"t"`
+	Profile    string   `help:"The profile to use for opening a new topic; has no effect when not opening a new topic" long:"profile" short:"p"`
+	DebugShell bool     `help:"Open a debug shell instead of a browser tab (formerly --debug, which still works after \"open\" but is deprecated)" name:"debug-shell"`
+	Detach     bool     `help:"Start the browser in the background and return as soon as it is ready; its output is written to the instance's log file"`
+	NoDaemon   bool     `help:"Start the browser in the foreground even if a tbml daemon is running" name:"no-daemon"`
+	NoRules    bool     `help:"Do not use the configured routing rules to pick a topic and profile for the URL" name:"no-rules"`
+	Session    string   `help:"Whether to reopen the tabs of the topic's last session when starting a new instance for it, for profiles with SaveSession: ask, restore or discard" default:"ask" enum:"ask,restore,discard"`
+	URL        *url.URL `arg:"" help:"A URL to load instead of the new tab page" name:"url" optional:""`
 }
 
 func (cmd *OpenCmd) Run(ctx CommandContext) error {
@@ -24,6 +29,19 @@ func (cmd *OpenCmd) Run(ctx CommandContext) error {
 		return err
 	}
 
+	if cmd.Topic == "" && cmd.URL != nil && !cmd.NoRules {
+		rule, err := internal.FindRoutingRule(ctx.Config, cmd.URL)
+		if err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		if rule != nil {
+			cmd.Topic = rule.Topic
+			if cmd.Profile == "" {
+				cmd.Profile = rule.Profile
+			}
+		}
+	}
+
 	if cmd.Topic == "" {
 		topics := internal.GetTopics(instances)
 		topic, err := gui.Prompt(ctx.Context, topics, "Topic", false)
@@ -31,7 +49,7 @@ func (cmd *OpenCmd) Run(ctx CommandContext) error {
 			return uerror.WithStackTrace(err)
 		}
 		if topic == nil || len(strings.TrimSpace(*topic)) == 0 {
-			return errors.New("No topic selected")
+			return userError(CodeCancelled, "No topic selected", "", nil)
 		}
 		cmd.Topic = *topic
 	}
@@ -59,25 +77,95 @@ func (cmd *OpenCmd) Run(ctx CommandContext) error {
 			return uerror.WithStackTrace(err)
 		}
 		if profile == nil || len(strings.TrimSpace(*profile)) == 0 {
-			return errors.New("No profile selected")
+			return userError(CodeCancelled, "No profile selected", "", nil)
 		}
 		cmd.Profile = *profile
 	}
 
 	profile := internal.FindProfileByLabel(ctx.Config, cmd.Profile)
 	if profile == nil {
-		return fmt.Errorf("Profile %s does not exist", cmd.Profile)
+		return userError(CodeUnknownProfile, fmt.Sprintf("Profile %s does not exist", cmd.Profile), "Run \"tbml ls\" to list the configured profiles", nil)
+	}
+
+	startURLs := []*url.URL{}
+	if profile.SaveSession && !cmd.DebugShell {
+		sessionURLs, err := cmd.getSessionURLs(ctx, profile.Label, instances)
+		if err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		startURLs = append(startURLs, sessionURLs...)
+	}
+	if cmd.URL != nil {
+		startURLs = append(startURLs, cmd.URL)
+	}
+
+	if !cmd.DebugShell && !cmd.NoDaemon {
+		startedThroughDaemon, err := startThroughDaemon(profile.Label, cmd.Topic, startURLs)
+		if err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		if startedThroughDaemon {
+			return nil
+		}
+	}
+
+	if cmd.Detach {
+		if cmd.DebugShell {
+			return errors.New("--detach can't be used together with --debug-shell")
+		}
+		return startDetached(profile.Label, cmd.Topic, cmd)
 	}
 
 	bestInstance := internal.GetBestInstance(*profile, instances)
-	fmt.Println("Best:", bestInstance.InstanceLabel)
+	ulog.Infof("Starting instance %s for topic %s", bestInstance.InstanceLabel, cmd.Topic)
 
 	bestInstance.UsageLabel = &cmd.Topic
 
-	exitCode, err := internal.StartInstance(ctx.Context, ctx.Config, *profile, bestInstance, instances, ctx.ConfigDir, cmd.URL, cmd.Debug)
+	onReady, detached, err := getDetachedReadyNotifier(ctx.Config, bestInstance)
 	if err != nil {
-		return uerror.WithExitCode(exitCode, uerror.WithStackTrace(err))
+		return uerror.WithStackTrace(err)
+	}
+
+	exitCode, err := internal.StartInstance(ctx.Context, ctx.Config, *profile, bestInstance, instances, ctx.ConfigDir, startURLs, cmd.DebugShell, detached, onReady)
+	if err != nil {
+		err = missingFileError(uerror.WithStackTrace(err))
+		// The exit code of a user error is more specific than the
+		// generic one that StartInstance returns with errors.
+		if _, hasExitCode := uerror.GetExitCode(err); hasExitCode {
+			return err
+		}
+		return uerror.WithExitCode(exitCode, err)
 	}
 
 	return nil
 }
+
+// getSessionURLs returns the URLs of the tabs of the topic's last
+// session in the profile if they should be reopened. Unless --session says otherwise,
+// the user is asked whether to reopen them.
+func (cmd *OpenCmd) getSessionURLs(ctx CommandContext, profileLabel string, instances []internal.ProfileInstance) ([]*url.URL, error) {
+	if cmd.Session == "discard" {
+		return nil, nil
+	}
+	session, err := internal.FindSession(ctx.Config, instances, profileLabel, cmd.Topic)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	if session == nil {
+		return nil, nil
+	}
+
+	if cmd.Session == "ask" {
+		restore := fmt.Sprintf("Reopen %d tabs from %s", len(session.Tabs), session.Saved.Format("2006-01-02 15:04"))
+		choice, err := gui.Prompt(ctx.Context, []string{restore, "Start without them"}, "Last session", true)
+		if err != nil {
+			return nil, uerror.WithStackTrace(err)
+		}
+		if choice == nil || *choice != restore {
+			cmd.Session = "discard"
+			return nil, nil
+		}
+		cmd.Session = "restore"
+	}
+	return session.StartURLs()
+}
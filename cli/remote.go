@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"t0ast.cc/tbml/internal"
+	uerror "t0ast.cc/tbml/util/error"
+)
+
+type RemoteCmd struct {
+	Tabs TabsCmd `cmd:"" default:"1" help:"List the open tabs (default)"`
+
+	CloseTab CloseTabCmd `cmd:"" help:"Close a tab" name:"close-tab"`
+
+	FocusTab FocusTabCmd `cmd:"" help:"Focus the window of a tab" name:"focus-tab"`
+
+	Version VersionCmd `cmd:"" help:"Show the versions of the browser and of Mothership"`
+
+	Ping PingCmd `cmd:"" help:"Check that Mothership in the browser responds"`
+}
+
+type TabsCmd struct {
+	Topic string `help:"The topic whose tabs to list" long:"topic" required:"" short:"t"`
+}
+
+func (cmd *TabsCmd) Run(common CommandContext) error {
+	tabs := []internal.Tab{}
+	if err := sendRemoteRequest(common, cmd.Topic, internal.CommandListTabs, nil, &tabs); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+
+	sb := strings.Builder{}
+	for _, tab := range tabs {
+		active := " "
+		if tab.Active {
+			active = "*"
+		}
+		sb.WriteString(fmt.Sprintf("%s %d %s\n    %s\n", active, tab.ID, tab.Title, tab.URL))
+	}
+	fmt.Print(sb.String())
+	return nil
+}
+
+type CloseTabCmd struct {
+	Topic string `help:"The topic to close the tab in" long:"topic" required:"" short:"t"`
+	TabID int    `arg:"" help:"The ID of the tab, as listed by \"tbml remote tabs\"" name:"tab-id"`
+}
+
+func (cmd *CloseTabCmd) Run(common CommandContext) error {
+	return sendRemoteRequest(common, cmd.Topic, internal.CommandCloseTab, internal.CloseTabArgs{TabID: cmd.TabID}, nil)
+}
+
+type FocusTabCmd struct {
+	Topic string `help:"The topic to focus the tab in" long:"topic" required:"" short:"t"`
+	TabID int    `arg:"" help:"The ID of the tab, as listed by \"tbml remote tabs\"" name:"tab-id"`
+}
+
+func (cmd *FocusTabCmd) Run(common CommandContext) error {
+	tabs := []internal.Tab{}
+	if err := sendRemoteRequest(common, cmd.Topic, internal.CommandListTabs, nil, &tabs); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	for _, tab := range tabs {
+		if tab.ID == cmd.TabID {
+			return sendRemoteRequest(common, cmd.Topic, internal.CommandFocusWindow, internal.FocusWindowArgs{WindowID: tab.WindowID}, nil)
+		}
+	}
+	return uerror.StackTracef("Topic %s has no tab %d", cmd.Topic, cmd.TabID)
+}
+
+type VersionCmd struct {
+	Topic string `help:"The topic whose browser to ask" long:"topic" required:"" short:"t"`
+}
+
+func (cmd *VersionCmd) Run(common CommandContext) error {
+	version := internal.Version{}
+	if err := sendRemoteRequest(common, cmd.Topic, internal.CommandGetVersion, nil, &version); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	fmt.Printf("Browser %s\nMothership %s\n", version.Browser, version.Extension)
+	return nil
+}
+
+type PingCmd struct {
+	Topic string `help:"The topic whose browser to ask" long:"topic" required:"" short:"t"`
+}
+
+func (cmd *PingCmd) Run(common CommandContext) error {
+	pong := ""
+	if err := sendRemoteRequest(common, cmd.Topic, internal.CommandPing, nil, &pong); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	fmt.Println(pong)
+	return nil
+}
+
+// sendRemoteRequest asks Mothership in the browser of the instance
+// that is used for the topic to run the command.
+func sendRemoteRequest(common CommandContext, topic string, command internal.Command, args, result interface{}) error {
+	instances, err := internal.GetProfileInstances(common.Config)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	instance := internal.FindInstanceByTopic(instances, topic)
+	if instance == nil {
+		return userError(CodeTopicNotOpen, fmt.Sprintf("Topic %s is not open", topic), "Run \"tbml ls\" to list the open topics", nil)
+	}
+
+	conn, err := internal.ConnectToExternalUnixSocket(common.Config, *instance)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	defer conn.Close()
+
+	return uerror.WithStackTrace(internal.SendRequest(conn, command, args, result))
+}
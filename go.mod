@@ -1,6 +1,6 @@
 module t0ast.cc/tbml
 
-go 1.17
+go 1.18
 
 require (
 	github.com/alecthomas/kong v0.2.17
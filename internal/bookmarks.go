@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"t0ast.cc/tbml/mothership-connector/com"
+	uerror "t0ast.cc/tbml/util/error"
+	ulog "t0ast.cc/tbml/util/log"
+)
+
+const bookmarksPollInterval = 2 * time.Second
+
+var ErrInvalidBookmarks error = errors.New("Invalid bookmarks file")
+
+var bookmarksHTMLTagPattern = regexp.MustCompile(`(?s)<(/?)([A-Za-z0-9]+)([^>]*)>`)
+var bookmarksHTMLHrefPattern = regexp.MustCompile(`(?i)\bHREF\s*=\s*"([^"]*)"`)
+
+// getBookmarksFilePath returns the path of the profile's bookmarks
+// file, or an empty string if it has none.
+func getBookmarksFilePath(profile ProfileConfiguration, configDir string) string {
+	if profile.BookmarksFile == nil {
+		return ""
+	}
+	if filepath.IsAbs(*profile.BookmarksFile) {
+		return *profile.BookmarksFile
+	}
+	return filepath.Join(configDir, *profile.BookmarksFile)
+}
+
+// readBookmarksFile reads a bookmarks file. It's either a JSON array
+// of bookmarks or an HTML file in the format that browsers export
+// bookmarks in.
+func readBookmarksFile(name string) ([]com.Bookmark, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+
+	var bookmarks []com.Bookmark
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(trimmed, &bookmarks); err != nil {
+			return nil, uerror.StackTracef("%w: %s: %v", ErrInvalidBookmarks, name, err)
+		}
+	} else {
+		bookmarks = parseBookmarksHTML(string(content))
+	}
+
+	if err := checkBookmarks(bookmarks); err != nil {
+		return nil, uerror.StackTracef("%w: %s: %v", ErrInvalidBookmarks, name, err)
+	}
+	return bookmarks, nil
+}
+
+// parseBookmarksHTML parses the Netscape bookmark file format. Folders
+// are <H3> headings followed by a <DL> list of their bookmarks, which
+// are <A> links. Everything else is ignored.
+func parseBookmarksHTML(content string) []com.Bookmark {
+	root := &com.Bookmark{}
+	folders := []*com.Bookmark{root}
+	var pendingFolder *com.Bookmark
+	var text strings.Builder
+	href := ""
+
+	current := func() *com.Bookmark {
+		return folders[len(folders)-1]
+	}
+
+	offset := 0
+	for _, match := range bookmarksHTMLTagPattern.FindAllStringSubmatchIndex(content, -1) {
+		text.WriteString(content[offset:match[0]])
+		offset = match[1]
+
+		closing := content[match[2]:match[3]] == "/"
+		tag := strings.ToUpper(content[match[4]:match[5]])
+		attributes := content[match[6]:match[7]]
+
+		switch {
+		case !closing && (tag == "H3" || tag == "A"):
+			text.Reset()
+			href = ""
+			if hrefMatch := bookmarksHTMLHrefPattern.FindStringSubmatch(attributes); hrefMatch != nil {
+				href = html.UnescapeString(hrefMatch[1])
+			}
+		case closing && tag == "H3":
+			pendingFolder = &com.Bookmark{
+				Title: strings.TrimSpace(html.UnescapeString(text.String())),
+			}
+		case closing && tag == "A":
+			current().Children = append(current().Children, com.Bookmark{
+				Title: strings.TrimSpace(html.UnescapeString(text.String())),
+				URL:   href,
+			})
+		case !closing && tag == "DL":
+			// The outermost list holds the top-level bookmarks.
+			if pendingFolder != nil {
+				folders = append(folders, pendingFolder)
+				pendingFolder = nil
+			} else {
+				folders = append(folders, current())
+			}
+		case closing && tag == "DL":
+			if len(folders) > 1 {
+				folder := current()
+				folders = folders[:len(folders)-1]
+				if folder != current() {
+					current().Children = append(current().Children, *folder)
+				}
+			}
+		}
+	}
+
+	return root.Children
+}
+
+// checkBookmarks checks that all bookmarks have absolute URLs and that
+// only folders have children.
+func checkBookmarks(bookmarks []com.Bookmark) error {
+	for _, bookmark := range bookmarks {
+		if bookmark.URL == "" {
+			if bookmark.Title == "" {
+				return errors.New("Folders need a title")
+			}
+			if err := checkBookmarks(bookmark.Children); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(bookmark.Children) > 0 {
+			return fmt.Errorf("Bookmark %s has children, but only folders can have them", bookmark.URL)
+		}
+		u, err := url.Parse(bookmark.URL)
+		if err != nil {
+			return err
+		}
+		if !u.IsAbs() {
+			return fmt.Errorf("Bookmark URL %s is not absolute", bookmark.URL)
+		}
+	}
+	return nil
+}
+
+// watchBookmarksFile sends the bookmarks in the file and then sends
+// them again whenever the file changes, until the context is
+// cancelled. If the changed file can't be read, the error is logged
+// and the bookmarks are left as they are.
+func watchBookmarksFile(ctx context.Context, name string) (<-chan []com.Bookmark, error) {
+	bookmarks, err := readBookmarksFile(name)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	stat, err := os.Stat(name)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+
+	updates := make(chan []com.Bookmark, 1)
+	updates <- bookmarks
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(bookmarksPollInterval):
+			}
+
+			newStat, err := os.Stat(name)
+			if err != nil || (newStat.ModTime().Equal(stat.ModTime()) && newStat.Size() == stat.Size()) {
+				continue
+			}
+			stat = newStat
+			bookmarks, err := readBookmarksFile(name)
+			if err != nil {
+				ulog.Errorf("Failed to reload bookmarks: %v", err)
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case updates <- bookmarks:
+			}
+		}
+	}()
+	return updates, nil
+}
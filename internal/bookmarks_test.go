@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"t0ast.cc/tbml/mothership-connector/com"
+)
+
+func TestReadBookmarksFile(t *testing.T) {
+	expected := []com.Bookmark{
+		{
+			Title: "Tor Project",
+			URL:   "https://www.torproject.org/",
+		},
+		{
+			Children: []com.Bookmark{
+				{
+					Title: "DuckDuckGo",
+					URL:   "https://duckduckgo.com/?q=tor&ia=web",
+				},
+				{
+					Title: "Empty",
+				},
+			},
+			Title: "News & Search",
+		},
+		{
+			Title: "Example",
+			URL:   "https://example.com/",
+		},
+	}
+
+	testCases := []struct {
+		desc string
+
+		expected    []com.Bookmark
+		expectedErr error
+		file        string
+	}{
+		{
+			desc: "HTML",
+
+			expected: expected,
+			file:     "testdata/bookmarks/bookmarks.html",
+		},
+		{
+			desc: "JSON",
+
+			expected: expected,
+			file:     "testdata/bookmarks/bookmarks.json",
+		},
+		{
+			desc: "Relative URL",
+
+			expectedErr: ErrInvalidBookmarks,
+			file:        "testdata/bookmarks/relative.json",
+		},
+		{
+			desc: "Broken JSON",
+
+			expectedErr: ErrInvalidBookmarks,
+			file:        "testdata/bookmarks/broken.json",
+		},
+		{
+			desc: "Missing file",
+
+			expectedErr: fs.ErrNotExist,
+			file:        "testdata/bookmarks/missing.json",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			bookmarks, err := readBookmarksFile(tC.file)
+			if tC.expectedErr != nil {
+				assert.ErrorIs(t, err, tC.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tC.expected, bookmarks)
+		})
+	}
+}
@@ -0,0 +1,570 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	uerror "t0ast.cc/tbml/util/error"
+	uio "t0ast.cc/tbml/util/io"
+)
+
+// relativeLauncherBundlesPath is the directory that torbrowser-launcher
+// installs Tor Browser bundles into, relative to the home directory.
+// It contains a directory per architecture.
+const relativeLauncherBundlesPath = ".local/share/torbrowser/tbb"
+
+// getLauncherArchitecture returns torbrowser-launcher's name of the
+// architecture tbml runs on.
+func getLauncherArchitecture() string {
+	switch runtime.GOARCH {
+	case "386":
+		return "i686"
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	default:
+		return runtime.GOARCH
+	}
+}
+
+// getLauncherBundleDirNames returns the names that the directory of
+// a bundle in the locale has, the preferred one first. Newer
+// torbrowser-launcher versions don't include the locale, older ones
+// do.
+func getLauncherBundleDirNames(locale string) []string {
+	return []string{"tor-browser", "tor-browser_" + locale}
+}
+
+// bundleMarkerPaths are paths that only exist in the directory of a
+// real Tor Browser bundle, relative to it. A directory without any of
+// them is a leftover or not installed yet.
+var bundleMarkerPaths = []string{
+	"Browser/tbb_version.json",
+	"start-tor-browser.desktop",
+}
+
+// getRelativeBundlePath returns the path that torbrowser-launcher
+// installs the bundle of the architecture in the locale to, relative
+// to the home directory.
+func getRelativeBundlePath(arch, locale string) string {
+	return filepath.Join(relativeLauncherBundlesPath, arch, getLauncherBundleDirNames(locale)[0])
+}
+
+// findRelativeBundlePath returns the path of the Tor Browser bundle in
+// the instance directory, relative to the home directory. Bundles of
+// the architecture tbml runs on are preferred. Of an instance that
+// uses a shared bundle only the bundle's data is left, which is looked
+// for instead. If there is no bundle in the locale yet, the path that
+// torbrowser-launcher will install it to is returned.
+func findRelativeBundlePath(instanceDir, locale string) (string, error) {
+	entries, err := os.ReadDir(filepath.Join(instanceDir, relativeLauncherBundlesPath))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", uerror.WithStackTrace(err)
+	}
+
+	sharedBundleName, err := readSharedBundleName(instanceDir)
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	markerPaths := bundleMarkerPaths
+	if sharedBundleName != "" {
+		markerPaths = []string{bundleDataPath}
+	}
+
+	nativeArch := getLauncherArchitecture()
+	archs := []string{nativeArch}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != nativeArch {
+			archs = append(archs, entry.Name())
+		}
+	}
+
+	for _, arch := range archs {
+		for _, name := range getLauncherBundleDirNames(locale) {
+			relativePath := filepath.Join(relativeLauncherBundlesPath, arch, name)
+			found, err := hasAnyPath(filepath.Join(instanceDir, relativePath), markerPaths)
+			if err != nil {
+				return "", uerror.WithStackTrace(err)
+			}
+			if found {
+				return relativePath, nil
+			}
+		}
+	}
+	return getRelativeBundlePath(nativeArch, locale), nil
+}
+
+// hasAnyPath returns whether any of the paths exists in dir.
+func hasAnyPath(dir string, paths []string) (bool, error) {
+	for _, path := range paths {
+		_, err := os.Lstat(filepath.Join(dir, path))
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, syscall.ENOTDIR) {
+			return false, uerror.WithStackTrace(err)
+		}
+	}
+	return false, nil
+}
+
+// bundleDataPath is the path of the writable data (profile and Tor
+// state) inside the Tor Browser bundle.
+const bundleDataPath = "Browser/TorBrowser/Data"
+
+// bundleDistributionPath is the path of the directory with the
+// enterprise policies inside the Tor Browser bundle. Each instance
+// has its own.
+const bundleDistributionPath = "Browser/distribution"
+
+// sharedBundleFileName is the name of the file in an instance
+// directory that holds the name of the shared bundle the instance
+// uses.
+const sharedBundleFileName = "shared-bundle"
+
+var ErrSharedBundleMissing error = errors.New("Shared Tor Browser bundle is missing")
+
+// tbbVersion is the content of a Tor Browser bundle's
+// Browser/tbb_version.json.
+type tbbVersion struct {
+	Architecture string
+	Channel      string
+	Locale       string
+	Version      string
+}
+
+// SharedBundleUsage describes which instances use a bundle from the
+// bundle store.
+type SharedBundleUsage struct {
+	Instances []string
+	Name      string
+	Size      int64
+}
+
+// SpaceSaved returns how many bytes are saved by the instances using
+// the shared bundle instead of a copy each.
+func (u SharedBundleUsage) SpaceSaved() int64 {
+	if len(u.Instances) < 2 {
+		return 0
+	}
+	return u.Size * int64(len(u.Instances)-1)
+}
+
+func readBundleVersion(bundleDir string) (tbbVersion, error) {
+	versionBytes, err := os.ReadFile(filepath.Join(bundleDir, "Browser/tbb_version.json"))
+	if err != nil {
+		return tbbVersion{}, uerror.WithStackTrace(err)
+	}
+	version := tbbVersion{}
+	if err := json.Unmarshal(versionBytes, &version); err != nil {
+		return tbbVersion{}, uerror.StackTracef("Failed to parse version of bundle %s: %w", bundleDir, err)
+	}
+	if version.Version == "" || version.Architecture == "" || version.Locale == "" {
+		return tbbVersion{}, uerror.StackTracef("Incomplete version information in bundle %s", bundleDir)
+	}
+	return version, nil
+}
+
+func getSharedBundleName(version tbbVersion) string {
+	return fmt.Sprintf("%s-%s-%s", version.Version, version.Architecture, version.Locale)
+}
+
+// readSharedBundleName returns the name of the shared bundle that the
+// instance uses, or an empty string if it doesn't use one.
+func readSharedBundleName(instanceDir string) (string, error) {
+	nameBytes, err := os.ReadFile(filepath.Join(instanceDir, sharedBundleFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	return strings.TrimSpace(string(nameBytes)), nil
+}
+
+// bundleInstancePath is a path in a shared Tor Browser bundle that
+// each instance has its own copy of.
+type bundleInstancePath struct {
+	Path     string
+	ReadOnly bool
+}
+
+// bundleInstancePaths are the paths in a shared bundle that are bound
+// from the instance over the otherwise read-only shared bundle. The
+// browser writes to its profile and caches, and Tor to its data
+// directory. The native messaging manifests and the policies are
+// written by tbml and only read inside of the sandbox.
+var bundleInstancePaths = []bundleInstancePath{
+	{Path: filepath.Join(bundleDataPath, "Browser/profile.default")},
+	{Path: filepath.Join(bundleDataPath, "Browser/Caches")},
+	{Path: filepath.Join(bundleDataPath, "Tor")},
+	{Path: filepath.Join(bundleDataPath, "Browser/.mozilla"), ReadOnly: true},
+	{Path: bundleDistributionPath, ReadOnly: true},
+}
+
+// shippedTorDataPatterns match the files in Tor's data directory that
+// Tor Browser ships. Everything else in it is state that Tor wrote.
+var shippedTorDataPatterns = []string{"geoip*", "torrc*"}
+
+// getBundleArchitecture returns the architecture of the Tor Browser
+// bundles for the machine tbml runs on, as named in the bundles'
+// tbb_version.json.
+func getBundleArchitecture() string {
+	return "linux-" + getLauncherArchitecture()
+}
+
+// setUpSharedBundle makes the instance use a read-only Tor Browser
+// bundle from the bundle store instead of its own copy and returns
+// the binds needed for that. If the instance has a bundle of its own
+// (installed by torbrowser-launcher), it is adopted into the store,
+// unless the store already has a bundle of the same version, and
+// removed from the instance except for its data. An instance without
+// a bundle is set up with the newest bundle in the store for its
+// architecture and locale, so that torbrowser-launcher doesn't
+// download one. If bundle sharing is disabled or there is no bundle
+// yet, nil is returned.
+func setUpSharedBundle(config Configuration, instanceDir, relativeBundlePath, locale string) ([]sandboxBind, error) {
+	if config.BundlePath == nil {
+		return nil, nil
+	}
+
+	name, err := readSharedBundleName(instanceDir)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+
+	instanceBundleDir := filepath.Join(instanceDir, relativeBundlePath)
+	if name == "" {
+		version, err := readBundleVersion(instanceBundleDir)
+		switch {
+		case err == nil:
+			name = getSharedBundleName(version)
+			if err := adoptBundle(instanceBundleDir, filepath.Join(*config.BundlePath, name)); err != nil {
+				return nil, uerror.WithStackTrace(err)
+			}
+		case errors.Is(err, fs.ErrNotExist):
+			name, err = findSharedBundle(*config.BundlePath, getBundleArchitecture(), locale)
+			if err != nil {
+				return nil, uerror.WithStackTrace(err)
+			}
+			if name == "" {
+				return nil, nil
+			}
+		default:
+			return nil, uerror.WithStackTrace(err)
+		}
+		if err := os.WriteFile(filepath.Join(instanceDir, sharedBundleFileName), []byte(name+"\n"), uio.FileModeURWGRWO); err != nil {
+			return nil, uerror.WithStackTrace(err)
+		}
+	}
+
+	sharedBundleDir := filepath.Join(*config.BundlePath, name)
+	exists, err := uio.DirExists(sharedBundleDir)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	if !exists {
+		return nil, uerror.StackTracef("%w: %s (used by %s)", ErrSharedBundleMissing, sharedBundleDir, instanceDir)
+	}
+
+	if err := markTorBrowserInstalled(instanceDir); err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+
+	binds := []sandboxBind{
+		{
+			Dst:      relativeBundlePath,
+			ReadOnly: true,
+			Src:      sharedBundleDir,
+		},
+	}
+	for _, instancePath := range bundleInstancePaths {
+		sharedPath := filepath.Join(sharedBundleDir, instancePath.Path)
+		ownPath := filepath.Join(instanceBundleDir, instancePath.Path)
+		// The shared bundle needs the directory to bind the
+		// instance's copy onto.
+		if err := os.MkdirAll(sharedPath, uio.FileModeURWXGRWXO); err != nil {
+			return nil, uerror.WithStackTrace(err)
+		}
+		// Files that the bundle ships, like Tor's configuration, are
+		// copied into the instance if it doesn't have them yet.
+		if err := uio.CopyDirMissing(sharedPath, ownPath); err != nil {
+			return nil, uerror.WithStackTrace(err)
+		}
+		binds = append(binds, sandboxBind{
+			Dst:      filepath.Join(relativeBundlePath, instancePath.Path),
+			ReadOnly: instancePath.ReadOnly,
+			Src:      ownPath,
+		})
+	}
+	return binds, nil
+}
+
+// findSharedBundle returns the name of the newest bundle in the bundle
+// store for the architecture in the locale or with all locales, or an
+// empty string if there is none.
+func findSharedBundle(bundlePath, arch, locale string) (string, error) {
+	entries, err := os.ReadDir(bundlePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+
+	name := ""
+	newest := ""
+	for _, entry := range entries {
+		// Bundles that are being installed or adopted are in hidden
+		// directories.
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		version, err := readBundleVersion(filepath.Join(bundlePath, entry.Name()))
+		if err != nil {
+			continue
+		}
+		if version.Architecture != arch || (version.Locale != locale && version.Locale != torBrowserAllLocales) {
+			continue
+		}
+		if name == "" || compareBundleVersions(version.Version, newest) > 0 {
+			name, newest = entry.Name(), version.Version
+		}
+	}
+	return name, nil
+}
+
+// compareBundleVersions compares two Tor Browser versions like
+// "12.5.1" part by part. It returns a negative number if a is older
+// than b, a positive number if it's newer and 0 if they are equal.
+func compareBundleVersions(a, b string) int {
+	aParts, bParts := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNum, aErr := strconv.Atoi(aParts[i])
+		bNum, bErr := strconv.Atoi(bParts[i])
+		if aErr == nil && bErr == nil {
+			if aNum != bNum {
+				return aNum - bNum
+			}
+			continue
+		}
+		if c := strings.Compare(aParts[i], bParts[i]); c != 0 {
+			return c
+		}
+	}
+	return len(aParts) - len(bParts)
+}
+
+// adoptBundle moves the bundle in instanceBundleDir to the bundle
+// store at sharedBundleDir, leaving only the bundle's data in the
+// instance. If the store already has the bundle, the instance's copy
+// is dropped.
+func adoptBundle(instanceBundleDir, sharedBundleDir string) error {
+	exists, err := uio.DirExists(sharedBundleDir)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+
+	if !exists {
+		if err := os.MkdirAll(filepath.Dir(sharedBundleDir), uio.FileModeURWXGRWXO); err != nil {
+			return uerror.WithStackTrace(err)
+		}
+
+		// Copy to a temporary directory first, so that an
+		// interrupted adoption doesn't leave a broken bundle in the
+		// store.
+		tmpDir, err := os.MkdirTemp(filepath.Dir(sharedBundleDir), ".adopt-")
+		if err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		defer os.RemoveAll(tmpDir)
+		if err := uio.CopyDir(instanceBundleDir, tmpDir); err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		if err := removeInstanceState(tmpDir); err != nil {
+			return uerror.WithStackTrace(err)
+		}
+
+		if err := os.Rename(tmpDir, sharedBundleDir); err != nil {
+			return uerror.WithStackTrace(err)
+		}
+	}
+
+	return uerror.WithStackTrace(removeAllExcept(instanceBundleDir, bundleDataPath))
+}
+
+// removeInstanceState removes what an instance wrote into the bundle
+// at bundleDir, so that only what the bundle ships is left to set up
+// other instances with. The directories of the instance paths are
+// kept to bind the instances' copies onto.
+func removeInstanceState(bundleDir string) error {
+	for _, instancePath := range bundleInstancePaths {
+		path := filepath.Join(bundleDir, instancePath.Path)
+		if instancePath.Path == filepath.Join(bundleDataPath, "Tor") {
+			if err := removeAllExceptMatching(path, shippedTorDataPatterns); err != nil {
+				return uerror.WithStackTrace(err)
+			}
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		if err := os.MkdirAll(path, uio.FileModeURWXGRWXO); err != nil {
+			return uerror.WithStackTrace(err)
+		}
+	}
+	return nil
+}
+
+// removeAllExceptMatching removes everything in dir whose name doesn't
+// match one of the patterns.
+func removeAllExceptMatching(dir string, patterns []string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	for _, entry := range entries {
+		matches := false
+		for _, pattern := range patterns {
+			if matched, _ := filepath.Match(pattern, entry.Name()); matched && !entry.IsDir() {
+				matches = true
+			}
+		}
+		if !matches {
+			if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+				return uerror.WithStackTrace(err)
+			}
+		}
+	}
+	return nil
+}
+
+// removeAllExcept removes everything in dir except for the path keep
+// (relative to dir) and its parent directories.
+func removeAllExcept(dir, keep string) error {
+	keepParts := strings.SplitN(keep, "/", 2)
+	keepFirst, keepRest := keepParts[0], ""
+	if len(keepParts) > 1 {
+		keepRest = keepParts[1]
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		if entry.Name() != keepFirst {
+			if err := os.RemoveAll(path); err != nil {
+				return uerror.WithStackTrace(err)
+			}
+		} else if keepRest != "" {
+			if err := removeAllExcept(path, keepRest); err != nil {
+				return uerror.WithStackTrace(err)
+			}
+		}
+	}
+	return nil
+}
+
+// GetSharedBundleUsage returns which of the instances use which
+// bundle from the bundle store, sorted by bundle name.
+func GetSharedBundleUsage(config Configuration, instances []ProfileInstance) ([]SharedBundleUsage, error) {
+	if config.BundlePath == nil {
+		return []SharedBundleUsage{}, nil
+	}
+
+	usageByName := make(map[string]*SharedBundleUsage)
+	for _, instance := range instances {
+		name, err := readSharedBundleName(getInstanceDir(config, instance))
+		if err != nil {
+			return nil, uerror.WithStackTrace(err)
+		}
+		if name == "" {
+			continue
+		}
+		usage, ok := usageByName[name]
+		if !ok {
+			size, err := uio.DirSize(filepath.Join(*config.BundlePath, name))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return nil, uerror.WithStackTrace(err)
+			}
+			usage = &SharedBundleUsage{
+				Name: name,
+				Size: size,
+			}
+			usageByName[name] = usage
+		}
+		usage.Instances = append(usage.Instances, instance.InstanceLabel)
+	}
+
+	usages := make([]SharedBundleUsage, 0, len(usageByName))
+	for _, usage := range usageByName {
+		usages = append(usages, *usage)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].Name < usages[j].Name
+	})
+	return usages, nil
+}
+
+// useManagedBundle makes the instance use the bundle with the given
+// name that tbml installed into the bundle store. Files of a bundle
+// that the instance has of its own are removed, except for its data.
+// The instance is set up with the data that the bundle ships with, and
+// torbrowser-launcher is told about the bundle, by setUpSharedBundle.
+func useManagedBundle(instanceDir, name, relativeBundlePath string) error {
+	instanceBundleDir := filepath.Join(instanceDir, relativeBundlePath)
+	ownBundle, err := uio.FileExists(filepath.Join(instanceBundleDir, "Browser/tbb_version.json"))
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	if ownBundle {
+		if err := removeAllExcept(instanceBundleDir, bundleDataPath); err != nil {
+			return uerror.WithStackTrace(err)
+		}
+	}
+
+	return uerror.WithStackTrace(os.WriteFile(filepath.Join(instanceDir, sharedBundleFileName), []byte(name+"\n"), uio.FileModeURWGRWO))
+}
+
+// markTorBrowserInstalled tells torbrowser-launcher in the instance
+// that Tor Browser is installed already and that it mustn't check for
+// updates, so that it doesn't download Tor Browser into the shared
+// bundle, which is read-only.
+func markTorBrowserInstalled(instanceDir string) error {
+	settingsPath := filepath.Join(instanceDir, tblSettingsPath)
+	settings := make(map[string]interface{})
+	settingsBytes, err := os.ReadFile(settingsPath)
+	if err == nil {
+		if err := json.Unmarshal(settingsBytes, &settings); err != nil {
+			return uerror.StackTracef("Failed to parse %s: %w", settingsPath, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return uerror.WithStackTrace(err)
+	}
+	// The other settings are filled in by reconcileLauncherSettings.
+	if err := os.MkdirAll(filepath.Dir(settingsPath), uio.FileModeURWXGRWXO); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	settings["check_for_updates"] = false
+	settings["installed"] = true
+	settingsBytes, err = json.Marshal(settings)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	return uerror.WithStackTrace(os.WriteFile(settingsPath, settingsBytes, uio.FileModeURWGRWO))
+}
@@ -0,0 +1,303 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	uio "t0ast.cc/tbml/util/io"
+)
+
+// testRelativeBundlePath is where torbrowser-launcher installs the
+// en-US bundle on the machine the tests run on.
+var testRelativeBundlePath = getRelativeBundlePath(getLauncherArchitecture(), defaultLauncherLocale)
+
+// testBundleVersion is the tbb_version.json of the bundle written by
+// writeTestBundle.
+var testBundleVersion = `{"version":"11.0.4","architecture":"` + getBundleArchitecture() + `","channel":"release","locale":"en-US"}`
+
+func writeTestBundle(t *testing.T, instanceDir string) {
+	files := map[string]string{
+		"Browser/tbb_version.json": testBundleVersion,
+		"Browser/firefox":          "binary",
+		"Browser/TorBrowser/Data/Browser/profile.default/prefs.js": "prefs",
+		"Browser/TorBrowser/Data/Tor/cached-certs":                 "certs",
+		"Browser/TorBrowser/Data/Tor/torrc":                        "torrc",
+		"start-tor-browser.desktop":                                "desktop",
+	}
+	for path, content := range files {
+		fullPath := filepath.Join(instanceDir, testRelativeBundlePath, path)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(fullPath), uio.FileModeURWXGRWXO))
+		assert.NoError(t, os.WriteFile(fullPath, []byte(content), uio.FileModeURWGRWO))
+	}
+}
+
+func TestSetUpSharedBundle(t *testing.T) {
+	config, _, instance, instanceDir, cleanUpEnvironment := setUpTestEnvironment(t)
+	defer cleanUpEnvironment()
+
+	bundlePath, err := os.MkdirTemp(os.TempDir(), "tbml-test-bundles-*")
+	assert.NoError(t, err)
+	defer os.RemoveAll(bundlePath)
+	config.BundlePath = &bundlePath
+
+	otherInstance := instance
+	otherInstance.InstanceLabel = "test-2"
+	otherInstanceDir := getInstanceDir(config, otherInstance)
+
+	t.Run("No bundle yet", func(t *testing.T) {
+		binds, err := setUpSharedBundle(config, instanceDir, testRelativeBundlePath, defaultLauncherLocale)
+		assert.NoError(t, err)
+		assert.Nil(t, binds)
+	})
+
+	sharedBundleName := "11.0.4-" + getBundleArchitecture() + "-en-US"
+	sharedBundleDir := filepath.Join(bundlePath, sharedBundleName)
+	expectedBinds := func(instanceDir string) []sandboxBind {
+		instanceBundleDir := filepath.Join(instanceDir, testRelativeBundlePath)
+		return []sandboxBind{
+			{
+				Dst:      testRelativeBundlePath,
+				ReadOnly: true,
+				Src:      sharedBundleDir,
+			},
+			{
+				Dst: filepath.Join(testRelativeBundlePath, bundleDataPath, "Browser/profile.default"),
+				Src: filepath.Join(instanceBundleDir, bundleDataPath, "Browser/profile.default"),
+			},
+			{
+				Dst: filepath.Join(testRelativeBundlePath, bundleDataPath, "Browser/Caches"),
+				Src: filepath.Join(instanceBundleDir, bundleDataPath, "Browser/Caches"),
+			},
+			{
+				Dst: filepath.Join(testRelativeBundlePath, bundleDataPath, "Tor"),
+				Src: filepath.Join(instanceBundleDir, bundleDataPath, "Tor"),
+			},
+			{
+				Dst:      filepath.Join(testRelativeBundlePath, bundleDataPath, "Browser/.mozilla"),
+				ReadOnly: true,
+				Src:      filepath.Join(instanceBundleDir, bundleDataPath, "Browser/.mozilla"),
+			},
+			{
+				Dst:      filepath.Join(testRelativeBundlePath, bundleDistributionPath),
+				ReadOnly: true,
+				Src:      filepath.Join(instanceBundleDir, bundleDistributionPath),
+			},
+		}
+	}
+
+	t.Run("Adopt bundle", func(t *testing.T) {
+		writeTestBundle(t, instanceDir)
+
+		binds, err := setUpSharedBundle(config, instanceDir, testRelativeBundlePath, defaultLauncherLocale)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedBinds(instanceDir), binds)
+
+		assert.FileExists(t, filepath.Join(sharedBundleDir, "Browser/firefox"))
+		assert.FileExists(t, filepath.Join(sharedBundleDir, "start-tor-browser.desktop"))
+		assert.DirExists(t, filepath.Join(sharedBundleDir, bundleDataPath))
+		assert.DirExists(t, filepath.Join(sharedBundleDir, bundleDistributionPath))
+		assert.FileExists(t, filepath.Join(sharedBundleDir, bundleDataPath, "Tor/torrc"))
+		assert.NoFileExists(t, filepath.Join(sharedBundleDir, bundleDataPath, "Tor/cached-certs"))
+		assert.NoFileExists(t, filepath.Join(sharedBundleDir, bundleDataPath, "Browser/profile.default/prefs.js"))
+
+		instanceBundleDir := filepath.Join(instanceDir, testRelativeBundlePath)
+		assert.NoFileExists(t, filepath.Join(instanceBundleDir, "Browser/firefox"))
+		assert.NoFileExists(t, filepath.Join(instanceBundleDir, "start-tor-browser.desktop"))
+		assert.FileExists(t, filepath.Join(instanceBundleDir, bundleDataPath, "Tor/torrc"))
+		assert.FileExists(t, filepath.Join(instanceDir, getRelativeProfilePath(testRelativeBundlePath), "prefs.js"))
+
+		name, err := readSharedBundleName(instanceDir)
+		assert.NoError(t, err)
+		assert.Equal(t, sharedBundleName, name)
+	})
+
+	t.Run("Reuse adopted bundle", func(t *testing.T) {
+		binds, err := setUpSharedBundle(config, instanceDir, testRelativeBundlePath, defaultLauncherLocale)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedBinds(instanceDir), binds)
+	})
+
+	t.Run("Drop copy of bundle in store", func(t *testing.T) {
+		writeTestBundle(t, otherInstanceDir)
+
+		binds, err := setUpSharedBundle(config, otherInstanceDir, testRelativeBundlePath, defaultLauncherLocale)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedBinds(otherInstanceDir), binds)
+		assert.NoFileExists(t, filepath.Join(otherInstanceDir, testRelativeBundlePath, "Browser/firefox"))
+
+		entries, err := os.ReadDir(bundlePath)
+		assert.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	newInstance := instance
+	newInstance.InstanceLabel = "test-3"
+	newInstanceDir := getInstanceDir(config, newInstance)
+
+	t.Run("Set up new instance with bundle from store", func(t *testing.T) {
+		settingsPath := filepath.Join(newInstanceDir, tblSettingsPath)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(settingsPath), uio.FileModeURWXGRWXO))
+		assert.NoError(t, os.WriteFile(settingsPath, []byte(`{"installed":false}`), uio.FileModeURWGRWO))
+
+		binds, err := setUpSharedBundle(config, newInstanceDir, testRelativeBundlePath, defaultLauncherLocale)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedBinds(newInstanceDir), binds)
+
+		name, err := readSharedBundleName(newInstanceDir)
+		assert.NoError(t, err)
+		assert.Equal(t, sharedBundleName, name)
+		assert.FileExists(t, filepath.Join(newInstanceDir, testRelativeBundlePath, bundleDataPath, "Tor/torrc"))
+
+		settingsBytes, err := os.ReadFile(settingsPath)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"check_for_updates":false,"installed":true}`, string(settingsBytes))
+	})
+
+	t.Run("No bundle in store for locale", func(t *testing.T) {
+		otherLocaleDir := getInstanceDir(config, ProfileInstance{InstanceLabel: "test-4", ProfileLabel: instance.ProfileLabel})
+
+		binds, err := setUpSharedBundle(config, otherLocaleDir, testRelativeBundlePath, "de")
+		assert.NoError(t, err)
+		assert.Nil(t, binds)
+	})
+
+	t.Run("Usage", func(t *testing.T) {
+		usages, err := GetSharedBundleUsage(config, []ProfileInstance{instance, otherInstance, newInstance})
+		assert.NoError(t, err)
+		assert.Equal(t, []SharedBundleUsage{
+			{
+				Instances: []string{"test-1", "test-2", "test-3"},
+				Name:      sharedBundleName,
+				Size:      int64(len("binary") + len(testBundleVersion) + len("torrc") + len("desktop")),
+			},
+		}, usages)
+		assert.Equal(t, 2*usages[0].Size, usages[0].SpaceSaved())
+	})
+
+	t.Run("Missing shared bundle", func(t *testing.T) {
+		assert.NoError(t, os.RemoveAll(sharedBundleDir))
+
+		_, err := setUpSharedBundle(config, instanceDir, testRelativeBundlePath, defaultLauncherLocale)
+		assert.ErrorIs(t, err, ErrSharedBundleMissing)
+	})
+}
+
+func TestSetUpSharedBundleDisabled(t *testing.T) {
+	config, _, _, instanceDir, cleanUpEnvironment := setUpTestEnvironment(t)
+	defer cleanUpEnvironment()
+	writeTestBundle(t, instanceDir)
+
+	binds, err := setUpSharedBundle(config, instanceDir, testRelativeBundlePath, defaultLauncherLocale)
+	assert.NoError(t, err)
+	assert.Nil(t, binds)
+	assert.FileExists(t, filepath.Join(instanceDir, testRelativeBundlePath, "Browser/firefox"))
+}
+
+func TestFindRelativeBundlePath(t *testing.T) {
+	nativeArch := getLauncherArchitecture()
+
+	testCases := []struct {
+		desc string
+
+		// Bundles in the instance's bundles directory. {arch} is
+		// replaced with the architecture the tests run on.
+		bundles []string
+		// Directories in the instance's bundles directory that
+		// aren't bundles.
+		dirs         []string
+		expected     string
+		locale       string
+		sharedBundle bool
+	}{
+		{
+			desc: "No bundle yet",
+
+			expected: filepath.Join(nativeArch, "tor-browser"),
+			locale:   "en-US",
+		},
+		{
+			desc: "Localized bundle",
+
+			bundles:  []string{"{arch}/tor-browser_de"},
+			expected: filepath.Join(nativeArch, "tor-browser_de"),
+			locale:   "de",
+		},
+		{
+			desc: "Unlocalized bundle",
+
+			bundles:  []string{"{arch}/tor-browser"},
+			expected: filepath.Join(nativeArch, "tor-browser"),
+			locale:   "de",
+		},
+		{
+			desc: "Unlocalized bundle is preferred",
+
+			bundles:  []string{"{arch}/tor-browser", "{arch}/tor-browser_de"},
+			expected: filepath.Join(nativeArch, "tor-browser"),
+			locale:   "de",
+		},
+		{
+			desc: "Leftover directory is no bundle",
+
+			bundles:  []string{"{arch}/tor-browser_de"},
+			dirs:     []string{"{arch}/tor-browser/Browser"},
+			expected: filepath.Join(nativeArch, "tor-browser_de"),
+			locale:   "de",
+		},
+		{
+			desc: "Bundle of other architecture",
+
+			bundles:  []string{"other-arch/tor-browser_en-US"},
+			expected: "other-arch/tor-browser_en-US",
+			locale:   "en-US",
+		},
+		{
+			desc: "Bundle of own architecture is preferred",
+
+			bundles:  []string{"another-arch/tor-browser_en-US", "{arch}/tor-browser_en-US"},
+			expected: filepath.Join(nativeArch, "tor-browser_en-US"),
+			locale:   "en-US",
+		},
+		{
+			desc: "Bundle in other locale is ignored",
+
+			bundles:  []string{"{arch}/tor-browser_fr"},
+			expected: filepath.Join(nativeArch, "tor-browser"),
+			locale:   "en-US",
+		},
+		{
+			desc: "Data of shared bundle",
+
+			dirs:         []string{"{arch}/tor-browser_de/" + bundleDataPath},
+			expected:     filepath.Join(nativeArch, "tor-browser_de"),
+			locale:       "de",
+			sharedBundle: true,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			instanceDir := t.TempDir()
+			for _, bundle := range tC.bundles {
+				bundle = strings.ReplaceAll(bundle, "{arch}", nativeArch)
+				markerPath := filepath.Join(instanceDir, relativeLauncherBundlesPath, bundle, "start-tor-browser.desktop")
+				assert.NoError(t, os.MkdirAll(filepath.Dir(markerPath), uio.FileModeURWXGRWXO))
+				assert.NoError(t, os.WriteFile(markerPath, []byte("desktop"), uio.FileModeURWGRWO))
+			}
+			for _, dir := range tC.dirs {
+				dir = strings.ReplaceAll(dir, "{arch}", nativeArch)
+				assert.NoError(t, os.MkdirAll(filepath.Join(instanceDir, relativeLauncherBundlesPath, dir), uio.FileModeURWXGRWXO))
+			}
+			if tC.sharedBundle {
+				assert.NoError(t, os.WriteFile(filepath.Join(instanceDir, sharedBundleFileName), []byte("11.0.4-linux-x86_64-de\n"), uio.FileModeURWGRWO))
+			}
+
+			actual, err := findRelativeBundlePath(instanceDir, tC.locale)
+			assert.NoError(t, err)
+			assert.Equal(t, filepath.Join(relativeLauncherBundlesPath, tC.expected), actual)
+		})
+	}
+}
@@ -0,0 +1,506 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	uerror "t0ast.cc/tbml/util/error"
+	uio "t0ast.cc/tbml/util/io"
+	ulog "t0ast.cc/tbml/util/log"
+)
+
+var ErrDaemonRunning error = errors.New("tbml daemon is already running")
+var ErrTopicInUse error = errors.New("Topic is already open")
+
+type daemonMsgType string
+
+const (
+	daemonMsgTypeError           daemonMsgType = "error"
+	daemonMsgTypeInstances       daemonMsgType = "instances"
+	daemonMsgTypeListInstances   daemonMsgType = "list-instances"
+	daemonMsgTypeStartInstance   daemonMsgType = "start-instance"
+	daemonMsgTypeStartedInstance daemonMsgType = "started-instance"
+)
+
+type daemonRequest struct {
+	Type    daemonMsgType
+	Profile string   `json:",omitempty"`
+	Topic   string   `json:",omitempty"`
+	URLs    []string `json:",omitempty"`
+}
+
+type daemonResponse struct {
+	Type  daemonMsgType
+	Error string `json:",omitempty"`
+	// ErrorKind names the sentinel error that Error wraps, if it's
+	// one of daemonErrorKinds.
+	ErrorKind string           `json:",omitempty"`
+	Instances []DaemonInstance `json:",omitempty"`
+}
+
+// daemonErrorKinds are the sentinel errors that survive the trip from
+// the daemon to the client, so that the client can tell them apart.
+var daemonErrorKinds = map[string]error{
+	"daemon-running":        ErrDaemonRunning,
+	"instance-in-use":       ErrInstanceInUse,
+	"invalid-configuration": ErrInvalidConfiguration,
+	"topic-in-use":          ErrTopicInUse,
+}
+
+// daemonError is an error that the daemon reported. It wraps the
+// sentinel error of its kind, if it is a known one.
+type daemonError struct {
+	kind    error
+	message string
+}
+
+func (e daemonError) Error() string {
+	return e.message
+}
+
+func (e daemonError) Unwrap() error {
+	return e.kind
+}
+
+// DaemonInstance describes an instance that is supervised by the
+// daemon.
+type DaemonInstance struct {
+	InstanceLabel string
+	ProfileLabel  string
+	Started       time.Time
+	Topic         string
+}
+
+type daemonState struct {
+	Instances []DaemonInstance
+	PID       int
+}
+
+type daemon struct {
+	ctx       context.Context
+	instances map[string]DaemonInstance
+	// loadConfig reads the configuration. It's called for every
+	// request, so that changes to the configuration take effect
+	// without restarting the daemon.
+	loadConfig func() (config Configuration, configDir string, err error)
+	// mutex guards "instances" and serializes instance starts, so
+	// that concurrent requests can't pick the same instance.
+	mutex     sync.Mutex
+	statePath string
+	wg        sync.WaitGroup
+}
+
+// RunDaemon runs the tbml daemon until the context is cancelled.
+// The daemon listens on a user-level socket for requests to start
+// instances, supervises the started instances as child processes and
+// persists their state to disk, so that instances of a crashed daemon
+// can be released when the daemon is started again. The configuration
+// is read with loadConfig on startup and again for every request.
+func RunDaemon(ctx context.Context, loadConfig func() (config Configuration, configDir string, err error)) error {
+	config, _, err := loadConfig()
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	statePath, err := getDaemonStatePath()
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	if err := recoverDaemonState(config, statePath); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+
+	d := &daemon{
+		ctx:        ctx,
+		instances:  make(map[string]DaemonInstance),
+		loadConfig: loadConfig,
+		statePath:  statePath,
+	}
+	if err := d.persist(); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+
+	listener, err := listenOnDaemonSocket()
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.AcceptUnix()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			ulog.Errorf("Failed to accept daemon connection: %v", err)
+			continue
+		}
+		go func() {
+			defer conn.Close()
+			if err := d.handleConnection(conn); err != nil {
+				ulog.Errorf("Daemon connection: %v", err)
+			}
+		}()
+	}
+
+	d.wg.Wait()
+	if err := os.Remove(statePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return uerror.WithStackTrace(err)
+	}
+	return nil
+}
+
+func (d *daemon) handleConnection(conn *net.UnixConn) error {
+	sc := bufio.NewScanner(conn)
+	if !sc.Scan() {
+		return uerror.WithStackTrace(sc.Err())
+	}
+	var req daemonRequest
+	if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+		return sendDaemonError(conn, err)
+	}
+
+	switch req.Type {
+	case daemonMsgTypeListInstances:
+		return sendMessageOverSocket(conn, daemonResponse{
+			Type:      daemonMsgTypeInstances,
+			Instances: d.listInstances(),
+		})
+	case daemonMsgTypeStartInstance:
+		instance, err := d.startInstance(req)
+		if err != nil {
+			return sendDaemonError(conn, err)
+		}
+		return sendMessageOverSocket(conn, daemonResponse{
+			Type:      daemonMsgTypeStartedInstance,
+			Instances: []DaemonInstance{instance},
+		})
+	default:
+		return sendDaemonError(conn, fmt.Errorf("Unknown request type: %s", req.Type))
+	}
+}
+
+func (d *daemon) listInstances() []DaemonInstance {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	instances := make([]DaemonInstance, 0, len(d.instances))
+	for _, instance := range d.instances {
+		instances = append(instances, instance)
+	}
+	return instances
+}
+
+func (d *daemon) startInstance(req daemonRequest) (DaemonInstance, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	startURLs := []*url.URL{}
+	for _, reqURL := range req.URLs {
+		u, err := url.Parse(reqURL)
+		if err != nil {
+			return DaemonInstance{}, uerror.WithStackTrace(err)
+		}
+		startURLs = append(startURLs, u)
+	}
+
+	config, configDir, err := d.loadConfig()
+	if err != nil {
+		return DaemonInstance{}, uerror.StackTracef("Failed to read configuration: %w", err)
+	}
+	instances, err := GetProfileInstances(config)
+	if err != nil {
+		return DaemonInstance{}, uerror.WithStackTrace(err)
+	}
+	if FindInstanceByTopic(instances, req.Topic) != nil {
+		return DaemonInstance{}, fmt.Errorf("%w: %s", ErrTopicInUse, req.Topic)
+	}
+	profile := FindProfileByLabel(config, req.Profile)
+	if profile == nil {
+		return DaemonInstance{}, fmt.Errorf("Profile %s does not exist", req.Profile)
+	}
+
+	instance := GetBestInstance(*profile, instances)
+	topic := req.Topic
+	instance.UsageLabel = &topic
+
+	daemonInstance := DaemonInstance{
+		InstanceLabel: instance.InstanceLabel,
+		ProfileLabel:  instance.ProfileLabel,
+		Started:       time.Now(),
+		Topic:         topic,
+	}
+
+	ready := make(chan struct{})
+	startErrs := make(chan error, 1)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		exitCode, err := StartInstance(d.ctx, config, *profile, instance, instances, configDir, startURLs, false, true, func() {
+			close(ready)
+		})
+		if err == nil && exitCode != 0 {
+			err = fmt.Errorf("Instance %s exited with code %d", instance.InstanceLabel, exitCode)
+		}
+		select {
+		case <-ready:
+			if err != nil {
+				ulog.Errorf("%v", err)
+			} else {
+				ulog.Infof("Instance %s exited", instance.InstanceLabel)
+			}
+			d.mutex.Lock()
+			delete(d.instances, instance.InstanceLabel)
+			if err := d.persist(); err != nil {
+				ulog.Errorf("Failed to persist daemon state: %v", err)
+			}
+			d.mutex.Unlock()
+		default:
+			startErrs <- err
+		}
+	}()
+
+	select {
+	case <-ready:
+	case err := <-startErrs:
+		if err == nil {
+			err = fmt.Errorf("Instance %s exited before it was ready", instance.InstanceLabel)
+		}
+		return DaemonInstance{}, uerror.WithStackTrace(err)
+	}
+
+	ulog.Infof("Started instance %s for topic %s", instance.InstanceLabel, topic)
+	d.instances[instance.InstanceLabel] = daemonInstance
+	if err := d.persist(); err != nil {
+		return DaemonInstance{}, uerror.WithStackTrace(err)
+	}
+	return daemonInstance, nil
+}
+
+// persist writes the daemon's state to disk. The caller must hold
+// the mutex.
+func (d *daemon) persist() error {
+	state := daemonState{
+		Instances: make([]DaemonInstance, 0, len(d.instances)),
+		PID:       os.Getpid(),
+	}
+	for _, instance := range d.instances {
+		state.Instances = append(state.Instances, instance)
+	}
+	stateBytes, err := json.Marshal(state)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	if err := ensureExists(d.statePath, stateBytes); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	return nil
+}
+
+// recoverDaemonState releases the instances that were left marked as
+// in use by a daemon that did not shut down cleanly.
+func recoverDaemonState(config Configuration, statePath string) error {
+	stateBytes, err := os.ReadFile(statePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	var state daemonState
+	if err := json.Unmarshal(stateBytes, &state); err != nil {
+		return uerror.StackTracef("Failed to unmarshal daemon state in %s: %w", statePath, err)
+	}
+
+	if state.PID != os.Getpid() && isProcessAlive(state.PID) {
+		return fmt.Errorf("%w (PID %d)", ErrDaemonRunning, state.PID)
+	}
+
+	for _, daemonInstance := range state.Instances {
+		instance, err := GetProfileInstance(config, daemonInstance.InstanceLabel)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		if instance.UsagePID == nil || *instance.UsagePID != state.PID {
+			continue
+		}
+		instanceDataPath := filepath.Join(getInstanceDir(config, instance), "profile-instance.json")
+		if err := releaseInstanceData(instanceDataPath); err != nil {
+			return uerror.WithStackTrace(err)
+		}
+	}
+	return nil
+}
+
+func isProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func sendDaemonError(conn *net.UnixConn, err error) error {
+	resp := daemonResponse{
+		Type:  daemonMsgTypeError,
+		Error: err.Error(),
+	}
+	for kind, kindErr := range daemonErrorKinds {
+		if errors.Is(err, kindErr) {
+			resp.ErrorKind = kind
+			break
+		}
+	}
+	if sendErr := sendMessageOverSocket(conn, resp); sendErr != nil {
+		return uerror.WithStackTrace(sendErr)
+	}
+	return uerror.WithStackTrace(err)
+}
+
+func listenOnDaemonSocket() (*net.UnixListener, error) {
+	addr, err := resolveDaemonSocketAddr()
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(addr.Name), uio.FileModeURWXGO); err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+
+	listener, err := net.ListenUnix("unix", addr)
+	if errors.Is(err, syscall.EADDRINUSE) {
+		// The socket file might be left over from a daemon that
+		// didn't shut down cleanly.
+		if conn, dialErr := net.DialUnix("unix", nil, addr); dialErr == nil {
+			conn.Close()
+			return nil, uerror.WithStackTrace(ErrDaemonRunning)
+		}
+		if err := os.Remove(addr.Name); err != nil {
+			return nil, uerror.WithStackTrace(err)
+		}
+		listener, err = net.ListenUnix("unix", addr)
+	}
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	return listener, nil
+}
+
+// ConnectToDaemon connects to the daemon's socket. It returns nil
+// without an error if no daemon is running.
+func ConnectToDaemon() (*net.UnixConn, error) {
+	addr, err := resolveDaemonSocketAddr()
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	conn, err := net.DialUnix("unix", nil, addr)
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	return conn, nil
+}
+
+// StartInstanceThroughDaemon asks the daemon to start an instance of
+// the given profile for the given topic and open the start URLs in
+// it. It returns as soon as the instance is ready.
+func StartInstanceThroughDaemon(conn *net.UnixConn, profileLabel, topic string, startURLs []*url.URL) (DaemonInstance, error) {
+	req := daemonRequest{
+		Type:    daemonMsgTypeStartInstance,
+		Profile: profileLabel,
+		Topic:   topic,
+	}
+	for _, startURL := range startURLs {
+		req.URLs = append(req.URLs, startURL.String())
+	}
+	resp, err := sendDaemonRequest(conn, req, daemonMsgTypeStartedInstance)
+	if err != nil {
+		return DaemonInstance{}, uerror.WithStackTrace(err)
+	}
+	if len(resp.Instances) != 1 {
+		return DaemonInstance{}, uerror.StackTracef("Expected one started instance, got %d", len(resp.Instances))
+	}
+	return resp.Instances[0], nil
+}
+
+// ListDaemonInstances returns the instances that are supervised by
+// the daemon.
+func ListDaemonInstances(conn *net.UnixConn) ([]DaemonInstance, error) {
+	resp, err := sendDaemonRequest(conn, daemonRequest{
+		Type: daemonMsgTypeListInstances,
+	}, daemonMsgTypeInstances)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	return resp.Instances, nil
+}
+
+func sendDaemonRequest(conn *net.UnixConn, req daemonRequest, expectedType daemonMsgType) (daemonResponse, error) {
+	if err := sendMessageOverSocket(conn, req); err != nil {
+		return daemonResponse{}, uerror.WithStackTrace(err)
+	}
+	sc := bufio.NewScanner(conn)
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return daemonResponse{}, uerror.WithStackTrace(err)
+		}
+		return daemonResponse{}, uerror.StackTracef("Daemon closed the connection without a response")
+	}
+	var resp daemonResponse
+	if err := json.Unmarshal(sc.Bytes(), &resp); err != nil {
+		return daemonResponse{}, uerror.WithStackTrace(err)
+	}
+	if resp.Type == daemonMsgTypeError {
+		return daemonResponse{}, uerror.StackTracef("Daemon error: %w", daemonError{
+			kind:    daemonErrorKinds[resp.ErrorKind],
+			message: resp.Error,
+		})
+	}
+	if resp.Type != expectedType {
+		return daemonResponse{}, uerror.StackTracef("Unexpected response from daemon: wanted \"%s\" but got \"%s\"", expectedType, resp.Type)
+	}
+	return resp, nil
+}
+
+func resolveDaemonSocketAddr() (*net.UnixAddr, error) {
+	runtimeDir, err := getDaemonRuntimeDir()
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	addr, err := net.ResolveUnixAddr("unix", filepath.Join(runtimeDir, "daemon-socket"))
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	return addr, nil
+}
+
+func getDaemonRuntimeDir() (string, error) {
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "tbml"), nil
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprint("tbml-", os.Getuid())), nil
+}
+
+func getDaemonStatePath() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	return filepath.Join(cache, "tbml-daemon", "state.json"), nil
+}
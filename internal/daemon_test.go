@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	uio "t0ast.cc/tbml/util/io"
+)
+
+func setUpDaemonEnvironment(t *testing.T) {
+	tmpDir := t.TempDir()
+	for k, v := range map[string]string{
+		"XDG_CACHE_HOME":  filepath.Join(tmpDir, "cache"),
+		"XDG_RUNTIME_DIR": filepath.Join(tmpDir, "run"),
+	} {
+		orig, hadOrig := os.LookupEnv(k)
+		os.Setenv(k, v)
+		k := k
+		t.Cleanup(func() {
+			if hadOrig {
+				os.Setenv(k, orig)
+			} else {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
+
+func TestRecoverDaemonState(t *testing.T) {
+	config, profile, instance, instanceDir, cleanUpEnvironment := setUpTestEnvironment(t)
+	defer cleanUpEnvironment()
+
+	_, err := writeInstanceData(config, profile, instance)
+	require.NoError(t, err)
+
+	// Pretend the instance was started by a daemon that has since
+	// died.
+	deadPID := 1 << 30
+	instanceDataPath := filepath.Join(instanceDir, "profile-instance.json")
+	instance, err = GetProfileInstance(config, instance.InstanceLabel)
+	require.NoError(t, err)
+	instance.UsagePID = &deadPID
+	instanceDataBytes, err := json.Marshal(instance)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(instanceDataPath, instanceDataBytes, uio.FileModeURWGRWO))
+
+	stateBytes, err := json.Marshal(daemonState{
+		Instances: []DaemonInstance{
+			{
+				InstanceLabel: instance.InstanceLabel,
+				ProfileLabel:  instance.ProfileLabel,
+				Topic:         *instance.UsageLabel,
+			},
+		},
+		PID: deadPID,
+	})
+	require.NoError(t, err)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(statePath, stateBytes, uio.FileModeURWGRWO))
+
+	assert.NoError(t, recoverDaemonState(config, statePath))
+
+	actual, err := GetProfileInstance(config, instance.InstanceLabel)
+	assert.NoError(t, err)
+	assert.Nil(t, actual.UsagePID)
+	assert.Nil(t, actual.UsageLabel)
+}
+
+func TestRecoverDaemonStateRunningDaemon(t *testing.T) {
+	config, _, _, _, cleanUpEnvironment := setUpTestEnvironment(t)
+	defer cleanUpEnvironment()
+
+	stateBytes, err := json.Marshal(daemonState{
+		PID: os.Getppid(),
+	})
+	require.NoError(t, err)
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(statePath, stateBytes, uio.FileModeURWGRWO))
+
+	assert.ErrorIs(t, recoverDaemonState(config, statePath), ErrDaemonRunning)
+}
+
+func TestDaemon(t *testing.T) {
+	config, profile, instance, _, cleanUpEnvironment := setUpTestEnvironment(t)
+	defer cleanUpEnvironment()
+	setUpDaemonEnvironment(t)
+
+	conn, err := ConnectToDaemon()
+	assert.NoError(t, err)
+	assert.Nil(t, conn)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	daemonErrs := make(chan error)
+	configErrs := make(chan error, 1)
+	go func() {
+		daemonErrs <- RunDaemon(ctx, func() (Configuration, string, error) {
+			select {
+			case err := <-configErrs:
+				return Configuration{}, "", err
+			default:
+				return config, "", nil
+			}
+		})
+	}()
+
+	require.Eventually(t, func() bool {
+		conn, err := ConnectToDaemon()
+		if conn != nil {
+			conn.Close()
+		}
+		return err == nil && conn != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	conn, err = ConnectToDaemon()
+	require.NoError(t, err)
+	instances, err := ListDaemonInstances(conn)
+	assert.NoError(t, err)
+	assert.Empty(t, instances)
+	conn.Close()
+
+	conn, err = ConnectToDaemon()
+	require.NoError(t, err)
+	_, err = StartInstanceThroughDaemon(conn, "nonexistent", "some-topic", nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Profile nonexistent does not exist")
+	}
+	conn.Close()
+
+	// Known errors can be told apart by the client
+	_, err = writeInstanceData(config, profile, instance)
+	require.NoError(t, err)
+	conn, err = ConnectToDaemon()
+	require.NoError(t, err)
+	_, err = StartInstanceThroughDaemon(conn, profile.Label, *instance.UsageLabel, nil)
+	assert.ErrorIs(t, err, ErrTopicInUse)
+	conn.Close()
+
+	// The configuration is read again for every request
+	configErrs <- errors.New("Broken configuration")
+	conn, err = ConnectToDaemon()
+	require.NoError(t, err)
+	_, err = StartInstanceThroughDaemon(conn, "nonexistent", "some-topic", nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "Broken configuration")
+	}
+	conn.Close()
+
+	statePath, err := getDaemonStatePath()
+	assert.NoError(t, err)
+	assert.FileExists(t, statePath)
+
+	cancel()
+	assert.NoError(t, <-daemonErrs)
+	assert.NoFileExists(t, statePath)
+}
@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	uerror "t0ast.cc/tbml/util/error"
+	uio "t0ast.cc/tbml/util/io"
+)
+
+const desktopEntryFileName = "tbml.desktop"
+
+var desktopEntryMimeTypes = []string{
+	"text/html",
+	"application/xhtml+xml",
+	"x-scheme-handler/http",
+	"x-scheme-handler/https",
+}
+
+var desktopActionInvalidCharsRE *regexp.Regexp = regexp.MustCompile("[^A-Za-z0-9-]")
+
+// GenerateDesktopEntry renders a desktop entry that launches tbml
+// through the given command (the tbml executable followed by any
+// global arguments) as a web browser. If withActions is true, an
+// additional desktop action is added for every configured profile.
+func GenerateDesktopEntry(config Configuration, command []string, withActions bool) string {
+	sb := strings.Builder{}
+	sb.WriteString("[Desktop Entry]\n")
+	sb.WriteString("Type=Application\n")
+	sb.WriteString("Version=1.0\n")
+	sb.WriteString("Name=tbml\n")
+	sb.WriteString("GenericName=Web Browser\n")
+	sb.WriteString("Comment=Open links in topic-isolated Tor Browser instances\n")
+	sb.WriteString("Icon=torbrowser\n")
+	sb.WriteString("Terminal=false\n")
+	sb.WriteString("Categories=Network;WebBrowser;\n")
+	fmt.Fprintf(&sb, "MimeType=%s;\n", strings.Join(desktopEntryMimeTypes, ";"))
+	fmt.Fprintf(&sb, "Exec=%s\n", desktopEntryExec(command, "open", "%u"))
+
+	if withActions && len(config.Profiles) > 0 {
+		actionIDs := make([]string, 0, len(config.Profiles))
+		for _, profile := range config.Profiles {
+			actionIDs = append(actionIDs, desktopActionID(profile))
+		}
+		fmt.Fprintf(&sb, "Actions=%s;\n", strings.Join(actionIDs, ";"))
+
+		for i, profile := range config.Profiles {
+			fmt.Fprintf(&sb, "\n[Desktop Action %s]\n", actionIDs[i])
+			fmt.Fprintf(&sb, "Name=Open with tbml (%s)\n", profile.Label)
+			fmt.Fprintf(&sb, "Exec=%s\n", desktopEntryExec(command, "open", "-p", profile.Label, "%u"))
+		}
+	}
+
+	return sb.String()
+}
+
+// InstallDesktopEntry writes the tbml desktop entry to the user's
+// applications directory and returns the path of the written file.
+func InstallDesktopEntry(config Configuration, command []string, withActions bool) (string, error) {
+	applicationsDir, err := getDesktopApplicationsDir()
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	entryPath := filepath.Join(applicationsDir, desktopEntryFileName)
+	if err := ensureExists(entryPath, []byte(GenerateDesktopEntry(config, command, withActions))); err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	if err := updateDesktopDatabase(applicationsDir); err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	return entryPath, nil
+}
+
+// UninstallDesktopEntry removes the tbml desktop entry from the
+// user's applications directory, if it exists, and returns its path.
+func UninstallDesktopEntry() (string, error) {
+	applicationsDir, err := getDesktopApplicationsDir()
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	entryPath := filepath.Join(applicationsDir, desktopEntryFileName)
+	if err := os.Remove(entryPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", uerror.WithStackTrace(err)
+	}
+	if err := updateDesktopDatabase(applicationsDir); err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	return entryPath, nil
+}
+
+// SetDefaultBrowser registers the tbml desktop entry as the
+// desktop's default web browser.
+func SetDefaultBrowser() error {
+	xdgSettingsCmd := exec.Command("xdg-settings", "set", "default-web-browser", desktopEntryFileName)
+	xdgSettingsCmd.Stdout = os.Stdout
+	xdgSettingsCmd.Stderr = os.Stderr
+	if err := xdgSettingsCmd.Run(); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	return nil
+}
+
+func getDesktopApplicationsDir() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", uerror.WithStackTrace(err)
+		}
+		dataHome = filepath.Join(home, ".local/share")
+	}
+	applicationsDir := filepath.Join(dataHome, "applications")
+	if err := os.MkdirAll(applicationsDir, uio.FileModeURWXGRWXO); err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	return applicationsDir, nil
+}
+
+func updateDesktopDatabase(applicationsDir string) error {
+	if _, err := exec.LookPath("update-desktop-database"); err != nil {
+		// The database is only a cache; desktops that need it
+		// will also have the tool installed.
+		return nil
+	}
+	updateCmd := exec.Command("update-desktop-database", applicationsDir)
+	updateCmd.Stdout = os.Stdout
+	updateCmd.Stderr = os.Stderr
+	if err := updateCmd.Run(); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	return nil
+}
+
+func desktopActionID(profile ProfileConfiguration) string {
+	return fmt.Sprint("profile-", desktopActionInvalidCharsRE.ReplaceAllString(profile.Label, "-"))
+}
+
+func desktopEntryExec(command []string, args ...string) string {
+	quoted := make([]string, 0, len(command)+len(args))
+	for _, arg := range command {
+		quoted = append(quoted, quoteDesktopEntryArg(arg))
+	}
+	for _, arg := range args {
+		if arg == "%u" {
+			quoted = append(quoted, arg)
+		} else {
+			quoted = append(quoted, quoteDesktopEntryArg(arg))
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// quoteDesktopEntryArg quotes an argument of an Exec key according
+// to the desktop entry specification.
+func quoteDesktopEntryArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\"'\\><~|&;$*?#()`%") {
+		return arg
+	}
+	sb := strings.Builder{}
+	sb.WriteByte('"')
+	for _, r := range arg {
+		switch r {
+		case '"', '`', '$', '\\':
+			sb.WriteRune('\\')
+		case '%':
+			sb.WriteRune('%')
+		}
+		sb.WriteRune(r)
+	}
+	sb.WriteByte('"')
+	// Values of Exec keys are strings, so backslashes have to be
+	// escaped a second time.
+	return strings.ReplaceAll(sb.String(), "\\", "\\\\")
+}
@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	ustring "t0ast.cc/tbml/util/string"
+)
+
+func TestGenerateDesktopEntry(t *testing.T) {
+	config := Configuration{
+		Profiles: []ProfileConfiguration{
+			{
+				Label: "default",
+			},
+			{
+				Label: "secure profile",
+			},
+		},
+	}
+	command := []string{"/usr/bin/tbml", "--config", "/home/user/my tbml/config.json"}
+
+	t.Run("Without actions", func(t *testing.T) {
+		actual := GenerateDesktopEntry(config, command, false)
+
+		assert.Equal(t, ustring.TrimIndentation(`
+			[Desktop Entry]
+			Type=Application
+			Version=1.0
+			Name=tbml
+			GenericName=Web Browser
+			Comment=Open links in topic-isolated Tor Browser instances
+			Icon=torbrowser
+			Terminal=false
+			Categories=Network;WebBrowser;
+			MimeType=text/html;application/xhtml+xml;x-scheme-handler/http;x-scheme-handler/https;
+			Exec=/usr/bin/tbml --config "/home/user/my tbml/config.json" open %u
+
+		`), actual)
+	})
+
+	t.Run("With actions", func(t *testing.T) {
+		actual := GenerateDesktopEntry(config, command, true)
+
+		assert.Contains(t, actual, "Actions=profile-default;profile-secure-profile;\n")
+		assert.Contains(t, actual, ustring.TrimIndentation(`
+			[Desktop Action profile-default]
+			Name=Open with tbml (default)
+			Exec=/usr/bin/tbml --config "/home/user/my tbml/config.json" open -p default %u
+		`))
+		assert.Contains(t, actual, ustring.TrimIndentation(`
+			[Desktop Action profile-secure-profile]
+			Name=Open with tbml (secure profile)
+			Exec=/usr/bin/tbml --config "/home/user/my tbml/config.json" open -p "secure profile" %u
+		`))
+	})
+}
+
+func TestQuoteDesktopEntryArg(t *testing.T) {
+	assert.Equal(t, "plain", quoteDesktopEntryArg("plain"))
+	assert.Equal(t, `""`, quoteDesktopEntryArg(""))
+	assert.Equal(t, `"100%%"`, quoteDesktopEntryArg("100%"))
+	assert.Equal(t, `"\\$HOME"`, quoteDesktopEntryArg("$HOME"))
+	assert.Equal(t, `"a\\"b"`, quoteDesktopEntryArg(`a"b`))
+}
+
+func TestInstallDesktopEntry(t *testing.T) {
+	dataHome := t.TempDir()
+	origDataHome, hadDataHome := os.LookupEnv("XDG_DATA_HOME")
+	os.Setenv("XDG_DATA_HOME", dataHome)
+	defer func() {
+		if hadDataHome {
+			os.Setenv("XDG_DATA_HOME", origDataHome)
+		} else {
+			os.Unsetenv("XDG_DATA_HOME")
+		}
+	}()
+
+	config := Configuration{}
+	expectedPath := filepath.Join(dataHome, "applications", "tbml.desktop")
+
+	entryPath, err := InstallDesktopEntry(config, []string{"tbml"}, false)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedPath, entryPath)
+	assert.FileExists(t, expectedPath)
+
+	entryPath, err = UninstallDesktopEntry()
+	assert.NoError(t, err)
+	assert.Equal(t, expectedPath, entryPath)
+	assert.NoFileExists(t, expectedPath)
+
+	_, err = UninstallDesktopEntry()
+	assert.NoError(t, err)
+}
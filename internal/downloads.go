@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	uerror "t0ast.cc/tbml/util/error"
+	uio "t0ast.cc/tbml/util/io"
+)
+
+const (
+	// DownloadsInstance keeps the downloads of an instance in the
+	// instance's directory.
+	DownloadsInstance = "instance"
+	// DownloadsShared makes the host's downloads directory available
+	// to all instances.
+	DownloadsShared = "shared"
+	// DownloadsTopic keeps the downloads of each topic in a
+	// directory named after the topic under the profile's
+	// DownloadsRoot.
+	DownloadsTopic = "topic"
+)
+
+// relativeDownloadsPath is the path of the downloads directory inside
+// the sandbox, relative to the home directory. This is where firejail's
+// ${DOWNLOADS} points to in the instance's home.
+const relativeDownloadsPath = "Downloads"
+
+// topicDirNameChars are the characters that are used as they are in
+// the names of topic downloads directories.
+const topicDirNameChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789._ -"
+
+// getHostDownloadsDir returns the user's downloads directory on the
+// host.
+func getHostDownloadsDir() (string, error) {
+	if xdgUserDir, err := exec.LookPath("xdg-user-dir"); err == nil {
+		output, err := exec.Command(xdgUserDir, "DOWNLOAD").Output()
+		if dir := strings.TrimSpace(string(output)); err == nil && dir != "" {
+			return dir, nil
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	return filepath.Join(home, "Downloads"), nil
+}
+
+// getDownloadsRoot returns the directory that the per-topic downloads
+// directories of the profile are in.
+func getDownloadsRoot(profile ProfileConfiguration, configDir string) (string, error) {
+	if profile.DownloadsRoot != nil {
+		return resolveConfigurationPath(configDir, *profile.DownloadsRoot)
+	}
+	hostDownloadsDir, err := getHostDownloadsDir()
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	return filepath.Join(hostDownloadsDir, "tbml", profile.Label), nil
+}
+
+// getTopicDirName returns the name of the downloads directory of the
+// topic. Other characters than topicDirNameChars and a leading "." are
+// percent-encoded, so that every topic has a directory of its own that
+// isn't hidden.
+func getTopicDirName(topic string) string {
+	if topic == "" {
+		return "%"
+	}
+	name := strings.Builder{}
+	for i := 0; i < len(topic); i++ {
+		c := topic[i]
+		if (i == 0 && c == '.') || strings.IndexByte(topicDirNameChars, c) == -1 {
+			fmt.Fprintf(&name, "%%%02X", c)
+		} else {
+			name.WriteByte(c)
+		}
+	}
+	return name.String()
+}
+
+// GetDownloadsDir returns the directory on the host that the
+// downloads of the instance end up in while it's used for the topic.
+func GetDownloadsDir(config Configuration, profile ProfileConfiguration, instance ProfileInstance, topic, configDir string) (string, error) {
+	switch profile.Downloads {
+	case "", DownloadsInstance:
+		return filepath.Join(getInstanceDir(config, instance), relativeDownloadsPath), nil
+	case DownloadsShared:
+		return getHostDownloadsDir()
+	case DownloadsTopic:
+		root, err := getDownloadsRoot(profile, configDir)
+		if err != nil {
+			return "", uerror.WithStackTrace(err)
+		}
+		return filepath.Join(root, getTopicDirName(topic)), nil
+	default:
+		return "", uerror.StackTracef("Profile %s: Unknown downloads policy %q (expected \"%s\", \"%s\" or \"%s\")", profile.Label, profile.Downloads, DownloadsInstance, DownloadsShared, DownloadsTopic)
+	}
+}
+
+// getDownloadsBinds returns the binds that make the downloads
+// directory of the instance available inside the sandbox.
+func getDownloadsBinds(config Configuration, profile ProfileConfiguration, instance ProfileInstance, topic, configDir string) ([]sandboxBind, error) {
+	downloadsDir, err := GetDownloadsDir(config, profile, instance, topic, configDir)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	if profile.Downloads == "" || profile.Downloads == DownloadsInstance {
+		// The instance directory is the home directory inside the
+		// sandbox already.
+		return nil, uerror.WithStackTrace(os.MkdirAll(downloadsDir, uio.FileModeURWXGRWXO))
+	}
+	return []sandboxBind{
+		{
+			Dst: relativeDownloadsPath,
+			Src: downloadsDir,
+		},
+	}, nil
+}
@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetDownloadsBinds(t *testing.T) {
+	config, profile, instance, instanceDir, cleanUpEnvironment := setUpTestEnvironment(t)
+	defer cleanUpEnvironment()
+
+	// Make sure the fallback for the host's downloads directory is
+	// used instead of xdg-user-dir.
+	tmpHome := t.TempDir()
+	for k, v := range map[string]string{
+		"HOME": tmpHome,
+		"PATH": "",
+	} {
+		orig := os.Getenv(k)
+		os.Setenv(k, v)
+		k := k
+		defer os.Setenv(k, orig)
+	}
+
+	root := "/srv/downloads"
+	relativeRoot := "downloads"
+
+	testCases := []struct {
+		desc string
+
+		downloads     string
+		downloadsRoot *string
+		topic         string
+		expected      []sandboxBind
+	}{
+		{
+			desc: "Default",
+
+			topic:    "news",
+			expected: nil,
+		},
+		{
+			desc: "Instance",
+
+			downloads: DownloadsInstance,
+			topic:     "news",
+			expected:  nil,
+		},
+		{
+			desc: "Shared",
+
+			downloads: DownloadsShared,
+			topic:     "news",
+			expected: []sandboxBind{
+				{
+					Dst: "Downloads",
+					Src: filepath.Join(tmpHome, "Downloads"),
+				},
+			},
+		},
+		{
+			desc: "Topic",
+
+			downloads: DownloadsTopic,
+			topic:     "news",
+			expected: []sandboxBind{
+				{
+					Dst: "Downloads",
+					Src: filepath.Join(tmpHome, "Downloads/tbml/test/news"),
+				},
+			},
+		},
+		{
+			desc: "Topic with root",
+
+			downloads:     DownloadsTopic,
+			downloadsRoot: &root,
+			topic:         "news",
+			expected: []sandboxBind{
+				{
+					Dst: "Downloads",
+					Src: "/srv/downloads/news",
+				},
+			},
+		},
+		{
+			desc: "Topic with relative root",
+
+			downloads:     DownloadsTopic,
+			downloadsRoot: &relativeRoot,
+			topic:         "news",
+			expected: []sandboxBind{
+				{
+					Dst: "Downloads",
+					Src: "/etc/tbml/downloads/news",
+				},
+			},
+		},
+		{
+			desc: "Topic with unsafe name",
+
+			downloads:     DownloadsTopic,
+			downloadsRoot: &root,
+			topic:         "../work/secret",
+			expected: []sandboxBind{
+				{
+					Dst: "Downloads",
+					Src: "/srv/downloads/%2E.%2Fwork%2Fsecret",
+				},
+			},
+		},
+		{
+			desc: "Topic that is a relative path",
+
+			downloads:     DownloadsTopic,
+			downloadsRoot: &root,
+			topic:         "..",
+			expected: []sandboxBind{
+				{
+					Dst: "Downloads",
+					Src: "/srv/downloads/%2E.",
+				},
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			profile.Downloads = tC.downloads
+			profile.DownloadsRoot = tC.downloadsRoot
+
+			actual, err := getDownloadsBinds(config, profile, instance, tC.topic, "/etc/tbml")
+			assert.NoError(t, err)
+			assert.Equal(t, tC.expected, actual)
+
+			if tC.expected == nil {
+				assert.DirExists(t, filepath.Join(instanceDir, "Downloads"))
+			}
+		})
+	}
+
+	t.Run("Unknown policy", func(t *testing.T) {
+		profile.Downloads = "everywhere"
+		_, err := getDownloadsBinds(config, profile, instance, "news", "/etc/tbml")
+		assert.EqualError(t, err, "Profile test: Unknown downloads policy \"everywhere\" (expected \"instance\", \"shared\" or \"topic\")")
+	})
+}
+
+func TestGetTopicDirName(t *testing.T) {
+	testCases := []struct {
+		desc string
+
+		topic    string
+		expected string
+	}{
+		{
+			desc: "Safe name",
+
+			topic:    "news 2.0",
+			expected: "news 2.0",
+		},
+		{
+			desc: "Slash",
+
+			topic:    "a/b",
+			expected: "a%2Fb",
+		},
+		{
+			desc: "Colon",
+
+			topic:    "a:b",
+			expected: "a%3Ab",
+		},
+		{
+			desc: "Percent sign",
+
+			topic:    "a%2Fb",
+			expected: "a%252Fb",
+		},
+		{
+			desc: "Leading dot",
+
+			topic:    ".hidden",
+			expected: "%2Ehidden",
+		},
+		{
+			desc: "Non-ASCII",
+
+			topic:    "ä",
+			expected: "%C3%A4",
+		},
+		{
+			desc: "Empty",
+
+			topic:    "",
+			expected: "%",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			assert.Equal(t, tC.expected, getTopicDirName(tC.topic))
+		})
+	}
+}
@@ -0,0 +1,334 @@
+package internal
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	uerror "t0ast.cc/tbml/util/error"
+	uio "t0ast.cc/tbml/util/io"
+)
+
+var ErrExtensionUnsigned error = errors.New("Extension is not signed")
+var ErrExtensionIDMissing error = errors.New("Extension has no add-on ID")
+var ErrExtensionNotCached error = errors.New("Extension is not in the extension cache")
+var ErrExtensionHashMismatch error = errors.New("Extension doesn't match its SHA-256 hash")
+
+// extensionSignatureFiles are the files that an XPI signed by Mozilla
+// has at least one of. Tor Browser refuses to install unsigned
+// extensions.
+var extensionSignatureFiles = []string{
+	"META-INF/cose.sig",
+	"META-INF/mozilla.rsa",
+}
+
+// Extension identifies an XPI by its add-on ID, its version and the
+// SHA-256 hash of its content. Profiles refer to extensions in the
+// extension cache like this.
+type Extension struct {
+	ID      string
+	SHA256  string
+	Version string
+}
+
+// UnmarshalJSON also accepts a plain add-on ID, which is how instances
+// recorded their installed extensions before versions and hashes were
+// recorded.
+func (e *Extension) UnmarshalJSON(data []byte) error {
+	var id string
+	if err := json.Unmarshal(data, &id); err == nil {
+		*e = Extension{ID: id}
+		return nil
+	}
+	type extension Extension
+	return json.Unmarshal(data, (*extension)(e))
+}
+
+// ConfiguredExtension is an extension from a profile's ExtensionFiles
+// or Extensions, together with the file it's installed from.
+type ConfiguredExtension struct {
+	Extension
+	File string
+}
+
+// ExtensionStatus compares an extension that a profile is configured
+// with to the version that an instance of the profile has installed.
+type ExtensionStatus struct {
+	Configured ConfiguredExtension
+	// Installed is nil if the instance hasn't installed the
+	// extension yet.
+	Installed *Extension
+}
+
+// IsOutdated reports whether the instance doesn't have the configured
+// extension file installed yet. This is the case until the instance is
+// started the next time.
+func (s ExtensionStatus) IsOutdated() bool {
+	return s.Installed == nil || s.Installed.SHA256 != s.Configured.SHA256
+}
+
+type extensionGeckoSettings struct {
+	Gecko *struct {
+		ID string `json:"id"`
+	} `json:"gecko"`
+}
+
+// extensionManifest is the part of an extension's manifest.json that
+// tbml needs.
+type extensionManifest struct {
+	Applications            *extensionGeckoSettings `json:"applications"`
+	BrowserSpecificSettings *extensionGeckoSettings `json:"browser_specific_settings"`
+	Version                 string                  `json:"version"`
+}
+
+// readExtension returns the add-on ID and version from the manifest of
+// the XPI, along with the hash of the XPI. The add-on ID is what
+// Firefox expects the XPI to be named after in the profile's
+// extensions directory.
+func readExtension(xpiPath string) (Extension, error) {
+	xpi, err := zip.OpenReader(xpiPath)
+	if err != nil {
+		return Extension{}, uerror.StackTracef("Failed to open extension %s: %w", xpiPath, err)
+	}
+	defer xpi.Close()
+
+	signed := false
+	var manifestFile *zip.File
+	for _, f := range xpi.File {
+		for _, signatureFile := range extensionSignatureFiles {
+			if f.Name == signatureFile {
+				signed = true
+			}
+		}
+		if f.Name == "manifest.json" {
+			manifestFile = f
+		}
+	}
+	if !signed {
+		return Extension{}, uerror.StackTracef("%w: %s", ErrExtensionUnsigned, xpiPath)
+	}
+	if manifestFile == nil {
+		return Extension{}, uerror.StackTracef("Extension %s has no manifest.json", xpiPath)
+	}
+
+	manifestReader, err := manifestFile.Open()
+	if err != nil {
+		return Extension{}, uerror.WithStackTrace(err)
+	}
+	defer manifestReader.Close()
+	manifest := extensionManifest{}
+	if err := json.NewDecoder(manifestReader).Decode(&manifest); err != nil {
+		return Extension{}, uerror.StackTracef("Failed to parse manifest.json of extension %s: %w", xpiPath, err)
+	}
+
+	id := ""
+	for _, settings := range []*extensionGeckoSettings{manifest.BrowserSpecificSettings, manifest.Applications} {
+		if settings != nil && settings.Gecko != nil && settings.Gecko.ID != "" {
+			id = settings.Gecko.ID
+			break
+		}
+	}
+	if id == "" {
+		return Extension{}, uerror.StackTracef("%w: %s (browser_specific_settings.gecko.id is not set)", ErrExtensionIDMissing, xpiPath)
+	}
+
+	hash, err := hashFile(xpiPath)
+	if err != nil {
+		return Extension{}, uerror.WithStackTrace(err)
+	}
+
+	return Extension{
+		ID:      id,
+		SHA256:  hash,
+		Version: manifest.Version,
+	}, nil
+}
+
+// hashFile returns the hex-encoded SHA-256 hash of the file's content.
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// GetConfiguredExtensions reads the extension files of the profile
+// and looks up its extensions in the extension cache.
+func GetConfiguredExtensions(config Configuration, profile ProfileConfiguration, configDir string) ([]ConfiguredExtension, error) {
+	extensions := []ConfiguredExtension{}
+	sourceByID := make(map[string]string)
+	add := func(extension ConfiguredExtension, source string) error {
+		if otherSource, ok := sourceByID[extension.ID]; ok {
+			return uerror.StackTracef("Profile %s: %s and %s are both extension %s", profile.Label, otherSource, source, extension.ID)
+		}
+		sourceByID[extension.ID] = source
+		extensions = append(extensions, extension)
+		return nil
+	}
+
+	for _, extensionFile := range profile.ExtensionFiles {
+		if !filepath.IsAbs(extensionFile) {
+			extensionFile = filepath.Join(configDir, extensionFile)
+		}
+		extension, err := readExtension(extensionFile)
+		if err != nil {
+			return nil, uerror.StackTracef("Profile %s: %w", profile.Label, err)
+		}
+		configured := ConfiguredExtension{
+			Extension: extension,
+			File:      extensionFile,
+		}
+		if err := add(configured, "extension file "+extensionFile); err != nil {
+			return nil, uerror.WithStackTrace(err)
+		}
+	}
+
+	if len(profile.Extensions) == 0 {
+		return extensions, nil
+	}
+	cachePath, err := GetExtensionCachePath(config)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	for _, reference := range profile.Extensions {
+		configured, err := resolveCachedExtension(cachePath, reference)
+		if err != nil {
+			return nil, uerror.StackTracef("Profile %s: %w", profile.Label, err)
+		}
+		if err := add(configured, "cached extension "+configured.SHA256); err != nil {
+			return nil, uerror.WithStackTrace(err)
+		}
+	}
+	return extensions, nil
+}
+
+// GetExtensionStatuses compares the configured extensions of a profile
+// to the extensions that the instance has installed.
+func GetExtensionStatuses(configured []ConfiguredExtension, instance ProfileInstance) []ExtensionStatus {
+	statuses := []ExtensionStatus{}
+	for _, extension := range configured {
+		status := ExtensionStatus{Configured: extension}
+		for i, installed := range instance.InstalledExtensions {
+			if installed.ID == extension.ID {
+				status.Installed = &instance.InstalledExtensions[i]
+				break
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// GetExtensionCachePath returns the directory of the extension cache.
+// Extensions in it are named after their SHA-256 hash.
+func GetExtensionCachePath(config Configuration) (string, error) {
+	if config.ExtensionCachePath != nil {
+		return *config.ExtensionCachePath, nil
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	return filepath.Join(cache, "tbml-extensions"), nil
+}
+
+func getCachedExtensionPath(cachePath, hash string) string {
+	return filepath.Join(cachePath, strings.ToLower(hash)+".xpi")
+}
+
+// resolveCachedExtension finds the extension that the reference from a
+// profile's Extensions refers to in the extension cache. The cached
+// file must match the reference's hash, ID and, if it's set, version.
+func resolveCachedExtension(cachePath string, reference Extension) (ConfiguredExtension, error) {
+	if reference.ID == "" || reference.SHA256 == "" {
+		return ConfiguredExtension{}, uerror.StackTracef("Extension %q needs both an ID and a SHA256", reference.ID)
+	}
+
+	extensionPath := getCachedExtensionPath(cachePath, reference.SHA256)
+	exists, err := uio.FileExists(extensionPath)
+	if err != nil {
+		return ConfiguredExtension{}, uerror.WithStackTrace(err)
+	}
+	if !exists {
+		return ConfiguredExtension{}, uerror.StackTracef("%w: %s %s with SHA-256 %s; import it with \"tbml extensions import\"", ErrExtensionNotCached, reference.ID, formatReferenceVersion(reference.Version), reference.SHA256)
+	}
+
+	extension, err := readExtension(extensionPath)
+	if err != nil {
+		return ConfiguredExtension{}, uerror.WithStackTrace(err)
+	}
+	if !strings.EqualFold(extension.SHA256, reference.SHA256) {
+		return ConfiguredExtension{}, uerror.StackTracef("%w: %s has SHA-256 %s", ErrExtensionHashMismatch, extensionPath, extension.SHA256)
+	}
+	if extension.ID != reference.ID || (reference.Version != "" && extension.Version != reference.Version) {
+		return ConfiguredExtension{}, uerror.StackTracef("Extension with SHA-256 %s is %s %s, not %s %s", reference.SHA256, extension.ID, extension.Version, reference.ID, formatReferenceVersion(reference.Version))
+	}
+
+	return ConfiguredExtension{
+		Extension: extension,
+		File:      extensionPath,
+	}, nil
+}
+
+func formatReferenceVersion(version string) string {
+	if version == "" {
+		return "(any version)"
+	}
+	return version
+}
+
+// ImportExtension copies the XPI into the extension cache and returns
+// how profiles refer to it in their Extensions.
+func ImportExtension(config Configuration, xpiPath string) (Extension, error) {
+	extension, err := readExtension(xpiPath)
+	if err != nil {
+		return Extension{}, uerror.WithStackTrace(err)
+	}
+
+	cachePath, err := GetExtensionCachePath(config)
+	if err != nil {
+		return Extension{}, uerror.WithStackTrace(err)
+	}
+	if err := os.MkdirAll(cachePath, uio.FileModeURWXGRWXO); err != nil {
+		return Extension{}, uerror.WithStackTrace(err)
+	}
+
+	extensionPath := getCachedExtensionPath(cachePath, extension.SHA256)
+	exists, err := uio.FileExists(extensionPath)
+	if err != nil {
+		return Extension{}, uerror.WithStackTrace(err)
+	}
+	if exists {
+		return extension, nil
+	}
+
+	// The extension is copied under a temporary name first, so that
+	// a file named after a hash always has that hash.
+	tmpFile, err := os.CreateTemp(cachePath, ".import-*")
+	if err != nil {
+		return Extension{}, uerror.WithStackTrace(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if err := tmpFile.Close(); err != nil {
+		return Extension{}, uerror.WithStackTrace(err)
+	}
+	if err := uio.CopyFile(xpiPath, tmpFile.Name()); err != nil {
+		return Extension{}, uerror.WithStackTrace(err)
+	}
+	if err := os.Rename(tmpFile.Name(), extensionPath); err != nil {
+		return Extension{}, uerror.WithStackTrace(err)
+	}
+	return extension, nil
+}
@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	uio "t0ast.cc/tbml/util/io"
+)
+
+func TestReadExtension(t *testing.T) {
+	testCases := []struct {
+		desc string
+
+		expectedErr     error
+		expectedID      string
+		expectedVersion string
+		file            string
+	}{
+		{
+			desc: "Named after ID",
+
+			expectedID:      "foo@t0ast.cc",
+			expectedVersion: "1.0",
+			file:            "foo@t0ast.cc.xpi",
+		},
+		{
+			desc: "Not named after ID",
+
+			expectedID:      "qux@t0ast.cc",
+			expectedVersion: "1.0",
+			file:            "renamed.xpi",
+		},
+		{
+			desc: "Legacy ID",
+
+			expectedID:      "legacy@t0ast.cc",
+			expectedVersion: "1.0",
+			file:            "legacy.xpi",
+		},
+		{
+			desc: "Unsigned",
+
+			expectedErr: ErrExtensionUnsigned,
+			file:        "unsigned.xpi",
+		},
+		{
+			desc: "No ID",
+
+			expectedErr: ErrExtensionIDMissing,
+			file:        "no-id.xpi",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			extension, err := readExtension("testdata/ensure-extensions/extensions/" + tC.file)
+			if tC.expectedErr != nil {
+				assert.ErrorIs(t, err, tC.expectedErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tC.expectedID, extension.ID)
+			assert.Equal(t, tC.expectedVersion, extension.Version)
+			assert.Len(t, extension.SHA256, 64)
+		})
+	}
+
+	t.Run("Not an XPI", func(t *testing.T) {
+		_, err := readExtension("testdata/ensure-files/user.js")
+		assert.Error(t, err)
+	})
+}
+
+func TestUnmarshalExtension(t *testing.T) {
+	instance := ProfileInstance{}
+	require.NoError(t, json.Unmarshal([]byte(`{"InstalledExtensions":["foo@t0ast.cc",{"ID":"bar@t0ast.cc","SHA256":"abc","Version":"1.0"}]}`), &instance))
+	assert.Equal(t, []Extension{
+		{ID: "foo@t0ast.cc"},
+		{ID: "bar@t0ast.cc", SHA256: "abc", Version: "1.0"},
+	}, instance.InstalledExtensions)
+}
+
+func TestGetExtensionStatuses(t *testing.T) {
+	profile := ProfileConfiguration{
+		ExtensionFiles: []string{
+			"extensions/foo-1.1.xpi",
+			"extensions/bar@t0ast.cc.xpi",
+			"extensions/baz@t0ast.cc.xpi",
+		},
+		Label: "test",
+	}
+	configured, err := GetConfiguredExtensions(Configuration{}, profile, "testdata/ensure-extensions")
+	require.NoError(t, err)
+	require.Len(t, configured, 3)
+
+	oldFoo, err := readExtension("testdata/ensure-extensions/extensions/foo@t0ast.cc.xpi")
+	require.NoError(t, err)
+	instance := ProfileInstance{
+		InstalledExtensions: []Extension{oldFoo, configured[1].Extension},
+	}
+
+	statuses := GetExtensionStatuses(configured, instance)
+	assert.Len(t, statuses, 3)
+
+	assert.True(t, statuses[0].IsOutdated(), "Older version")
+	assert.Equal(t, "1.0", statuses[0].Installed.Version)
+	assert.Equal(t, "1.1", statuses[0].Configured.Version)
+
+	assert.False(t, statuses[1].IsOutdated(), "Same version")
+
+	assert.True(t, statuses[2].IsOutdated(), "Not installed")
+	assert.Nil(t, statuses[2].Installed)
+}
+
+func TestExtensionCache(t *testing.T) {
+	cachePath := filepath.Join(t.TempDir(), "extensions")
+	config := Configuration{
+		ExtensionCachePath: &cachePath,
+	}
+
+	foo, err := ImportExtension(config, "testdata/ensure-extensions/extensions/foo-1.1.xpi")
+	require.NoError(t, err)
+	assert.Equal(t, "foo@t0ast.cc", foo.ID)
+	assert.Equal(t, "1.1", foo.Version)
+	assert.FileExists(t, filepath.Join(cachePath, foo.SHA256+".xpi"))
+
+	t.Run("Import again", func(t *testing.T) {
+		again, err := ImportExtension(config, "testdata/ensure-extensions/extensions/foo-1.1.xpi")
+		assert.NoError(t, err)
+		assert.Equal(t, foo, again)
+	})
+
+	t.Run("Import unsigned extension", func(t *testing.T) {
+		_, err := ImportExtension(config, "testdata/ensure-extensions/extensions/unsigned.xpi")
+		assert.ErrorIs(t, err, ErrExtensionUnsigned)
+	})
+
+	testCases := []struct {
+		desc string
+
+		expectResolved bool
+		expectedErr    error
+		reference      Extension
+	}{
+		{
+			desc: "Cached",
+
+			expectResolved: true,
+			reference:      foo,
+		},
+		{
+			desc: "Cached without version",
+
+			expectResolved: true,
+			reference:      Extension{ID: foo.ID, SHA256: foo.SHA256},
+		},
+		{
+			desc: "Other version",
+
+			reference: Extension{ID: foo.ID, SHA256: foo.SHA256, Version: "1.0"},
+		},
+		{
+			desc: "Other ID",
+
+			reference: Extension{ID: "bar@t0ast.cc", SHA256: foo.SHA256},
+		},
+		{
+			desc: "Not cached",
+
+			expectedErr: ErrExtensionNotCached,
+			reference:   Extension{ID: foo.ID, SHA256: strings.Repeat("0", 64)},
+		},
+		{
+			desc: "No hash",
+
+			reference: Extension{ID: foo.ID},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			profile := ProfileConfiguration{
+				Extensions: []Extension{tC.reference},
+				Label:      "test",
+			}
+			configured, err := GetConfiguredExtensions(config, profile, "")
+			if tC.expectResolved {
+				assert.NoError(t, err)
+				assert.Equal(t, []ConfiguredExtension{
+					{
+						Extension: foo,
+						File:      filepath.Join(cachePath, foo.SHA256+".xpi"),
+					},
+				}, configured)
+				return
+			}
+			assert.Error(t, err)
+			if tC.expectedErr != nil {
+				assert.ErrorIs(t, err, tC.expectedErr)
+			}
+		})
+	}
+
+	t.Run("Hash mismatch", func(t *testing.T) {
+		require.NoError(t, uio.CopyFile("testdata/ensure-extensions/extensions/baz@t0ast.cc.xpi", filepath.Join(cachePath, foo.SHA256+".xpi")))
+
+		_, err := GetConfiguredExtensions(config, ProfileConfiguration{Extensions: []Extension{foo}}, "")
+		assert.ErrorIs(t, err, ErrExtensionHashMismatch)
+	})
+}
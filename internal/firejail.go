@@ -0,0 +1,292 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	uerror "t0ast.cc/tbml/util/error"
+	uio "t0ast.cc/tbml/util/io"
+)
+
+var ErrForbiddenFirejailDirective error = errors.New("Forbidden firejail directive")
+
+// firejailPathDirectives are the firejail directives that make the
+// path they are given unavailable or unusable inside the sandbox.
+// Read-only paths are fine, because tbml only needs to read the files
+// in them and to connect to the control socket.
+var firejailPathDirectives = map[string]bool{
+	"blacklist":       true,
+	"blacklist-nolog": true,
+	"noexec":          true,
+	"tmpfs":           true,
+}
+
+// firejailMaxIncludeDepth is how deeply firejail nests included files.
+const firejailMaxIncludeDepth = 16
+
+// firejailProfileLine is a line of a firejail profile or of a file
+// that it includes.
+type firejailProfileLine struct {
+	// Location is where the line is, like "line 3" or "line 3 of
+	// /etc/firejail/disable-common.inc".
+	Location string
+	Text     string
+}
+
+// getFirejailProtectedPaths returns the paths, relative to the home
+// directory, that tbml needs to be usable inside the sandbox when the
+// Tor Browser bundle is at relativeBundlePath.
+func getFirejailProtectedPaths(relativeBundlePath string) []string {
+	return []string{
+		"control-socket",
+		"mothership-connector",
+		filepath.Join(relativeBundlePath, bundleDataPath, "Browser/.mozilla/native-messaging-hosts"),
+	}
+}
+
+// renderFirejailProfile returns the firejail profile for instances of
+// the given profile. This is the embedded torbrowser-launcher profile,
+// or the profile's replacement file, followed by the profile's extra
+// directives. relativeBundlePath is the path of the Tor Browser bundle
+// in the instances' home directory.
+func renderFirejailProfile(profile ProfileConfiguration, configDir, relativeBundlePath string) ([]byte, error) {
+	content := tblFirejailProfile
+	if profile.FirejailProfileFile != nil {
+		profilePath := *profile.FirejailProfileFile
+		if !filepath.IsAbs(profilePath) {
+			profilePath = filepath.Join(configDir, profilePath)
+		}
+		var err error
+		content, err = os.ReadFile(profilePath)
+		if err != nil {
+			return nil, uerror.WithStackTrace(err)
+		}
+	}
+
+	buf := bytes.Buffer{}
+	buf.Write(content)
+	if len(profile.FirejailDirectives) > 0 {
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			buf.WriteString("\n")
+		}
+		buf.WriteString("\n# Directives from the tbml configuration of profile ")
+		buf.WriteString(profile.Label)
+		buf.WriteString("\n")
+		for _, directive := range profile.FirejailDirectives {
+			buf.WriteString(directive)
+			buf.WriteString("\n")
+		}
+	}
+
+	if err := validateFirejailProfile(buf.Bytes(), relativeBundlePath); err != nil {
+		return nil, uerror.StackTracef("Profile %s: %w", profile.Label, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// validateFirejailProfile checks that the firejail profile, including
+// the files it includes, doesn't contain directives that would break
+// tbml, like blacklisting the control socket or disallowing Unix
+// sockets.
+func validateFirejailProfile(content []byte, relativeBundlePath string) error {
+	lines, err := getFirejailProfileLines(content, "", 0)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+
+	protectedPaths := getFirejailProtectedPaths(relativeBundlePath)
+	ignored := []string{}
+	noblacklisted := []string{}
+	var homeWhitelist *firejailProfileLine
+	whitelisted := make(map[string]bool)
+LINES:
+	for i, line := range lines {
+		for _, prefix := range ignored {
+			if strings.HasPrefix(line.Text, prefix) {
+				continue LINES
+			}
+		}
+		fields := strings.Fields(line.Text)
+		name, args := fields[0], strings.TrimSpace(strings.TrimPrefix(line.Text, fields[0]))
+
+		switch name {
+		case "ignore":
+			ignored = append(ignored, args)
+			continue
+		case "noblacklist":
+			noblacklisted = append(noblacklisted, args)
+			continue
+		case "blacklist", "blacklist-nolog":
+			for _, pattern := range noblacklisted {
+				if matched, err := filepath.Match(pattern, args); pattern == args || (err == nil && matched) {
+					continue LINES
+				}
+			}
+		case "private-home":
+			// Firejail copies the files into the private home
+			// directory, and the control socket can't be copied.
+			return fmt.Errorf("%w in %s: %q would make ${HOME}/%s unusable", ErrForbiddenFirejailDirective, line.Location, line.Text, protectedPaths[0])
+		case "whitelist":
+			if isFirejailHomePattern(args) {
+				if homeWhitelist == nil {
+					homeWhitelist = &lines[i]
+				}
+				for _, protectedPath := range protectedPaths {
+					if firejailPathCovers(args, protectedPath) {
+						whitelisted[protectedPath] = true
+					}
+				}
+			}
+		case "protocol":
+			hasUnix, onlyChanges := false, true
+			for _, protocol := range strings.Split(args, ",") {
+				switch protocol {
+				case "-unix":
+					return fmt.Errorf("%w in %s: %q disallows the unix protocol, which the control socket needs", ErrForbiddenFirejailDirective, line.Location, line.Text)
+				case "unix", "+unix":
+					hasUnix = true
+				}
+				if !strings.HasPrefix(protocol, "+") && !strings.HasPrefix(protocol, "-") {
+					onlyChanges = false
+				}
+			}
+			// A list of changes like "+inet6" keeps the protocols
+			// that are allowed already.
+			if !hasUnix && !onlyChanges {
+				return fmt.Errorf("%w in %s: %q doesn't allow the unix protocol, which the control socket needs", ErrForbiddenFirejailDirective, line.Location, line.Text)
+			}
+		}
+
+		if firejailPathDirectives[name] {
+			for _, protectedPath := range protectedPaths {
+				if firejailPathCovers(args, protectedPath) {
+					return fmt.Errorf("%w in %s: %q would make ${HOME}/%s unusable", ErrForbiddenFirejailDirective, line.Location, line.Text, protectedPath)
+				}
+			}
+		}
+	}
+
+	// Whitelisting a path in the home directory hides everything in
+	// it that isn't whitelisted as well.
+	if homeWhitelist != nil {
+		for _, protectedPath := range protectedPaths {
+			if !whitelisted[protectedPath] {
+				return fmt.Errorf("%w in %s: %q hides ${HOME}/%s, which needs to be whitelisted too", ErrForbiddenFirejailDirective, homeWhitelist.Location, homeWhitelist.Text, protectedPath)
+			}
+		}
+	}
+	return nil
+}
+
+// getFirejailProfileLines returns the directives of the profile at
+// path (empty for the rendered profile), with the lines of the files
+// it includes in place of the include directives. Included files that
+// don't exist are skipped, like firejail does for .local files.
+func getFirejailProfileLines(content []byte, path string, depth int) ([]firejailProfileLine, error) {
+	lines := []firejailProfileLine{}
+	for i, text := range strings.Split(string(content), "\n") {
+		text = strings.TrimSpace(text)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		location := fmt.Sprint("line ", i+1)
+		if path != "" {
+			location = fmt.Sprintf("line %d of %s", i+1, path)
+		}
+		if !strings.HasPrefix(text, "include ") {
+			lines = append(lines, firejailProfileLine{
+				Location: location,
+				Text:     text,
+			})
+			continue
+		}
+
+		if depth >= firejailMaxIncludeDepth {
+			return nil, fmt.Errorf("%w in %s: %q nests included files more than %d levels deep", ErrForbiddenFirejailDirective, location, text, firejailMaxIncludeDepth)
+		}
+		includePath, err := findFirejailInclude(strings.TrimSpace(strings.TrimPrefix(text, "include ")))
+		if err != nil {
+			return nil, uerror.WithStackTrace(err)
+		}
+		if includePath == "" {
+			continue
+		}
+		includeContent, err := os.ReadFile(includePath)
+		if err != nil {
+			return nil, uerror.WithStackTrace(err)
+		}
+		includeLines, err := getFirejailProfileLines(includeContent, includePath, depth+1)
+		if err != nil {
+			return nil, uerror.WithStackTrace(err)
+		}
+		lines = append(lines, includeLines...)
+	}
+	return lines, nil
+}
+
+// findFirejailInclude returns the path of the file that an include
+// directive refers to, or an empty string if it doesn't exist.
+// Relative paths are looked up in the user's firejail configuration
+// directory first and in /etc/firejail then, like firejail does.
+func findFirejailInclude(name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	name = strings.ReplaceAll(name, "${CFG}", "/etc/firejail")
+	name = strings.ReplaceAll(name, "${HOME}", home)
+	if strings.HasPrefix(name, "~/") {
+		name = filepath.Join(home, strings.TrimPrefix(name, "~/"))
+	}
+
+	candidates := []string{name}
+	if !filepath.IsAbs(name) {
+		candidates = []string{filepath.Join(home, ".config/firejail", name), filepath.Join("/etc/firejail", name)}
+	}
+	for _, candidate := range candidates {
+		exists, err := uio.FileExists(candidate)
+		if err != nil {
+			return "", uerror.WithStackTrace(err)
+		}
+		if exists {
+			return candidate, nil
+		}
+	}
+	return "", nil
+}
+
+// isFirejailHomePattern reports whether the firejail path pattern is
+// in the home directory.
+func isFirejailHomePattern(pattern string) bool {
+	return pattern == "${HOME}" || pattern == "~" || pattern == "${DOWNLOADS}" || strings.HasPrefix(pattern, "${HOME}/") || strings.HasPrefix(pattern, "~/") || strings.HasPrefix(pattern, "${DOWNLOADS}/")
+}
+
+// firejailPathCovers reports whether the firejail path pattern
+// matches the path (relative to the home directory) or one of its
+// parents. Only patterns starting with the home directory are
+// considered.
+func firejailPathCovers(pattern, path string) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+	var relPattern string
+	switch {
+	case pattern == "${HOME}" || pattern == "~":
+		return true
+	case strings.HasPrefix(pattern, "${HOME}/"):
+		relPattern = strings.TrimPrefix(pattern, "${HOME}/")
+	case strings.HasPrefix(pattern, "~/"):
+		relPattern = strings.TrimPrefix(pattern, "~/")
+	default:
+		return false
+	}
+
+	for candidate := path; candidate != "."; candidate = filepath.Dir(candidate) {
+		if matched, err := filepath.Match(relPattern, candidate); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	uio "t0ast.cc/tbml/util/io"
+	ustring "t0ast.cc/tbml/util/string"
+)
+
+func TestRenderFirejailProfile(t *testing.T) {
+	replacementFile := "replacement.profile"
+	brokenFile := "broken.profile"
+
+	testCases := []struct {
+		desc string
+
+		profile       ProfileConfiguration
+		expected      string
+		expectedError string
+	}{
+		{
+			desc: "Embedded profile",
+
+			profile: ProfileConfiguration{
+				Label: "test",
+			},
+			expected: string(tblFirejailProfile),
+		},
+		{
+			desc: "Extra directives",
+
+			profile: ProfileConfiguration{
+				FirejailDirectives: []string{"nosound", "whitelist ${HOME}/Downloads/topic"},
+				Label:              "test",
+			},
+			expected: string(tblFirejailProfile) + ustring.TrimIndentation(`
+
+				# Directives from the tbml configuration of profile test
+				nosound
+				whitelist ${HOME}/Downloads/topic
+			`) + "\n",
+		},
+		{
+			desc: "Replacement file with extra directives",
+
+			profile: ProfileConfiguration{
+				FirejailDirectives:  []string{"nosound"},
+				FirejailProfileFile: &replacementFile,
+				Label:               "test",
+			},
+			expected: ustring.TrimIndentation(`
+				include torbrowser-launcher.local
+				private-tmp
+
+				# Directives from the tbml configuration of profile test
+				nosound
+			`) + "\n",
+		},
+		{
+			desc: "Blacklisted control socket",
+
+			profile: ProfileConfiguration{
+				FirejailDirectives: []string{"blacklist ${HOME}/control-*"},
+				Label:              "test",
+			},
+			expectedError: "Profile test: Forbidden firejail directive in line 66: \"blacklist ${HOME}/control-*\" would make ${HOME}/control-socket unusable",
+		},
+		{
+			desc: "Protocol without unix",
+
+			profile: ProfileConfiguration{
+				FirejailDirectives: []string{"protocol inet,inet6"},
+				Label:              "test",
+			},
+			expectedError: "Profile test: Forbidden firejail directive in line 66: \"protocol inet,inet6\" doesn't allow the unix protocol, which the control socket needs",
+		},
+		{
+			desc: "Broken replacement file",
+
+			profile: ProfileConfiguration{
+				FirejailProfileFile: &brokenFile,
+				Label:               "test",
+			},
+			expectedError: "Profile test: Forbidden firejail directive in line 2: \"blacklist ${HOME}/.local/share/torbrowser\" would make ${HOME}/.local/share/torbrowser/tbb/x86_64/tor-browser/Browser/TorBrowser/Data/Browser/.mozilla/native-messaging-hosts unusable",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			actual, err := renderFirejailProfile(tC.profile, "testdata/firejail", testRelativeBundlePath)
+			if tC.expectedError != "" {
+				assert.EqualError(t, err, tC.expectedError)
+				assert.ErrorIs(t, err, ErrForbiddenFirejailDirective)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tC.expected, string(actual))
+		})
+	}
+}
+
+func TestValidateFirejailProfile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	includeDir := filepath.Join(home, ".config/firejail")
+	require.NoError(t, os.MkdirAll(includeDir, uio.FileModeURWXGRWXO))
+	require.NoError(t, os.WriteFile(filepath.Join(includeDir, "unsafe.inc"), []byte("nosound\nblacklist ${HOME}/mothership-connector\n"), uio.FileModeURWGRWO))
+	require.NoError(t, os.WriteFile(filepath.Join(includeDir, "nested.inc"), []byte("include unsafe.inc\n"), uio.FileModeURWGRWO))
+	require.NoError(t, os.WriteFile(filepath.Join(includeDir, "loop.inc"), []byte("include loop.inc\n"), uio.FileModeURWGRWO))
+
+	testCases := []struct {
+		desc string
+
+		profile       string
+		expectedError string
+	}{
+		{
+			desc: "Read-only home",
+
+			profile: "read-only ${HOME}",
+		},
+		{
+			desc: "Included file with forbidden directive",
+
+			profile:       "nosound\ninclude nested.inc",
+			expectedError: fmt.Sprintf("Forbidden firejail directive in line 2 of %s: \"blacklist ${HOME}/mothership-connector\" would make ${HOME}/mothership-connector unusable", filepath.Join(includeDir, "unsafe.inc")),
+		},
+		{
+			desc: "Included file that doesn't exist",
+
+			profile: "include missing.local",
+		},
+		{
+			desc: "Endlessly nested included files",
+
+			profile:       "include loop.inc",
+			expectedError: fmt.Sprintf("Forbidden firejail directive in line 1 of %s: \"include loop.inc\" nests included files more than 16 levels deep", filepath.Join(includeDir, "loop.inc")),
+		},
+		{
+			desc: "Ignored directive",
+
+			profile: "ignore blacklist ${HOME}/mothership-connector\ninclude unsafe.inc",
+		},
+		{
+			desc: "Directory that is not blacklisted",
+
+			profile: "noblacklist ${HOME}/mothership-*\ninclude unsafe.inc",
+		},
+		{
+			desc: "Private home",
+
+			profile:       "private-home .mozilla,control-socket",
+			expectedError: "Forbidden firejail directive in line 1: \"private-home .mozilla,control-socket\" would make ${HOME}/control-socket unusable",
+		},
+		{
+			desc: "Whitelist hiding the Mothership connector",
+
+			profile:       "whitelist ${DOWNLOADS}\nwhitelist ${HOME}/control-socket\nwhitelist ~/.local/share/torbrowser",
+			expectedError: "Forbidden firejail directive in line 1: \"whitelist ${DOWNLOADS}\" hides ${HOME}/mothership-connector, which needs to be whitelisted too",
+		},
+		{
+			desc: "Whitelist with all paths",
+
+			profile: "whitelist ${DOWNLOADS}\nwhitelist ${HOME}/control-socket\nwhitelist ${HOME}/mothership-connector\nwhitelist ~/.local/share/torbrowser",
+		},
+		{
+			desc: "Whitelist outside of home",
+
+			profile: "whitelist /usr/share/torbrowser",
+		},
+		{
+			desc: "Protocol that removes unix",
+
+			profile:       "protocol +inet6,-unix",
+			expectedError: "Forbidden firejail directive in line 1: \"protocol +inet6,-unix\" disallows the unix protocol, which the control socket needs",
+		},
+		{
+			desc: "Protocol that adds to the allowed protocols",
+
+			profile: "protocol +inet6",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			err := validateFirejailProfile([]byte(tC.profile), testRelativeBundlePath)
+			if tC.expectedError != "" {
+				assert.EqualError(t, err, tC.expectedError)
+				assert.ErrorIs(t, err, ErrForbiddenFirejailDirective)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestFirejailPathCovers(t *testing.T) {
+	testCases := []struct {
+		desc string
+
+		pattern  string
+		path     string
+		expected bool
+	}{
+		{
+			desc: "Home",
+
+			pattern:  "${HOME}",
+			path:     "control-socket",
+			expected: true,
+		},
+		{
+			desc: "Exact path",
+
+			pattern:  "${HOME}/mothership-connector",
+			path:     "mothership-connector",
+			expected: true,
+		},
+		{
+			desc: "Parent directory",
+
+			pattern:  "~/.local/share",
+			path:     ".local/share/torbrowser/tbb",
+			expected: true,
+		},
+		{
+			desc: "Glob",
+
+			pattern:  "${HOME}/.local/*",
+			path:     ".local/share/torbrowser/tbb",
+			expected: true,
+		},
+		{
+			desc: "Other path in home",
+
+			pattern:  "${HOME}/Downloads",
+			path:     "control-socket",
+			expected: false,
+		},
+		{
+			desc: "Outside of home",
+
+			pattern:  "/tmp",
+			path:     "control-socket",
+			expected: false,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			assert.Equal(t, tC.expected, firejailPathCovers(tC.pattern, tC.path))
+		})
+	}
+}
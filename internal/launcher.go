@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	uerror "t0ast.cc/tbml/util/error"
+	uio "t0ast.cc/tbml/util/io"
+)
+
+const defaultLauncherLocale = "en-US"
+
+// launcherLocales are the locales that torbrowser-launcher knows Tor
+// Browser to be available in.
+var launcherLocales = []string{"ar", "ca", "cs", "da", "de", "el", "en-US", "es-AR", "es-ES", "fa", "fr", "ga-IE", "he", "hu", "id", "is", "it", "ja", "ka", "ko", "lt", "mk", "ms", "my", "nb-NO", "nl", "pl", "pt-BR", "ro", "ru", "sv-SE", "th", "tr", "uk", "vi", "zh-CN", "zh-TW"}
+
+// LauncherSettings are the torbrowser-launcher settings that tbml
+// manages. Settings that are not set get torbrowser-launcher's
+// defaults.
+type LauncherSettings struct {
+	DownloadOverTor *bool
+	ForceEnUS       *bool
+	Mirror          *string
+	TorSOCKSAddress *string
+}
+
+// getLauncherSettingsDefaults returns the default values of the
+// settings in torbrowser-launcher's settings.json.
+func getLauncherSettingsDefaults() (map[string]interface{}, error) {
+	defaults := make(map[string]interface{})
+	if err := json.Unmarshal(tblDefaultSettings, &defaults); err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	return defaults, nil
+}
+
+// reconcileLauncherSettings writes the settings into the instance's
+// torbrowser-launcher settings.json. Settings that tbml doesn't manage,
+// like "installed", are kept as they are.
+func reconcileLauncherSettings(settings LauncherSettings, instanceDir string) error {
+	settingsPath := filepath.Join(instanceDir, tblSettingsPath)
+
+	current := make(map[string]interface{})
+	currentBytes, err := os.ReadFile(settingsPath)
+	if err == nil {
+		if err := json.Unmarshal(currentBytes, &current); err != nil {
+			return uerror.StackTracef("Failed to parse %s: %w", settingsPath, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return uerror.WithStackTrace(err)
+	}
+
+	defaults, err := getLauncherSettingsDefaults()
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	for key, value := range defaults {
+		if _, ok := current[key]; !ok {
+			current[key] = value
+		}
+	}
+
+	managed := map[string]interface{}{
+		"download_over_tor": settings.DownloadOverTor,
+		"force_en-US":       settings.ForceEnUS,
+		"mirror":            settings.Mirror,
+		"tor_socks_address": settings.TorSOCKSAddress,
+	}
+	for key, value := range managed {
+		switch v := value.(type) {
+		case *bool:
+			if v != nil {
+				current[key] = *v
+				continue
+			}
+		case *string:
+			if v != nil {
+				current[key] = *v
+				continue
+			}
+		}
+		current[key] = defaults[key]
+	}
+
+	newBytes, err := json.Marshal(current)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	if bytes.Equal(newBytes, currentBytes) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(settingsPath), uio.FileModeURWXGRWXO); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	return uerror.WithStackTrace(os.WriteFile(settingsPath, newBytes, uio.FileModeURWGRWO))
+}
+
+// getSystemLocale returns the locale of the environment, like
+// "de_DE", or an empty string if there is none.
+func getSystemLocale() string {
+	for _, envVar := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		value := os.Getenv(envVar)
+		if value == "" {
+			continue
+		}
+		value = strings.SplitN(value, ".", 2)[0]
+		value = strings.SplitN(value, "@", 2)[0]
+		if value == "C" || value == "POSIX" {
+			return ""
+		}
+		return value
+	}
+	return ""
+}
+
+// getLauncherLocale returns the locale of Tor Browser that
+// torbrowser-launcher installs with the settings. This mirrors
+// torbrowser-launcher's own choice based on the system locale.
+func getLauncherLocale(settings LauncherSettings) string {
+	if settings.ForceEnUS != nil && *settings.ForceEnUS {
+		return defaultLauncherLocale
+	}
+	return matchLauncherLocale(getSystemLocale())
+}
+
+func matchLauncherLocale(systemLocale string) string {
+	if systemLocale == "" {
+		return defaultLauncherLocale
+	}
+
+	isAvailable := func(locale string) bool {
+		for _, available := range launcherLocales {
+			if locale == available {
+				return true
+			}
+		}
+		return false
+	}
+
+	locale := strings.ReplaceAll(systemLocale, "_", "-")
+	if isAvailable(locale) {
+		return locale
+	}
+	language := strings.SplitN(locale, "-", 2)[0]
+	if isAvailable(language) {
+		return language
+	}
+	for _, available := range launcherLocales {
+		if strings.HasPrefix(available, language+"-") {
+			return available
+		}
+	}
+	return defaultLauncherLocale
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	uio "t0ast.cc/tbml/util/io"
+)
+
+func TestReconcileLauncherSettings(t *testing.T) {
+	readSettings := func(t *testing.T, instanceDir string) map[string]interface{} {
+		settingsBytes, err := os.ReadFile(filepath.Join(instanceDir, tblSettingsPath))
+		require.NoError(t, err)
+		settings := make(map[string]interface{})
+		require.NoError(t, json.Unmarshal(settingsBytes, &settings))
+		return settings
+	}
+
+	defaults, err := getLauncherSettingsDefaults()
+	require.NoError(t, err)
+
+	falseValue := false
+	mirror := "https://tor.example.com/"
+	configured := LauncherSettings{
+		DownloadOverTor: &falseValue,
+		Mirror:          &mirror,
+	}
+
+	testCases := []struct {
+		desc string
+
+		existing string
+		expected map[string]interface{}
+		settings LauncherSettings
+	}{
+		{
+			desc: "Write defaults initially",
+
+			expected: defaults,
+		},
+		{
+			desc: "Apply configured settings",
+
+			existing: `{"installed": true, "download_over_tor": true, "tor_socks_address": "127.0.0.1:9050", "mirror": "https://dist.torproject.org/", "force_en-US": false}`,
+			expected: map[string]interface{}{
+				"installed":         true,
+				"download_over_tor": false,
+				"tor_socks_address": "127.0.0.1:9050",
+				"mirror":            mirror,
+				"force_en-US":       false,
+			},
+			settings: configured,
+		},
+		{
+			desc: "Revert unconfigured settings to defaults",
+
+			existing: `{"installed": true, "download_over_tor": false, "tor_socks_address": "127.0.0.1:9150", "mirror": "https://tor.example.com/", "force_en-US": true}`,
+			expected: map[string]interface{}{
+				"installed":         true,
+				"download_over_tor": true,
+				"tor_socks_address": "127.0.0.1:9050",
+				"mirror":            "https://dist.torproject.org/",
+				"force_en-US":       false,
+			},
+		},
+		{
+			desc: "Keep unmanaged settings",
+
+			existing: `{"installed": true, "latest_version": "11.0.4"}`,
+			expected: map[string]interface{}{
+				"installed":         true,
+				"latest_version":    "11.0.4",
+				"download_over_tor": true,
+				"tor_socks_address": "127.0.0.1:9050",
+				"mirror":            "https://dist.torproject.org/",
+				"force_en-US":       false,
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			instanceDir := t.TempDir()
+			if tC.existing != "" {
+				settingsPath := filepath.Join(instanceDir, tblSettingsPath)
+				require.NoError(t, os.MkdirAll(filepath.Dir(settingsPath), uio.FileModeURWXGRWXO))
+				require.NoError(t, os.WriteFile(settingsPath, []byte(tC.existing), uio.FileModeURWGRWO))
+			}
+
+			assert.NoError(t, reconcileLauncherSettings(tC.settings, instanceDir))
+			assert.Equal(t, tC.expected, readSettings(t, instanceDir))
+		})
+	}
+
+	t.Run("Invalid settings file", func(t *testing.T) {
+		instanceDir := t.TempDir()
+		settingsPath := filepath.Join(instanceDir, tblSettingsPath)
+		require.NoError(t, os.MkdirAll(filepath.Dir(settingsPath), uio.FileModeURWXGRWXO))
+		require.NoError(t, os.WriteFile(settingsPath, []byte("not json"), uio.FileModeURWGRWO))
+
+		assert.Error(t, reconcileLauncherSettings(configured, instanceDir))
+	})
+}
+
+func TestMatchLauncherLocale(t *testing.T) {
+	testCases := []struct {
+		desc string
+
+		expected     string
+		systemLocale string
+	}{
+		{
+			desc: "No system locale",
+
+			expected:     "en-US",
+			systemLocale: "",
+		},
+		{
+			desc: "Exact match",
+
+			expected:     "pt-BR",
+			systemLocale: "pt_BR",
+		},
+		{
+			desc: "Language match",
+
+			expected:     "de",
+			systemLocale: "de_AT",
+		},
+		{
+			desc: "Region variant of language",
+
+			expected:     "sv-SE",
+			systemLocale: "sv_FI",
+		},
+		{
+			desc: "Unavailable language",
+
+			expected:     "en-US",
+			systemLocale: "eo",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			assert.Equal(t, tC.expected, matchLauncherLocale(tC.systemLocale))
+		})
+	}
+}
+
+func TestGetLauncherLocale(t *testing.T) {
+	origLCAll := os.Getenv("LC_ALL")
+	os.Setenv("LC_ALL", "de_DE.UTF-8")
+	defer os.Setenv("LC_ALL", origLCAll)
+
+	assert.Equal(t, "de", getLauncherLocale(LauncherSettings{}))
+
+	trueValue := true
+	assert.Equal(t, "en-US", getLauncherLocale(LauncherSettings{ForceEnUS: &trueValue}))
+}
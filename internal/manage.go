@@ -14,6 +14,7 @@ import (
 )
 
 var ErrInstanceInUse error = errors.New("Instance in use")
+var ErrInvalidConfiguration error = errors.New("Invalid configuration")
 
 func ReadConfiguration(configFile string) (config Configuration, configDir string, err error) {
 	configBytes, err := os.ReadFile(configFile)
@@ -21,7 +22,7 @@ func ReadConfiguration(configFile string) (config Configuration, configDir strin
 		return Configuration{}, "", uerror.WithStackTrace(err)
 	}
 	if err := json.Unmarshal(configBytes, &config); err != nil {
-		return Configuration{}, "", uerror.WithStackTrace(err)
+		return Configuration{}, "", uerror.WithStackTrace(describeConfigurationError(configFile, configBytes, err))
 	}
 
 	if config.ProfilePath == "" {
@@ -30,19 +31,76 @@ func ReadConfiguration(configFile string) (config Configuration, configDir strin
 			return Configuration{}, "", uerror.WithStackTrace(err)
 		}
 		config.ProfilePath = filepath.Join(cache, "tbml")
-	} else if strings.HasPrefix(config.ProfilePath, "~/") {
-		home, err := os.UserHomeDir()
+	} else {
+		config.ProfilePath, err = resolveConfigurationPath(filepath.Dir(configFile), config.ProfilePath)
+		if err != nil {
+			return Configuration{}, "", uerror.StackTracef("Failed to resolve profile path: %w", err)
+		}
+	}
+
+	if config.BundlePath != nil {
+		bundlePath, err := resolveConfigurationPath(filepath.Dir(configFile), *config.BundlePath)
 		if err != nil {
-			return Configuration{}, "", uerror.StackTracef("Failed to expand home directory in profile path: %w", err)
+			return Configuration{}, "", uerror.StackTracef("Failed to resolve bundle path: %w", err)
 		}
-		config.ProfilePath = filepath.Join(home, config.ProfilePath[2:])
-	} else if !filepath.IsAbs(config.ProfilePath) {
-		config.ProfilePath = filepath.Join(filepath.Dir(configFile), config.ProfilePath)
+		config.BundlePath = &bundlePath
+	}
+
+	if config.ExtensionCachePath != nil {
+		extensionCachePath, err := resolveConfigurationPath(filepath.Dir(configFile), *config.ExtensionCachePath)
+		if err != nil {
+			return Configuration{}, "", uerror.StackTracef("Failed to resolve extension cache path: %w", err)
+		}
+		config.ExtensionCachePath = &extensionCachePath
 	}
 
 	return config, filepath.Dir(configFile), nil
 }
 
+// resolveConfigurationPath expands a leading "~/" to the home
+// directory and makes relative paths relative to the configuration
+// directory.
+func resolveConfigurationPath(configDir, path string) (string, error) {
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", uerror.StackTracef("Failed to expand home directory: %w", err)
+		}
+		return filepath.Join(home, path[2:]), nil
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Join(configDir, path), nil
+	}
+	return path, nil
+}
+
+// describeConfigurationError adds the position of a JSON error, if
+// it has one, to the error.
+func describeConfigurationError(configFile string, configBytes []byte, err error) error {
+	var offset int64 = -1
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+	} else if errors.As(err, &typeErr) {
+		offset = typeErr.Offset
+	}
+	if offset < 0 || offset > int64(len(configBytes)) {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidConfiguration, configFile, err)
+	}
+
+	line, column := 1, 1
+	for _, b := range configBytes[:offset] {
+		if b == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return fmt.Errorf("%w: %s:%d:%d: %v", ErrInvalidConfiguration, configFile, line, column, err)
+}
+
 func GetProfileInstances(config Configuration) ([]ProfileInstance, error) {
 	dirEntries, err := os.ReadDir(config.ProfilePath)
 	if errors.Is(err, fs.ErrNotExist) {
@@ -126,6 +126,18 @@ func TestReadConfiguration(t *testing.T) {
 				expected.ProfilePath = "testdata/tbml/profiles"
 			},
 		},
+		{
+			desc: "Bundle path",
+
+			configFileName: "config-bundle-path.json",
+			prepareExpected: func(expected *internal.Configuration) {
+				home, err := os.UserHomeDir()
+				assert.NoError(t, err)
+				bundlePath := filepath.Join(home, ".local/share/tbml/bundles")
+				expected.BundlePath = &bundlePath
+				expected.ProfilePath = "testdata/tbml/profiles"
+			},
+		},
 	}
 	for _, tC := range testCases {
 		t.Run(tC.desc, func(t *testing.T) {
@@ -145,6 +157,12 @@ func TestReadConfigurationNonexistent(t *testing.T) {
 	assert.ErrorIs(t, err, fs.ErrNotExist)
 }
 
+func TestReadConfigurationInvalid(t *testing.T) {
+	_, _, err := internal.ReadConfiguration("testdata/config-invalid.json")
+	assert.ErrorIs(t, err, internal.ErrInvalidConfiguration)
+	assert.Contains(t, err.Error(), "testdata/config-invalid.json:5:4: ")
+}
+
 func TestGetProfileInstances(t *testing.T) {
 	config := getConfigurationFixture()
 	config.ProfilePath = "testdata/instances/profiles"
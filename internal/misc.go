@@ -8,20 +8,74 @@ import (
 const genericErrorExitCode = 1
 
 type Configuration struct {
-	ProfilePath string
-	Profiles    []ProfileConfiguration
+	// BundlePath is the bundle store, where instances share their
+	// Tor Browser bundles. Instances mount the bundle read-only, so
+	// Tor Browser's and torbrowser-launcher's updates are disabled
+	// for them. The firejail sandbox can only bind directories as
+	// root, so otherwise the bundle is mounted with bindfs, which
+	// needs to be installed.
+	BundlePath         *string
+	ExtensionCachePath *string
+	ProfilePath        string
+	Profiles           []ProfileConfiguration
+	RoutingRules       []RoutingRule
+	TorBrowser         *TorBrowserConfiguration
+}
+
+// TorBrowserConfiguration makes tbml install Tor Browser into the
+// bundle store itself instead of leaving it to torbrowser-launcher.
+// Instances use the installed bundle like any shared bundle, so it is
+// read-only for them and mounted with bindfs by the firejail sandbox
+// when it doesn't run as root.
+type TorBrowserConfiguration struct {
+	// Channel is "release" (the default) or "alpha".
+	Channel string
+	// DownloadProxy is the proxy URL that Tor Browser is downloaded
+	// through. It defaults to the system's Tor. An empty string
+	// disables the proxy.
+	DownloadProxy *string
+	// Mirror is the base URL (http, https or file) that Tor Browser
+	// is downloaded from. It defaults to https://dist.torproject.org/.
+	Mirror *string
+	// Version pins the installed Tor Browser version. If it is not
+	// set, the current version of the channel is installed. Versions
+	// look like "12.5.1" or "13.0a5".
+	Version *string
 }
 
 type ProfileConfiguration struct {
-	ExtensionFiles []string
-	Label          string
-	UserChromeFile *string
-	UserJSFile     *string
+	BookmarksFile       *string
+	Downloads           string
+	DownloadsRoot       *string
+	ExtensionFiles      []string
+	Extensions          []Extension
+	FirejailDirectives  []string
+	FirejailProfileFile *string
+	Label               string
+	LauncherSettings    LauncherSettings
+	// Network is "host" (the default) or "isolated". An isolated
+	// instance gets a network namespace of its own that only allows
+	// outbound connections. With bubblewrap, pasta connects it
+	// through a userspace network stack. With firejail, it is
+	// connected through a macvlan device that has an address in the
+	// host's LAN, and firejail's default filter drops inbound
+	// connections.
+	Network string
+	// NetworkInterface is the host's interface that an isolated
+	// network namespace is connected through. The firejail sandbox
+	// requires it. pasta uses the interface of the default route if
+	// it isn't set.
+	NetworkInterface *string
+	Policies         map[string]interface{}
+	Sandbox          string
+	SaveSession      bool
+	UserChromeFile   *string
+	UserJSFile       *string
 }
 
 type ProfileInstance struct {
 	Created             time.Time
-	InstalledExtensions []string
+	InstalledExtensions []Extension
 	InstanceLabel       string
 	LastUsed            time.Time
 	ProfileLabel        string
@@ -1,4 +1,167 @@
-const port = browser.runtime.connectNative("mothership_native_connector")
+const minReconnectDelay = 1000
+const maxReconnectDelay = 30000
+
+let port
+let reconnectDelay = minReconnectDelay
+
+// commands are run on requests from tbml. Each takes the request's
+// arguments and returns the result of the request.
+const commands = {
+	"close-tab": async ({ tabId }) => {
+		await browser.tabs.remove(tabId)
+	},
+	"focus-window": async ({ windowId }) => {
+		await browser.windows.update(windowId, {
+			focused: true,
+		})
+	},
+	"get-version": async () => {
+		const browserInfo = await browser.runtime.getBrowserInfo()
+		return {
+			browser: browserInfo.version,
+			extension: browser.runtime.getManifest().version,
+		}
+	},
+	"list-tabs": async () => {
+		const tabs = await browser.tabs.query({})
+		return tabs.map(({ active, id, title, url, windowId }) => ({
+			active,
+			id,
+			title,
+			url,
+			windowId,
+		}))
+	},
+	"ping": async () => "pong",
+}
+
+async function handleRequest({ args, command, id }) {
+	const response = {
+		type: "response",
+		id,
+	}
+	try {
+		if (!Object.prototype.hasOwnProperty.call(commands, command)) {
+			throw new Error(`Unknown command "${command}"`)
+		}
+		const result = await commands[command](args || {})
+		if (result !== undefined) {
+			response.result = result
+		}
+	} catch (e) {
+		response.error = e.message || String(e)
+	}
+	port.postMessage({
+		type: "tbml",
+		data: response,
+	})
+}
+
+// Once tbml asks for it, the open tabs are reported to tbml whenever
+// they change, so that tbml can save them when the browser exits.
+const sessionReportDelay = 500
+
+let watchingSession = false
+let sessionReportTimeout
+
+async function reportSession() {
+	const tabs = await browser.tabs.query({})
+	port.postMessage({
+		type: "tbml",
+		data: {
+			type: "session",
+			tabs: tabs
+				.filter(tab => !isOnStartPage(tab))
+				.map(({ title, url }) => ({
+					title,
+					url,
+				})),
+		},
+	})
+}
+
+function scheduleSessionReport() {
+	clearTimeout(sessionReportTimeout)
+	sessionReportTimeout = setTimeout(reportSession, sessionReportDelay)
+}
+
+function watchSession() {
+	if (!watchingSession) {
+		watchingSession = true
+		browser.tabs.onCreated.addListener(scheduleSessionReport)
+		browser.tabs.onUpdated.addListener(scheduleSessionReport, {
+			properties: ["title", "url"],
+		})
+		browser.tabs.onMoved.addListener(scheduleSessionReport)
+		browser.tabs.onAttached.addListener(scheduleSessionReport)
+		browser.tabs.onRemoved.addListener((tabId, { isWindowClosing }) => {
+			// When the browser exits, its windows close and the
+			// session must not be reported as empty.
+			if (!isWindowClosing) {
+				scheduleSessionReport()
+			}
+		})
+	}
+	reportSession()
+}
+
+// The bookmarks from the profile's bookmarks file are kept in a folder
+// that tbml manages. Changes made to it in the browser are undone.
+const managedBookmarksTitle = "tbml"
+const managedBookmarksParentId = "unfiled_____"
+
+let managedBookmarks
+let managedBookmarkIds = new Set()
+let applyingBookmarks = false
+
+async function createBookmarks(parentId, bookmarks) {
+	for (const { children, title, url } of bookmarks || []) {
+		const node = await browser.bookmarks.create({
+			parentId,
+			title,
+			url,
+		})
+		managedBookmarkIds.add(node.id)
+		if (!url) {
+			await createBookmarks(node.id, children)
+		}
+	}
+}
+
+async function applyBookmarks() {
+	applyingBookmarks = true
+	try {
+		const siblings = await browser.bookmarks.getChildren(managedBookmarksParentId)
+		for (const node of siblings) {
+			if (node.type === "folder" && node.title === managedBookmarksTitle) {
+				await browser.bookmarks.removeTree(node.id)
+			}
+		}
+
+		managedBookmarkIds = new Set()
+		const folder = await browser.bookmarks.create({
+			parentId: managedBookmarksParentId,
+			title: managedBookmarksTitle,
+		})
+		managedBookmarkIds.add(folder.id)
+		await createBookmarks(folder.id, managedBookmarks)
+	} catch (e) {
+		console.error("Failed to apply bookmarks:", e)
+	} finally {
+		applyingBookmarks = false
+	}
+}
+
+function reapplyIfManaged(...ids) {
+	if (!applyingBookmarks && managedBookmarks && ids.some(id => managedBookmarkIds.has(id))) {
+		applyBookmarks()
+	}
+}
+
+browser.bookmarks.onChanged.addListener(id => reapplyIfManaged(id))
+browser.bookmarks.onCreated.addListener((id, { parentId }) => reapplyIfManaged(parentId))
+browser.bookmarks.onMoved.addListener((id, { oldParentId, parentId }) => reapplyIfManaged(id, oldParentId, parentId))
+browser.bookmarks.onRemoved.addListener((id, { parentId }) => reapplyIfManaged(id, parentId))
 
 function isOnStartPage(tab) {
 	return [
@@ -10,52 +173,108 @@ function isOnStartPage(tab) {
 	].includes(tab.url)
 }
 
-port.onMessage.addListener(async msg => {
+// Tabs that a URL was opened in. Until the navigation is committed,
+// they still look like they are on the start page.
+const navigatingTabIds = new Set()
+
+browser.tabs.onUpdated.addListener((tabId, { url }) => {
+	if (url !== undefined && !isOnStartPage({ url })) {
+		navigatingTabIds.delete(tabId)
+	}
+})
+browser.tabs.onRemoved.addListener(tabId => navigatingTabIds.delete(tabId))
+
+// Tabs are opened one after another, so that of several URLs that tbml
+// sends at once, like the tabs of a restored session, only the first
+// one is opened instead of the start page.
+let openTabQueue = Promise.resolve()
+
+async function openTab(url) {
+	let openedTab
+	if (url && url !== "") {
+		const activeTabs = await browser.tabs.query({
+			active: true,
+		})
+		if (activeTabs.length > 0 && isOnStartPage(activeTabs[0]) && !navigatingTabIds.has(activeTabs[0].id)) {
+			openedTab = activeTabs[0]
+			navigatingTabIds.add(openedTab.id)
+			await browser.tabs.update(openedTab.id, {
+				url,
+			})
+		} else {
+			openedTab = await browser.tabs.create({
+				url,
+			})
+			navigatingTabIds.add(openedTab.id)
+		}
+	} else {
+		openedTab = await browser.tabs.create({})
+	}
+	await browser.windows.update(openedTab.windowId, {
+		focused: true,
+	})
+	await port.postMessage({
+		type: "tbml",
+		data: {
+			type: "opened-tab",
+			url,
+		},
+	})
+}
+
+async function handleMessage(msg) {
 	console.log("Received:", msg)
 
+	if (msg.type === "connector-state") {
+		console.log("Connector state:", msg.data)
+		if (msg.data === "connected") {
+			reconnectDelay = minReconnectDelay
+			// tbml recognizes Mothership's control socket connection by
+			// this message, so it's sent again on every connection.
+			port.postMessage({
+				type: "tbml",
+				data: "Hello from Mothership! :>"
+			})
+		}
+		return
+	}
+
 	if (typeof msg.data === "object") {
 		switch (msg.data.type) {
+			case "bookmarks":
+				managedBookmarks = msg.data.bookmarks || []
+				await applyBookmarks()
+				break
+			case "watch-session":
+				watchSession()
+				break
+			case "request":
+				await handleRequest(msg.data)
+				break
 			case "open-tab":
-				const { url } = msg.data
-				let openedTab
-				if (url && url !== "") {
-					const activeTabs = await browser.tabs.query({
-						active: true,
-					})
-					if (activeTabs.length > 0 && isOnStartPage(activeTabs[0])) {
-						openedTab = activeTabs[0]
-						await browser.tabs.update(openedTab.id, {
-							url,
-						})
-					} else {
-						openedTab = await browser.tabs.create({
-							url,
-						})
-					}
-				} else {
-					openedTab = await browser.tabs.create({})
-				}
-				await browser.windows.update(openedTab.windowId, {
-					focused: true,
-				})
-				await port.postMessage({
-					type: "tbml",
-					data: {
-						type: "opened-tab",
-						url,
-					},
-				})
+				openTabQueue = openTabQueue
+					.then(() => openTab(msg.data.url))
+					.catch(e => console.error("Failed to open tab:", e))
+				await openTabQueue
+				break
 		}
 	}
-})
+}
 
-console.log("Control socket:", controlSocketPath)
-port.postMessage({
-	type: "init-control-socket-path",
-	data: controlSocketPath,
-})
+function connect() {
+	port = browser.runtime.connectNative("mothership_native_connector")
+	port.onMessage.addListener(handleMessage)
+	port.onDisconnect.addListener(p => {
+		console.log("Connector disconnected:", p.error, "Reconnecting in", reconnectDelay, "ms")
+		setTimeout(connect, reconnectDelay)
+		reconnectDelay = Math.min(reconnectDelay * 2, maxReconnectDelay)
+	})
 
-port.postMessage({
-	type: "tbml",
-	data: "Hello from Mothership! :>"
-})
+	console.log("Control socket:", controlSocketPath)
+	port.postMessage({
+		type: "init-control-socket-path",
+		data: controlSocketPath,
+	})
+}
+
+connect()
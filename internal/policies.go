@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	uerror "t0ast.cc/tbml/util/error"
+	uio "t0ast.cc/tbml/util/io"
+)
+
+const mothershipExtensionID = "mothership@tbml.t0ast.cc"
+
+var ErrForbiddenPolicy error = errors.New("Forbidden policy")
+
+// renderPolicies returns the content of the enterprise policies file
+// for instances of the profile. Next to the profile's policies, it
+// force-installs Mothership, so that it can't be disabled or removed
+// from within Tor Browser. home is the home directory inside the
+// sandbox. Tor Browser can't update a shared bundle, which is
+// read-only, so its updates are disabled for it.
+func renderPolicies(profile ProfileConfiguration, home, relativeBundlePath string, sharedBundle bool) ([]byte, error) {
+	policies := make(map[string]interface{})
+	for name, policy := range profile.Policies {
+		policies[name] = policy
+	}
+
+	extensionSettings := make(map[string]interface{})
+	if policy, ok := policies["ExtensionSettings"]; ok {
+		settings, ok := policy.(map[string]interface{})
+		if !ok {
+			return nil, uerror.StackTracef("Profile %s: The ExtensionSettings policy must be an object", profile.Label)
+		}
+		if _, ok := settings[mothershipExtensionID]; ok {
+			return nil, uerror.StackTracef("Profile %s: %w: ExtensionSettings must not contain %s, which tbml needs", profile.Label, ErrForbiddenPolicy, mothershipExtensionID)
+		}
+		for id, setting := range settings {
+			extensionSettings[id] = setting
+		}
+	}
+	extensionSettings[mothershipExtensionID] = map[string]interface{}{
+		"installation_mode": "force_installed",
+		"install_url":       "file://" + filepath.Join(home, getRelativeProfilePath(relativeBundlePath), "extensions", fmt.Sprint(mothershipExtensionID, ".xpi")),
+	}
+	policies["ExtensionSettings"] = extensionSettings
+	if sharedBundle {
+		policies["DisableAppUpdate"] = true
+	}
+
+	policiesBytes, err := json.MarshalIndent(map[string]interface{}{
+		"policies": policies,
+	}, "", "  ")
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	return policiesBytes, nil
+}
+
+// ensurePolicies writes the enterprise policies file into the
+// instance's bundle.
+func ensurePolicies(profile ProfileConfiguration, home, instanceDir, relativeBundlePath string, sharedBundle bool) error {
+	policiesBytes, err := renderPolicies(profile, home, relativeBundlePath, sharedBundle)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	policiesPath := filepath.Join(instanceDir, relativeBundlePath, bundleDistributionPath, "policies.json")
+	if err := os.MkdirAll(filepath.Dir(policiesPath), uio.FileModeURWXGRWXO); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	return uerror.WithStackTrace(os.WriteFile(policiesPath, policiesBytes, uio.FileModeURWGRWO))
+}
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderPolicies(t *testing.T) {
+	mothershipSettings := map[string]interface{}{
+		"installation_mode": "force_installed",
+		"install_url":       "file:///home/user/" + filepath.Join(getRelativeProfilePath(testRelativeBundlePath), "extensions/mothership@tbml.t0ast.cc.xpi"),
+	}
+
+	testCases := []struct {
+		desc string
+
+		expected     map[string]interface{}
+		expectedErr  error
+		policies     string
+		sharedBundle bool
+	}{
+		{
+			desc: "No policies",
+
+			expected: map[string]interface{}{
+				"ExtensionSettings": map[string]interface{}{
+					"mothership@tbml.t0ast.cc": mothershipSettings,
+				},
+			},
+		},
+		{
+			desc: "Profile policies",
+
+			expected: map[string]interface{}{
+				"BlockAboutConfig": true,
+				"ExtensionSettings": map[string]interface{}{
+					"*": map[string]interface{}{
+						"installation_mode": "blocked",
+					},
+					"mothership@tbml.t0ast.cc": mothershipSettings,
+				},
+				"Preferences": map[string]interface{}{
+					"browser.startup.homepage": map[string]interface{}{
+						"Status": "locked",
+						"Value":  "about:blank",
+					},
+				},
+			},
+			policies: `{
+				"BlockAboutConfig": true,
+				"ExtensionSettings": {"*": {"installation_mode": "blocked"}},
+				"Preferences": {"browser.startup.homepage": {"Status": "locked", "Value": "about:blank"}}
+			}`,
+		},
+		{
+			desc: "Shared bundle",
+
+			expected: map[string]interface{}{
+				"DisableAppUpdate": true,
+				"ExtensionSettings": map[string]interface{}{
+					"mothership@tbml.t0ast.cc": mothershipSettings,
+				},
+			},
+			policies:     `{"DisableAppUpdate": false}`,
+			sharedBundle: true,
+		},
+		{
+			desc: "Invalid extension settings",
+
+			policies: `{"ExtensionSettings": []}`,
+		},
+		{
+			desc: "Mothership in extension settings",
+
+			expectedErr: ErrForbiddenPolicy,
+			policies:    `{"ExtensionSettings": {"mothership@tbml.t0ast.cc": {"installation_mode": "blocked"}}}`,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			profile := ProfileConfiguration{
+				Label: "test",
+			}
+			if tC.policies != "" {
+				require.NoError(t, json.Unmarshal([]byte(tC.policies), &profile.Policies))
+			}
+
+			actualBytes, err := renderPolicies(profile, "/home/user", testRelativeBundlePath, tC.sharedBundle)
+			if tC.expected == nil {
+				assert.Error(t, err)
+				if tC.expectedErr != nil {
+					assert.ErrorIs(t, err, tC.expectedErr)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			actual := make(map[string]interface{})
+			require.NoError(t, json.Unmarshal(actualBytes, &actual))
+			assert.Equal(t, map[string]interface{}{"policies": tC.expected}, actual)
+		})
+	}
+}
+
+func TestEnsurePolicies(t *testing.T) {
+	_, profile, _, instanceDir, cleanUpEnvironment := setUpTestEnvironment(t)
+	defer cleanUpEnvironment()
+
+	assert.NoError(t, ensurePolicies(profile, "/home/user", instanceDir, testRelativeBundlePath, false))
+
+	policiesBytes, err := os.ReadFile(filepath.Join(instanceDir, testRelativeBundlePath, "Browser/distribution/policies.json"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(policiesBytes), `"mothership@tbml.t0ast.cc"`)
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	uerror "t0ast.cc/tbml/util/error"
+)
+
+// RoutingRule maps URLs to the topic (and optionally the profile)
+// they should be opened in.
+//
+// Host is a glob pattern (as understood by path.Match) that is
+// matched against the host name of the URL, e.g. "*.bank.example".
+// Pattern is a regular expression that is matched against the whole
+// URL. If both are given, both have to match.
+type RoutingRule struct {
+	Host    string
+	Pattern string
+	Profile string
+	Topic   string
+}
+
+// FindRoutingRule returns the first rule in the configuration that
+// matches the given URL or nil if no rule matches.
+func FindRoutingRule(config Configuration, u *url.URL) (*RoutingRule, error) {
+	for _, rule := range config.RoutingRules {
+		matches, err := rule.Matches(u)
+		if err != nil {
+			return nil, uerror.WithStackTrace(err)
+		}
+		if matches {
+			_rule := rule // create an unchanging reference to "rule"
+			return &_rule, nil
+		}
+	}
+	return nil, nil
+}
+
+// Matches returns if the rule matches the given URL. Rules without
+// a host or pattern never match.
+func (rule RoutingRule) Matches(u *url.URL) (bool, error) {
+	if rule.Host == "" && rule.Pattern == "" {
+		return false, nil
+	}
+
+	if rule.Host != "" {
+		matches, err := path.Match(strings.ToLower(rule.Host), strings.ToLower(u.Hostname()))
+		if err != nil {
+			return false, uerror.StackTracef("Invalid host pattern %q in routing rule for topic %s: %w", rule.Host, rule.Topic, err)
+		}
+		if !matches {
+			return false, nil
+		}
+	}
+
+	if rule.Pattern != "" {
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return false, uerror.StackTracef("Invalid pattern %q in routing rule for topic %s: %w", rule.Pattern, rule.Topic, err)
+		}
+		if !re.MatchString(u.String()) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,141 @@
+package internal_test
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"t0ast.cc/tbml/internal"
+)
+
+func getRoutingRulesFixture() []internal.RoutingRule {
+	return []internal.RoutingRule{
+		{
+			Host:    "*.bank.example",
+			Profile: "secure",
+			Topic:   "banking",
+		},
+		{
+			Pattern: "^https://example\\.com/docs/",
+			Topic:   "docs",
+		},
+		{
+			Host:    "example.com",
+			Pattern: "/shop/",
+			Profile: "shopping",
+			Topic:   "shop",
+		},
+		{
+			Topic: "never-matched",
+		},
+	}
+}
+
+func TestFindRoutingRule(t *testing.T) {
+	testCases := []struct {
+		desc string
+
+		expectedTopic *string
+		url           string
+	}{
+		{
+			desc: "Host glob",
+
+			expectedTopic: strPtr("banking"),
+			url:           "https://www.bank.example/login",
+		},
+		{
+			desc: "Host glob is case-insensitive",
+
+			expectedTopic: strPtr("banking"),
+			url:           "https://WWW.Bank.Example/",
+		},
+		{
+			desc: "Host glob does not match bare domain",
+
+			url: "https://bank.example/",
+		},
+		{
+			desc: "Pattern",
+
+			expectedTopic: strPtr("docs"),
+			url:           "https://example.com/docs/index.html",
+		},
+		{
+			desc: "Host and pattern",
+
+			expectedTopic: strPtr("shop"),
+			url:           "https://example.com/shop/cart",
+		},
+		{
+			desc: "Host matches but pattern does not",
+
+			url: "https://example.com/blog/",
+		},
+		{
+			desc: "No match",
+
+			url: "https://unrelated.example/",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			config := getConfigurationFixture()
+			config.RoutingRules = getRoutingRulesFixture()
+
+			u, err := url.Parse(tC.url)
+			assert.NoError(t, err)
+
+			actual, err := internal.FindRoutingRule(config, u)
+			assert.NoError(t, err)
+
+			if tC.expectedTopic == nil {
+				assert.Nil(t, actual)
+			} else if assert.NotNil(t, actual) {
+				assert.Equal(t, *tC.expectedTopic, actual.Topic)
+			}
+		})
+	}
+}
+
+func TestFindRoutingRuleFirstMatchWins(t *testing.T) {
+	config := getConfigurationFixture()
+	config.RoutingRules = []internal.RoutingRule{
+		{
+			Host:  "*.example",
+			Topic: "first",
+		},
+		{
+			Host:  "www.example",
+			Topic: "second",
+		},
+	}
+
+	u, err := url.Parse("https://www.example/")
+	assert.NoError(t, err)
+
+	actual, err := internal.FindRoutingRule(config, u)
+	assert.NoError(t, err)
+	assert.Equal(t, &config.RoutingRules[0], actual)
+}
+
+func TestFindRoutingRuleInvalidPattern(t *testing.T) {
+	config := getConfigurationFixture()
+	config.RoutingRules = []internal.RoutingRule{
+		{
+			Pattern: "(",
+			Topic:   "broken",
+		},
+	}
+
+	u, err := url.Parse("https://example.com/")
+	assert.NoError(t, err)
+
+	_, err = internal.FindRoutingRule(config, u)
+	assert.Error(t, err)
+}
+
+func strPtr(str string) *string {
+	return &str
+}
@@ -17,6 +17,7 @@ import (
 	"strings"
 	"time"
 
+	"t0ast.cc/tbml/mothership-connector/com"
 	uerror "t0ast.cc/tbml/util/error"
 	uio "t0ast.cc/tbml/util/io"
 	ustring "t0ast.cc/tbml/util/string"
@@ -24,7 +25,18 @@ import (
 
 const tblFirejailProfileFileName = "torbrowser-launcher.profile"
 
-const relativeProfilePath = ".local/share/torbrowser/tbb/x86_64/tor-browser_en-US/Browser/TorBrowser/Data/Browser/profile.default"
+const instanceLogMaxSize = 5 << 20
+const instanceLogBackups = 2
+
+// tblSettingsPath is the path of torbrowser-launcher's settings,
+// relative to the home directory.
+const tblSettingsPath = ".config/torbrowser/settings.json"
+
+// getRelativeProfilePath returns the path of the Tor Browser profile
+// in the bundle at relativeBundlePath, relative to the home directory.
+func getRelativeProfilePath(relativeBundlePath string) string {
+	return filepath.Join(relativeBundlePath, bundleDataPath, "Browser/profile.default")
+}
 
 //go:embed torbrowser-launcher.profile
 var tblFirejailProfile []byte
@@ -38,44 +50,199 @@ var mothershipExtensionFiles embed.FS
 //go:embed mothership-connector
 var mothershipConnector []byte
 
-func StartInstance(ctx context.Context, config Configuration, profile ProfileConfiguration, instance ProfileInstance, allInstances []ProfileInstance, configDir string, startURL *url.URL, debugShell bool) (exitCode uint, err error) {
+// StartInstance prepares the given instance and runs the browser
+// (or a debug shell) in it until it exits. Mothership opens the start
+// URLs once it's connected. If onReady is not nil, it
+// is called once the instance is marked as in use and its control
+// socket is listening, right before the browser is started. If
+// detached is true, the browser's output is only written to the
+// instance's log file instead of also being written to tbml's stdout
+// and stderr.
+func StartInstance(ctx context.Context, config Configuration, profile ProfileConfiguration, instance ProfileInstance, allInstances []ProfileInstance, configDir string, startURLs []*url.URL, debugShell, detached bool, onReady func()) (exitCode uint, err error) {
 	instanceDir := getInstanceDir(config, instance)
 
+	sb, err := getSandbox(profile)
+	if err != nil {
+		return genericErrorExitCode, uerror.WithStackTrace(err)
+	}
+	isolateNetwork, networkInterface, err := getNetworkIsolation(profile)
+	if err != nil {
+		return genericErrorExitCode, uerror.WithStackTrace(err)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return genericErrorExitCode, uerror.WithStackTrace(err)
+	}
+	relativeBundlePath, err := findRelativeBundlePath(instanceDir, getLauncherLocale(profile.LauncherSettings))
+	if err != nil {
+		return genericErrorExitCode, uerror.WithStackTrace(err)
+	}
+
 	cleanUpInstanceData, err := writeInstanceData(config, profile, instance)
 	if err != nil {
 		return genericErrorExitCode, uerror.WithStackTrace(err)
 	}
 	defer cleanUpInstanceData()
 
-	if err := ensureFiles(profile, configDir, instanceDir); err != nil {
+	stdout, stderr, cleanUpOutput, err := setUpInstanceOutput(config, instance, detached)
+	if err != nil {
+		return genericErrorExitCode, uerror.WithStackTrace(err)
+	}
+	defer cleanUpOutput()
+
+	// The bundle is set up before the files are written into the
+	// instance's profile, so that an instance without a profile gets
+	// the one the bundle ships with.
+	if config.TorBrowser != nil {
+		name, err := EnsureTorBrowserBundle(ctx, config, getLauncherLocale(profile.LauncherSettings))
+		if err != nil {
+			return genericErrorExitCode, uerror.WithStackTrace(err)
+		}
+		if err := useManagedBundle(instanceDir, name, relativeBundlePath); err != nil {
+			return genericErrorExitCode, uerror.WithStackTrace(err)
+		}
+	}
+
+	bundleBinds, err := setUpSharedBundle(config, instanceDir, relativeBundlePath, getLauncherLocale(profile.LauncherSettings))
+	if err != nil {
 		return genericErrorExitCode, uerror.WithStackTrace(err)
 	}
 
-	if err := ensureExtensions(config, profile, instance.InstanceLabel, configDir, instanceDir); err != nil {
+	if err := ensureFiles(profile, configDir, instanceDir, relativeBundlePath); err != nil {
 		return genericErrorExitCode, uerror.WithStackTrace(err)
 	}
 
-	if err := ensureMothershipExtension(instanceDir); err != nil {
+	if err := ensureExtensions(config, profile, instance.InstanceLabel, configDir, instanceDir, relativeBundlePath); err != nil {
 		return genericErrorExitCode, uerror.WithStackTrace(err)
 	}
 
-	if err := writePortSettings(instanceDir, allInstances); err != nil {
+	if err := ensureMothershipExtension(instanceDir, relativeBundlePath); err != nil {
 		return genericErrorExitCode, uerror.WithStackTrace(err)
 	}
 
-	cleanUpExternalUnixSocket, err := setUpExternalUnixSocket(ctx, instanceDir, startURL)
+	// Instances with an isolated network can't collide on ports, so
+	// Tor Browser's default ports are used for them.
+	if !isolateNetwork {
+		if err := writePortSettings(instanceDir, relativeBundlePath, allInstances); err != nil {
+			return genericErrorExitCode, uerror.WithStackTrace(err)
+		}
+	}
+
+	topic := ""
+	if instance.UsageLabel != nil {
+		topic = *instance.UsageLabel
+	}
+
+	var onSession func(tabs []com.SessionTab)
+	session := sessionRecorder{}
+	if profile.SaveSession && !debugShell {
+		onSession = session.record
+		defer func() {
+			if saveErr := session.save(instanceDir, instance.InstanceLabel, topic); saveErr != nil && err == nil {
+				exitCode, err = genericErrorExitCode, uerror.StackTracef("Failed to save session of instance %s: %w", instance.InstanceLabel, saveErr)
+			}
+		}()
+	}
+
+	var bookmarks <-chan []com.Bookmark
+	if bookmarksFilePath := getBookmarksFilePath(profile, configDir); bookmarksFilePath != "" {
+		watchCtx, stopWatching := context.WithCancel(ctx)
+		defer stopWatching()
+		bookmarks, err = watchBookmarksFile(watchCtx, bookmarksFilePath)
+		if err != nil {
+			return genericErrorExitCode, uerror.WithStackTrace(err)
+		}
+	}
+
+	cleanUpExternalUnixSocket, err := setUpExternalUnixSocket(ctx, instanceDir, startURLs, onSession, bookmarks)
 	if err != nil {
 		return genericErrorExitCode, uerror.WithStackTrace(err)
 	}
 	defer cleanUpExternalUnixSocket()
+	downloadsBinds, err := getDownloadsBinds(config, profile, instance, topic, configDir)
+	if err != nil {
+		return genericErrorExitCode, uerror.WithStackTrace(err)
+	}
 
-	cleanUpBindMounts, err := setUpBindMounts(instanceDir)
+	// The policies are written after the bundle is set up, because
+	// adopting the instance's bundle into the bundle store removes
+	// everything but its data from the instance, and before the binds
+	// are set up, because a shared bundle is mounted read-only.
+	if err := ensurePolicies(profile, home, instanceDir, relativeBundlePath, len(bundleBinds) > 0); err != nil {
+		return genericErrorExitCode, uerror.WithStackTrace(err)
+	}
+
+	nativeBinds, cleanUpBinds, err := setUpInstanceBinds(sb, append(downloadsBinds, bundleBinds...), instanceDir, stdout, stderr)
 	if err != nil {
 		return genericErrorExitCode, uerror.WithStackTrace(err)
 	}
-	defer cleanUpBindMounts()
+	defer cleanUpBinds()
 
-	return runFirejail(ctx, instanceDir, debugShell)
+	var stdin io.Reader = os.Stdin
+	if detached {
+		stdin = nil
+	}
+	if debugShell {
+		// The debug shell is interactive and needs to be connected
+		// to the terminal directly.
+		stdout, stderr = os.Stdout, os.Stderr
+	}
+
+	if onReady != nil {
+		onReady()
+	}
+
+	waylandSocket, xAuthority := getDisplayFiles()
+	spec := sandboxSpec{
+		Binds:            nativeBinds,
+		DebugShell:       debugShell,
+		Home:             home,
+		InstanceDir:      instanceDir,
+		IsolateNetwork:   isolateNetwork,
+		NetworkInterface: networkInterface,
+		WaylandSocket:    waylandSocket,
+		XAuthority:       xAuthority,
+	}
+	return runSandbox(ctx, sb, spec, stdin, stdout, stderr)
+}
+
+// setUpInstanceOutput returns the writers that the output of the
+// processes run for an instance is written to. Every line is
+// prefixed with the instance label and written to the instance's
+// rotating log file and, unless detached is true, to tbml's stdout or
+// stderr.
+func setUpInstanceOutput(config Configuration, instance ProfileInstance, detached bool) (stdout, stderr io.Writer, cleanup func() error, err error) {
+	logFile, err := OpenInstanceLog(config, instance)
+	if err != nil {
+		return nil, nil, nil, uerror.WithStackTrace(err)
+	}
+
+	prefix := fmt.Sprintf("[%s] ", instance.InstanceLabel)
+	if detached {
+		stdout = uio.NewPrefixWriter(logFile, prefix)
+		stderr = stdout
+	} else {
+		stdout = uio.NewPrefixWriter(io.MultiWriter(os.Stdout, logFile), prefix)
+		stderr = uio.NewPrefixWriter(io.MultiWriter(os.Stderr, logFile), prefix)
+	}
+
+	return stdout, stderr, logFile.Close, nil
+}
+
+// GetInstanceLogPath returns the path of the file that the output of
+// an instance is written to.
+func GetInstanceLogPath(config Configuration, instance ProfileInstance) string {
+	return filepath.Join(getInstanceDir(config, instance), "tbml.log")
+}
+
+// OpenInstanceLog opens the instance's log file for appending. It is
+// rotated like the log file that the instance's output is written to.
+func OpenInstanceLog(config Configuration, instance ProfileInstance) (*uio.RotatingFile, error) {
+	logFile, err := uio.NewRotatingFile(GetInstanceLogPath(config, instance), instanceLogMaxSize, instanceLogBackups)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	return logFile, nil
 }
 
 func writeInstanceData(config Configuration, profile ProfileConfiguration, instance ProfileInstance) (cleanup func() error, err error) {
@@ -98,48 +265,58 @@ func writeInstanceData(config Configuration, profile ProfileConfiguration, insta
 	instance.LastUsed = time.Now()
 	instance.UsagePID = &pid
 
-	marshalData := func(instance ProfileInstance) error {
-		instanceDataBytes, err := json.Marshal(instance)
-		if err != nil {
-			return uerror.WithStackTrace(err)
-		}
-		if err := os.WriteFile(instanceDataPath, instanceDataBytes, uio.FileModeURWGRWO); err != nil {
-			return uerror.WithStackTrace(err)
-		}
-		return nil
+	instanceDataBytes, err := json.Marshal(instance)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
 	}
-
-	if err := marshalData(instance); err != nil {
-		return nil, err
+	if err := os.WriteFile(instanceDataPath, instanceDataBytes, uio.FileModeURWGRWO); err != nil {
+		return nil, uerror.WithStackTrace(err)
 	}
 
 	return func() error {
-		instanceDataBytes, err := os.ReadFile(instanceDataPath)
-		if err != nil {
-			return uerror.WithStackTrace(err)
-		}
-		instance = ProfileInstance{}
-		json.Unmarshal(instanceDataBytes, &instance)
-
-		instance.LastUsed = time.Now()
-		instance.UsageLabel = nil
-		instance.UsagePID = nil
-		return marshalData(instance)
+		return releaseInstanceData(instanceDataPath)
 	}, nil
 }
 
-func ensureFiles(profile ProfileConfiguration, configDir string, instanceDir string) error {
-	tblSettingsPath := filepath.Join(instanceDir, ".config/torbrowser/settings.json")
-	if err := writeIfNotExists(tblSettingsPath, tblDefaultSettings); err != nil {
+// releaseInstanceData marks the instance whose data is stored at the
+// given path as not in use anymore.
+func releaseInstanceData(instanceDataPath string) error {
+	instanceDataBytes, err := os.ReadFile(instanceDataPath)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	instance := ProfileInstance{}
+	json.Unmarshal(instanceDataBytes, &instance)
+
+	instance.LastUsed = time.Now()
+	instance.UsageLabel = nil
+	instance.UsagePID = nil
+
+	instanceDataBytes, err = json.Marshal(instance)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	if err := os.WriteFile(instanceDataPath, instanceDataBytes, uio.FileModeURWGRWO); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	return nil
+}
+
+func ensureFiles(profile ProfileConfiguration, configDir, instanceDir, relativeBundlePath string) error {
+	if err := reconcileLauncherSettings(profile.LauncherSettings, instanceDir); err != nil {
 		return uerror.WithStackTrace(err)
 	}
 
+	firejailProfile, err := renderFirejailProfile(profile, configDir, relativeBundlePath)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
 	tblFirejailProfilePath := filepath.Join(instanceDir, tblFirejailProfileFileName)
-	if err := ensureExists(tblFirejailProfilePath, tblFirejailProfile); err != nil {
+	if err := ensureExists(tblFirejailProfilePath, firejailProfile); err != nil {
 		return uerror.WithStackTrace(err)
 	}
 
-	profileDir := filepath.Join(instanceDir, relativeProfilePath)
+	profileDir := filepath.Join(instanceDir, getRelativeProfilePath(relativeBundlePath))
 
 	userChromePath := filepath.Join(profileDir, "chrome/userChrome.css")
 	if profile.UserChromeFile == nil {
@@ -174,40 +351,49 @@ func ensureFiles(profile ProfileConfiguration, configDir string, instanceDir str
 	return nil
 }
 
-func ensureExtensions(config Configuration, profile ProfileConfiguration, instanceLabel, configDir, instanceDir string) error {
+// ensureExtensions installs the profile's extensions into the instance
+// and removes extensions that the profile doesn't have anymore. An
+// extension is only copied if the instance doesn't have the same file
+// installed already.
+func ensureExtensions(config Configuration, profile ProfileConfiguration, instanceLabel, configDir, instanceDir, relativeBundlePath string) error {
 	instance, err := GetProfileInstance(config, instanceLabel)
 	if err != nil {
 		return uerror.WithStackTrace(err)
 	}
 
+	configured, err := GetConfiguredExtensions(config, profile, configDir)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+
+	extensionsDir := filepath.Join(instanceDir, getRelativeProfilePath(relativeBundlePath), "extensions")
+	installedExtensions := []Extension{}
 	wantedExtensions := make(map[string]bool)
-	extensionPathByID := make(map[string]string)
-	for _, extensionID := range instance.InstalledExtensions {
-		wantedExtensions[extensionID] = false
-	}
-	for _, extensionFilePath := range profile.ExtensionFiles {
-		extensionID := strings.TrimSuffix(filepath.Base(extensionFilePath), ".xpi")
-		wantedExtensions[extensionID] = true
-		extensionPathByID[extensionID] = extensionFilePath
-	}
-	for extensionID, wanted := range wantedExtensions {
-		extensionPathInProfile := filepath.Join(instanceDir, relativeProfilePath, "extensions", fmt.Sprint(extensionID, ".xpi"))
-		if wanted {
-			extensionSrcPath := extensionPathByID[extensionID]
-			if !filepath.IsAbs(extensionSrcPath) {
-				extensionSrcPath = filepath.Join(configDir, extensionSrcPath)
-			}
-			if err := ensureExistsFrom(extensionPathInProfile, extensionSrcPath); err != nil {
-				return uerror.WithStackTrace(err)
-			}
-			instance.InstalledExtensions = includeExtension(instance.InstalledExtensions, extensionID)
-		} else {
-			if err := os.Remove(extensionPathInProfile); err != nil {
-				return uerror.StackTracef("Couldn't delete installed extension %s: %w", extensionID, err)
-			}
-			instance.InstalledExtensions = excludeExtension(instance.InstalledExtensions, extensionID)
+	for _, status := range GetExtensionStatuses(configured, instance) {
+		wantedExtensions[status.Configured.ID] = true
+		extensionPathInProfile := filepath.Join(extensionsDir, fmt.Sprint(status.Configured.ID, ".xpi"))
+		exists, err := uio.FileExists(extensionPathInProfile)
+		if err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		if exists && !status.IsOutdated() {
+			installedExtensions = append(installedExtensions, *status.Installed)
+			continue
+		}
+		if err := ensureExistsFrom(extensionPathInProfile, status.Configured.File); err != nil {
+			return uerror.WithStackTrace(err)
 		}
+		installedExtensions = append(installedExtensions, status.Configured.Extension)
 	}
+	for _, extension := range instance.InstalledExtensions {
+		if wantedExtensions[extension.ID] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(extensionsDir, fmt.Sprint(extension.ID, ".xpi"))); err != nil {
+			return uerror.StackTracef("Couldn't delete installed extension %s: %w", extension.ID, err)
+		}
+	}
+	instance.InstalledExtensions = installedExtensions
 
 	instanceDataBytes, err := json.Marshal(instance)
 	if err != nil {
@@ -221,26 +407,7 @@ func ensureExtensions(config Configuration, profile ProfileConfiguration, instan
 	return nil
 }
 
-func includeExtension(extensionList []string, extensionID string) []string {
-	for _, idInList := range extensionList {
-		if idInList == extensionID {
-			return extensionList
-		}
-	}
-	return append(extensionList, extensionID)
-}
-
-func excludeExtension(extensionList []string, extensionID string) []string {
-	for i, idInList := range extensionList {
-		if idInList == extensionID {
-			extensionList[i] = extensionList[len(extensionList)-1]
-			return extensionList[:len(extensionList)-1]
-		}
-	}
-	return extensionList
-}
-
-func ensureMothershipExtension(instanceDir string) error {
+func ensureMothershipExtension(instanceDir, relativeBundlePath string) error {
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return uerror.WithStackTrace(err)
@@ -257,19 +424,22 @@ func ensureMothershipExtension(instanceDir string) error {
 		"path":        filepath.Join(home, "mothership-connector"),
 		"type":        "stdio",
 		"allowed_extensions": []interface{}{
-			"mothership@tbml.t0ast.cc",
+			mothershipExtensionID,
 		},
 	}
 	nativeManifestBytes, err := json.Marshal(nativeManifest)
 	if err != nil {
 		return uerror.WithStackTrace(err)
 	}
-	nativeManifestPath := filepath.Join(instanceDir, ".local/share/torbrowser/tbb/x86_64/tor-browser_en-US/Browser/TorBrowser/Data/Browser/.mozilla/native-messaging-hosts/mothership_native_connector.json")
+	nativeManifestPath := filepath.Join(instanceDir, relativeBundlePath, bundleDataPath, "Browser/.mozilla/native-messaging-hosts/mothership_native_connector.json")
 	if err := ensureExists(nativeManifestPath, nativeManifestBytes); err != nil {
 		return uerror.WithStackTrace(err)
 	}
 
-	extFilePath := filepath.Join(instanceDir, relativeProfilePath, "extensions/mothership@tbml.t0ast.cc.xpi")
+	extFilePath := filepath.Join(instanceDir, getRelativeProfilePath(relativeBundlePath), "extensions", fmt.Sprint(mothershipExtensionID, ".xpi"))
+	if err := os.MkdirAll(filepath.Dir(extFilePath), uio.FileModeURWXGRWXO); err != nil {
+		return uerror.WithStackTrace(err)
+	}
 	extFile, err := os.Create(extFilePath)
 	if err != nil {
 		return uerror.WithStackTrace(err)
@@ -321,22 +491,6 @@ func ensureMothershipExtension(instanceDir string) error {
 	return nil
 }
 
-func writeIfNotExists(name string, content []byte) error {
-	exists, err := uio.FileExists(name)
-	if err != nil {
-		return uerror.WithStackTrace(err)
-	}
-	if !exists {
-		if err := os.MkdirAll(filepath.Dir(name), uio.FileModeURWXGRWXO); err != nil {
-			return uerror.WithStackTrace(err)
-		}
-		if err := os.WriteFile(name, []byte(content), uio.FileModeURWGRWO); err != nil {
-			return uerror.WithStackTrace(err)
-		}
-	}
-	return nil
-}
-
 func ensureExists(name string, content []byte) error {
 	if err := os.MkdirAll(filepath.Dir(name), uio.FileModeURWXGRWXO); err != nil {
 		return uerror.WithStackTrace(err)
@@ -365,7 +519,7 @@ func ensureExistsFrom(name, srcFile string) error {
 	return nil
 }
 
-func writePortSettings(instanceDir string, allInstances []ProfileInstance) error {
+func writePortSettings(instanceDir, relativeBundlePath string, allInstances []ProfileInstance) error {
 	// There's no need to compensate for the currently starting
 	// instance in port calculation because "allInstances" is
 	// expected to reflect the state before the instance was marked
@@ -380,7 +534,7 @@ func writePortSettings(instanceDir string, allInstances []ProfileInstance) error
 	socksPort := 9150 + 10*runningInstances
 	controlPort := 9151 + 10*runningInstances
 
-	profileDir := filepath.Join(instanceDir, relativeProfilePath)
+	profileDir := filepath.Join(instanceDir, getRelativeProfilePath(relativeBundlePath))
 	if err := os.MkdirAll(profileDir, uio.FileModeURWXGRWXO); err != nil {
 		return uerror.WithStackTrace(err)
 	}
@@ -401,7 +555,7 @@ func writePortSettings(instanceDir string, allInstances []ProfileInstance) error
 	return nil
 }
 
-func setUpExternalUnixSocket(ctx context.Context, instanceDir string, startURL *url.URL) (cleanup func() error, err error) {
+func setUpExternalUnixSocket(ctx context.Context, instanceDir string, startURLs []*url.URL, onSession func(tabs []com.SessionTab), bookmarks <-chan []com.Bookmark) (cleanup func() error, err error) {
 	addr, err := resolveExternalUnixSocketAddr(instanceDir)
 	if err != nil {
 		return nil, uerror.WithStackTrace(err)
@@ -411,89 +565,152 @@ func setUpExternalUnixSocket(ctx context.Context, instanceDir string, startURL *
 		return nil, uerror.WithStackTrace(err)
 	}
 
-	go ListenOnExternalUnixSocket(ctx, listener, startURL)
+	go ListenOnExternalUnixSocket(ctx, listener, startURLs, onSession, bookmarks)
 
 	return func() error {
 		return listener.Close()
 	}, nil
 }
 
-func setUpBindMounts(instanceDir string) (cleanup func(), err error) {
-	home, err := os.UserHomeDir()
+// setUpInstanceBinds makes the directories that are shared with
+// every instance, and the given extra binds, available to the
+// sandbox. If the sandbox can bind
+// them itself, they are returned to be passed to it in its spec and
+// vanish together with the sandbox. Otherwise, they are mounted into
+// the instance directory with bindfs and the returned cleanup
+// function unmounts them.
+func setUpInstanceBinds(sb sandbox, extraBinds []sandboxBind, instanceDir string, stdout, stderr io.Writer) (nativeBinds []sandboxBind, cleanup func(), err error) {
+	binds, err := getInstanceBinds()
 	if err != nil {
-		return nil, uerror.WithStackTrace(err)
+		return nil, nil, uerror.WithStackTrace(err)
 	}
-	cache, err := os.UserCacheDir()
-	if err != nil {
-		return nil, uerror.WithStackTrace(err)
+	binds = append(binds, extraBinds...)
+
+	if !sb.nativeBinds() {
+		cleanup, err := setUpBindMounts(binds, instanceDir, stdout, stderr)
+		if err != nil {
+			return nil, nil, uerror.WithStackTrace(err)
+		}
+		return nil, cleanup, nil
 	}
 
-	cleanUpCache, err := bindMount(cache, filepath.Join(instanceDir, ".cache"), "torbrowser")
-	if err != nil {
-		return nil, uerror.WithStackTrace(err)
+	for _, bind := range binds {
+		// The mount point needs to exist for the sandbox to bind
+		// the source onto it.
+		for _, dir := range []string{bind.Src, filepath.Join(instanceDir, bind.Dst)} {
+			if err := os.MkdirAll(dir, uio.FileModeURWXGRWXO); err != nil {
+				return nil, nil, uerror.WithStackTrace(err)
+			}
+		}
 	}
-	cleanUpGPGHomeDir, err := bindMount(home, instanceDir, ".local/share/torbrowser/gnupg_homedir")
+	return binds, func() {}, nil
+}
+
+func setUpBindMounts(binds []sandboxBind, instanceDir string, stdout, stderr io.Writer) (cleanup func(), err error) {
+	stagingDir, err := os.MkdirTemp("", "tbml-binds-")
 	if err != nil {
 		return nil, uerror.WithStackTrace(err)
 	}
 
-	return func() {
-		_ = cleanUpCache()
-		_ = cleanUpGPGHomeDir()
-	}, nil
+	cleanUps := []func() error{
+		// The staging directory is removed without recursing, so
+		// that nothing is removed through a mount that is left over.
+		func() error {
+			entries, err := os.ReadDir(stagingDir)
+			if err != nil {
+				return uerror.WithStackTrace(err)
+			}
+			for _, entry := range entries {
+				if err := os.Remove(filepath.Join(stagingDir, entry.Name())); err != nil {
+					return uerror.WithStackTrace(err)
+				}
+			}
+			return uerror.WithStackTrace(os.Remove(stagingDir))
+		},
+	}
+	cleanUpAll := func() {
+		for i := len(cleanUps) - 1; i >= 0; i-- {
+			_ = cleanUps[i]()
+		}
+	}
+	for _, mount := range getBindMounts(binds, instanceDir, stagingDir) {
+		cleanUp, err := bindMount(mount.Src, mount.Dst, mount.ReadOnly, stdout, stderr)
+		if err != nil {
+			cleanUpAll()
+			return nil, uerror.WithStackTrace(err)
+		}
+		cleanUps = append(cleanUps, cleanUp)
+	}
+
+	return cleanUpAll, nil
 }
 
-func bindMount(src string, dst string, commonPath string) (cleanup func() error, err error) {
-	fullSrc := filepath.Join(src, commonPath)
-	fullDst := filepath.Join(dst, commonPath)
+// bindMountSpec is a bindfs mount. Both paths are absolute.
+type bindMountSpec struct {
+	Dst      string
+	ReadOnly bool
+	Src      string
+}
 
-	if err := os.MkdirAll(fullSrc, uio.FileModeURWXGRWXO); err != nil {
+// getBindMounts returns the mounts that make the binds available in
+// the instance directory, in the order they have to be mounted in.
+// A bind whose source is in the destination of an earlier bind, like
+// the instance's own paths in a shared bundle, would see the earlier
+// mount instead of its source. Its source is mounted into the staging
+// directory before any bind, and the bind uses that mount, which
+// keeps showing the source after it is hidden.
+func getBindMounts(binds []sandboxBind, instanceDir, stagingDir string) []bindMountSpec {
+	staged := []bindMountSpec{}
+	mounts := []bindMountSpec{}
+	for i, bind := range binds {
+		src := bind.Src
+		for _, earlier := range binds[:i] {
+			rel, err := filepath.Rel(filepath.Join(instanceDir, earlier.Dst), src)
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+				stagingPath := filepath.Join(stagingDir, fmt.Sprint(i))
+				staged = append(staged, bindMountSpec{
+					Dst: stagingPath,
+					Src: src,
+				})
+				src = stagingPath
+				break
+			}
+		}
+		mounts = append(mounts, bindMountSpec{
+			Dst:      filepath.Join(instanceDir, bind.Dst),
+			ReadOnly: bind.ReadOnly,
+			Src:      src,
+		})
+	}
+	return append(staged, mounts...)
+}
+
+func bindMount(src, dst string, readOnly bool, stdout, stderr io.Writer) (cleanup func() error, err error) {
+	if err := os.MkdirAll(src, uio.FileModeURWXGRWXO); err != nil {
 		return nil, uerror.WithStackTrace(err)
 	}
-	if err := os.MkdirAll(fullDst, uio.FileModeURWXGRWXO); err != nil {
+	if err := os.MkdirAll(dst, uio.FileModeURWXGRWXO); err != nil {
 		return nil, uerror.WithStackTrace(err)
 	}
 
-	bindCmd := exec.Command("bindfs", "--no-allow-other", filepath.Join(src, commonPath), fullDst)
-	bindCmd.Stdout = os.Stdout
-	bindCmd.Stderr = os.Stderr
+	args := []string{"--no-allow-other"}
+	if readOnly {
+		args = append(args, "-r")
+	}
+	bindCmd := exec.Command("bindfs", append(args, src, dst)...)
+	bindCmd.Stdout = stdout
+	bindCmd.Stderr = stderr
 	if err := bindCmd.Run(); err != nil {
 		return nil, uerror.WithStackTrace(err)
 	}
 
 	return func() error {
-		umountCmd := exec.Command("umount", fullDst)
-		umountCmd.Stdout = os.Stdout
-		umountCmd.Stderr = os.Stderr
+		umountCmd := exec.Command("umount", dst)
+		umountCmd.Stdout = stdout
+		umountCmd.Stderr = stderr
 		if err := umountCmd.Run(); err != nil {
 			return uerror.WithStackTrace(err)
 		}
 		return nil
 	}, nil
 }
-
-func runFirejail(ctx context.Context, instanceDir string, debugShell bool) (uint, error) {
-	firejailArgs := []string{
-		"dbus-launch", "firejail", fmt.Sprintf("--private=%s", instanceDir),
-	}
-	if debugShell {
-		firejailArgs = append(firejailArgs, "--noprofile", "fish")
-	} else {
-		firejailArgs = append(firejailArgs, fmt.Sprint("--profile=", filepath.Join(instanceDir, tblFirejailProfileFileName)), "torbrowser-launcher")
-	}
-
-	firejailCmd := exec.CommandContext(ctx, firejailArgs[0], firejailArgs[1:]...)
-	firejailCmd.Env = append(os.Environ(), "XDG_CACHE_HOME=")
-	firejailCmd.Stdin = os.Stdin
-	firejailCmd.Stdout = os.Stdout
-	firejailCmd.Stderr = os.Stderr
-
-	if err := firejailCmd.Run(); err != nil {
-		if err, ok := err.(*exec.ExitError); ok {
-			return uint(err.ExitCode()), nil
-		}
-		return 0, uerror.WithStackTrace(err)
-	}
-
-	return 0, nil
-}
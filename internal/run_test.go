@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	uio "t0ast.cc/tbml/util/io"
 	ustring "t0ast.cc/tbml/util/string"
@@ -106,14 +108,6 @@ func TestEnsureFiles(t *testing.T) {
 		expectedFiles        map[string]string
 		prepareProfile       func(profile *ProfileConfiguration)
 	}{
-		{
-			desc: "torbrowser-launcher settings",
-
-			expectChangesAreKept: true,
-			expectedFiles: map[string]string{
-				".config/torbrowser/settings.json": "torbrowser-launcher-default-settings.json",
-			},
-		},
 		{
 			desc: "Firejail profile",
 
@@ -125,7 +119,7 @@ func TestEnsureFiles(t *testing.T) {
 			desc: "userChrome.css",
 
 			expectedFiles: map[string]string{
-				filepath.Join(relativeProfilePath, "chrome/userChrome.css"): "testdata/ensure-files/userChrome.css",
+				filepath.Join(getRelativeProfilePath(testRelativeBundlePath), "chrome/userChrome.css"): "testdata/ensure-files/userChrome.css",
 			},
 			prepareProfile: func(profile *ProfileConfiguration) {
 				uc := "userChrome.css"
@@ -136,7 +130,7 @@ func TestEnsureFiles(t *testing.T) {
 			desc: "user.js",
 
 			expectedFiles: map[string]string{
-				filepath.Join(relativeProfilePath, "user.js"): "testdata/ensure-files/user.js",
+				filepath.Join(getRelativeProfilePath(testRelativeBundlePath), "user.js"): "testdata/ensure-files/user.js",
 			},
 			prepareProfile: func(profile *ProfileConfiguration) {
 				uj := "user.js"
@@ -171,7 +165,7 @@ func TestEnsureFiles(t *testing.T) {
 					assert.NoFileExists(t, filepath.Join(instanceDir, k))
 				}
 
-				assert.NoError(t, ensureFiles(profile, "testdata/ensure-files", instanceDir))
+				assert.NoError(t, ensureFiles(profile, "testdata/ensure-files", instanceDir, testRelativeBundlePath))
 
 				verifyFileContentsFromMap(t)
 			})
@@ -182,7 +176,7 @@ func TestEnsureFiles(t *testing.T) {
 					assert.NoError(t, os.WriteFile(filepath.Join(instanceDir, k), changedContent, uio.FileModeURWGRWO))
 				}
 
-				assert.NoError(t, ensureFiles(profile, "testdata/ensure-files", instanceDir))
+				assert.NoError(t, ensureFiles(profile, "testdata/ensure-files", instanceDir, testRelativeBundlePath))
 
 				if tC.expectChangesAreKept {
 					for k := range tC.expectedFiles {
@@ -204,7 +198,7 @@ func TestEnsureExtensions(t *testing.T) {
 	testCases := []struct {
 		desc string
 
-		extensionsInProfile       []string
+		extensionFilesInProfile   []string
 		installedExtensionsBefore []string
 
 		installedExtensionsAfter []string
@@ -212,9 +206,9 @@ func TestEnsureExtensions(t *testing.T) {
 		{
 			desc: "Only new extensions",
 
-			extensionsInProfile: []string{
-				"foo@t0ast.cc",
-				"bar@t0ast.cc",
+			extensionFilesInProfile: []string{
+				"foo@t0ast.cc.xpi",
+				"bar@t0ast.cc.xpi",
 			},
 
 			installedExtensionsAfter: []string{
@@ -225,9 +219,9 @@ func TestEnsureExtensions(t *testing.T) {
 		{
 			desc: "Remove old extension",
 
-			extensionsInProfile: []string{
-				"foo@t0ast.cc",
-				"bar@t0ast.cc",
+			extensionFilesInProfile: []string{
+				"foo@t0ast.cc.xpi",
+				"bar@t0ast.cc.xpi",
 			},
 			installedExtensionsBefore: []string{
 				"baz@t0ast.cc",
@@ -241,9 +235,9 @@ func TestEnsureExtensions(t *testing.T) {
 		{
 			desc: "Keep some extensions",
 
-			extensionsInProfile: []string{
-				"foo@t0ast.cc",
-				"bar@t0ast.cc",
+			extensionFilesInProfile: []string{
+				"foo@t0ast.cc.xpi",
+				"bar@t0ast.cc.xpi",
 			},
 			installedExtensionsBefore: []string{
 				"bar@t0ast.cc",
@@ -255,6 +249,22 @@ func TestEnsureExtensions(t *testing.T) {
 				"bar@t0ast.cc",
 			},
 		},
+		{
+			desc: "Extension files not named after their ID",
+
+			extensionFilesInProfile: []string{
+				"renamed.xpi",
+				"legacy.xpi",
+			},
+			installedExtensionsBefore: []string{
+				"foo@t0ast.cc",
+			},
+
+			installedExtensionsAfter: []string{
+				"qux@t0ast.cc",
+				"legacy@t0ast.cc",
+			},
+		},
 	}
 	for _, tC := range testCases {
 		t.Run(tC.desc, func(t *testing.T) {
@@ -263,7 +273,7 @@ func TestEnsureExtensions(t *testing.T) {
 
 			configDir := "testdata/ensure-extensions"
 
-			extensionsDir := filepath.Join(instanceDir, relativeProfilePath, "extensions")
+			extensionsDir := filepath.Join(instanceDir, getRelativeProfilePath(testRelativeBundlePath), "extensions")
 			assert.NoError(t, os.MkdirAll(extensionsDir, uio.FileModeURWXGRWXO))
 
 			doNotDeleteContent := []byte("Do not delete me plz :>")
@@ -275,11 +285,11 @@ func TestEnsureExtensions(t *testing.T) {
 				dstPath := filepath.Join(extensionsDir, fmt.Sprint(ext, ".xpi"))
 				assert.NoError(t, uio.CopyFile(srcPath, dstPath))
 
-				instance.InstalledExtensions = append(instance.InstalledExtensions, ext)
+				instance.InstalledExtensions = append(instance.InstalledExtensions, Extension{ID: ext})
 			}
 
-			for _, ext := range tC.extensionsInProfile {
-				profile.ExtensionFiles = append(profile.ExtensionFiles, fmt.Sprint("extensions/", ext, ".xpi"))
+			for _, extensionFile := range tC.extensionFilesInProfile {
+				profile.ExtensionFiles = append(profile.ExtensionFiles, filepath.Join("extensions", extensionFile))
 			}
 
 			instanceDataBytes, err := json.Marshal(instance)
@@ -287,7 +297,7 @@ func TestEnsureExtensions(t *testing.T) {
 			assert.NoError(t, os.MkdirAll(instanceDir, uio.FileModeURWXGRWXO))
 			assert.NoError(t, os.WriteFile(filepath.Join(instanceDir, "profile-instance.json"), instanceDataBytes, uio.FileModeURWGRWO))
 
-			assert.NoError(t, ensureExtensions(config, profile, instance.InstanceLabel, configDir, instanceDir))
+			assert.NoError(t, ensureExtensions(config, profile, instance.InstanceLabel, configDir, instanceDir, testRelativeBundlePath))
 
 			assert.FileExists(t, doNotDeletePath)
 			doNotDeleteContentAfter, err := os.ReadFile(doNotDeletePath)
@@ -301,16 +311,21 @@ func TestEnsureExtensions(t *testing.T) {
 			sort.Slice(expected, func(i, j int) bool {
 				return expected[i] < expected[j]
 			})
-			actual := instanceAfter.InstalledExtensions
-			sort.Slice(actual, func(i, j int) bool {
-				return actual[i] < actual[j]
-			})
+			actual := []string{}
+			for _, extension := range instanceAfter.InstalledExtensions {
+				assert.NotEmpty(t, extension.SHA256)
+				actual = append(actual, extension.ID)
+			}
+			sort.Strings(actual)
 			assert.Equal(t, expected, actual)
 
 			extensionsAfter := make(map[string]bool)
 			for _, ext := range tC.installedExtensionsAfter {
 				extensionsAfter[ext] = true
 			}
+			for _, ext := range tC.installedExtensionsAfter {
+				assert.FileExists(t, filepath.Join(extensionsDir, fmt.Sprint(ext, ".xpi")))
+			}
 			for _, ext := range tC.installedExtensionsBefore {
 				srcPath := filepath.Join("testdata/ensure-extensions/extensions", fmt.Sprint(ext, ".xpi"))
 				dstPath := filepath.Join(extensionsDir, fmt.Sprint(ext, ".xpi"))
@@ -330,6 +345,72 @@ func TestEnsureExtensions(t *testing.T) {
 	}
 }
 
+func TestEnsureExtensionsUpdates(t *testing.T) {
+	config, profile, instance, instanceDir, cleanUpEnvironment := setUpTestEnvironment(t)
+	defer cleanUpEnvironment()
+
+	configDir := "testdata/ensure-extensions"
+	installedPath := filepath.Join(instanceDir, getRelativeProfilePath(testRelativeBundlePath), "extensions/foo@t0ast.cc.xpi")
+
+	instanceDataBytes, err := json.Marshal(instance)
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(instanceDir, uio.FileModeURWXGRWXO))
+	assert.NoError(t, os.WriteFile(filepath.Join(instanceDir, "profile-instance.json"), instanceDataBytes, uio.FileModeURWGRWO))
+
+	getInstalledVersion := func(t *testing.T) string {
+		instanceAfter, err := GetProfileInstance(config, instance.InstanceLabel)
+		assert.NoError(t, err)
+		assert.Len(t, instanceAfter.InstalledExtensions, 1)
+		return instanceAfter.InstalledExtensions[0].Version
+	}
+
+	profile.ExtensionFiles = []string{"extensions/foo@t0ast.cc.xpi"}
+	t.Run("Install", func(t *testing.T) {
+		assert.NoError(t, ensureExtensions(config, profile, instance.InstanceLabel, configDir, instanceDir, testRelativeBundlePath))
+		assert.FileExists(t, installedPath)
+		assert.Equal(t, "1.0", getInstalledVersion(t))
+	})
+
+	// The installed file is only replaced if the configured file
+	// changes, so the marker survives unchanged configuration.
+	marker := []byte("Not copied again")
+	assert.NoError(t, os.WriteFile(installedPath, marker, uio.FileModeURWGRWO))
+	t.Run("Unchanged", func(t *testing.T) {
+		assert.NoError(t, ensureExtensions(config, profile, instance.InstanceLabel, configDir, instanceDir, testRelativeBundlePath))
+		actual, err := os.ReadFile(installedPath)
+		assert.NoError(t, err)
+		assert.Equal(t, marker, actual)
+	})
+
+	profile.ExtensionFiles = []string{"extensions/foo-1.1.xpi"}
+	t.Run("Update", func(t *testing.T) {
+		assert.NoError(t, ensureExtensions(config, profile, instance.InstanceLabel, configDir, instanceDir, testRelativeBundlePath))
+		expected, err := os.ReadFile("testdata/ensure-extensions/extensions/foo-1.1.xpi")
+		assert.NoError(t, err)
+		actual, err := os.ReadFile(installedPath)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, actual)
+		assert.Equal(t, "1.1", getInstalledVersion(t))
+	})
+}
+
+func TestEnsureMothershipExtension(t *testing.T) {
+	_, _, _, instanceDir, cleanUpEnvironment := setUpTestEnvironment(t)
+	defer cleanUpEnvironment()
+
+	unlocalizedBundleDir := filepath.Join(instanceDir, relativeLauncherBundlesPath, getLauncherArchitecture(), "tor-browser")
+	assert.NoError(t, os.MkdirAll(filepath.Join(unlocalizedBundleDir, "Browser"), uio.FileModeURWXGRWXO))
+	assert.NoError(t, os.WriteFile(filepath.Join(unlocalizedBundleDir, "Browser/tbb_version.json"), []byte("{}"), uio.FileModeURWGRWO))
+	relativeBundlePath, err := findRelativeBundlePath(instanceDir, "de")
+	assert.NoError(t, err)
+
+	assert.NoError(t, ensureMothershipExtension(instanceDir, relativeBundlePath))
+
+	assert.FileExists(t, filepath.Join(instanceDir, "mothership-connector"))
+	assert.FileExists(t, filepath.Join(unlocalizedBundleDir, bundleDataPath, "Browser/.mozilla/native-messaging-hosts/mothership_native_connector.json"))
+	assert.FileExists(t, filepath.Join(unlocalizedBundleDir, bundleDataPath, "Browser/profile.default/extensions/mothership@tbml.t0ast.cc.xpi"))
+}
+
 func TestWritePortSettings(t *testing.T) {
 	somePid := 1234
 
@@ -377,13 +458,13 @@ func TestWritePortSettings(t *testing.T) {
 			_, _, instance, instanceDir, cleanUpEnvironment := setUpTestEnvironment(t)
 			defer cleanUpEnvironment()
 
-			userJSPath := filepath.Join(instanceDir, relativeProfilePath, "user.js")
+			userJSPath := filepath.Join(instanceDir, getRelativeProfilePath(testRelativeBundlePath), "user.js")
 			if tC.existingUserJSContent != "" {
 				assert.NoError(t, os.MkdirAll(filepath.Dir(userJSPath), uio.FileModeURWXGRWXO))
 				assert.NoError(t, os.WriteFile(userJSPath, []byte(tC.existingUserJSContent), uio.FileModeURWGRWO))
 			}
 
-			assert.NoError(t, writePortSettings(instanceDir, append(tC.extraInstances, instance)))
+			assert.NoError(t, writePortSettings(instanceDir, testRelativeBundlePath, append(tC.extraInstances, instance)))
 
 			actualUserJS, err := os.ReadFile(userJSPath)
 			assert.NoError(t, err)
@@ -445,6 +526,9 @@ func TestSetUpBindMounts(t *testing.T) {
 
 			cachePath: "tmp-home/.cache",
 		},
+		{
+			desc: "Default cache",
+		},
 	}
 	for _, tC := range testCases {
 		t.Run(tC.desc, func(t *testing.T) {
@@ -453,37 +537,54 @@ func TestSetUpBindMounts(t *testing.T) {
 
 			assert.Equal(t, runtime.GOOS, "linux")
 			origHome := os.Getenv("HOME")
-			origCache := os.Getenv("XDG_CACHE_HOME")
+			origCache, origCacheSet := os.LookupEnv("XDG_CACHE_HOME")
 			resetEnv := func() {
 				os.Setenv("HOME", origHome)
-				os.Setenv("XDG_CACHE_HOME", origCache)
+				if origCacheSet {
+					os.Setenv("XDG_CACHE_HOME", origCache)
+				} else {
+					os.Unsetenv("XDG_CACHE_HOME")
+				}
 			}
 			defer resetEnv()
 
 			tmpHome := filepath.Join(instanceDir, "tmp-home")
-			tmpCache := filepath.Join(instanceDir, tC.cachePath)
+			tmpCache := filepath.Join(tmpHome, ".cache")
 			os.Setenv("HOME", tmpHome)
 			if tC.cachePath == "" {
 				os.Unsetenv("XDG_CACHE_HOME")
 			} else {
+				tmpCache = filepath.Join(instanceDir, tC.cachePath)
 				os.Setenv("XDG_CACHE_HOME", tmpCache)
 			}
 
-			home, err := os.UserHomeDir()
-			assert.NoError(t, err)
-			assert.Equal(t, tmpHome, home)
-			gnupgHomedirDst := filepath.Join(home, ".local/share/torbrowser/gnupg_homedir")
-			assert.NoError(t, os.MkdirAll(gnupgHomedirDst, uio.FileModeURWXGRWXO))
+			gnupgHomedirDst := filepath.Join(tmpHome, ".local/share/torbrowser/gnupg_homedir")
+			torbrowserCacheDst := filepath.Join(tmpCache, "torbrowser")
 
-			cache, err := os.UserCacheDir()
-			assert.NoError(t, err)
-			assert.Equal(t, tmpCache, cache)
-			torbrowserCacheDst := filepath.Join(cache, "torbrowser")
-			assert.NoError(t, os.MkdirAll(torbrowserCacheDst, uio.FileModeURWXGRWXO))
+			binds, err := getInstanceBinds()
+			require.NoError(t, err)
+			assert.Equal(t, []sandboxBind{
+				{
+					Src: torbrowserCacheDst,
+					Dst: ".cache/torbrowser",
+				},
+				{
+					Src: gnupgHomedirDst,
+					Dst: ".local/share/torbrowser/gnupg_homedir",
+				},
+			}, binds)
 
-			cleanUp, err := setUpBindMounts(instanceDir)
-			assert.NoError(t, err)
+			// Only mounting needs bindfs, which CI installs.
+			if _, err := exec.LookPath("bindfs"); err != nil {
+				t.Skip("bindfs is not installed")
+			}
+
+			// Firejail run by a user other than root can't bind
+			// directories, so they are mounted with bindfs.
+			nativeBinds, cleanUp, err := setUpInstanceBinds(firejailSandbox{asRoot: false}, nil, instanceDir, os.Stdout, os.Stderr)
+			require.NoError(t, err)
 			defer cleanUp()
+			assert.Empty(t, nativeBinds)
 
 			resetEnv()
 
@@ -500,3 +601,173 @@ func TestSetUpBindMounts(t *testing.T) {
 		})
 	}
 }
+
+func TestGetBindMounts(t *testing.T) {
+	binds := []sandboxBind{
+		{
+			Dst: ".cache/torbrowser",
+			Src: "/home/user/.cache/torbrowser",
+		},
+		{
+			Dst:      testRelativeBundlePath,
+			ReadOnly: true,
+			Src:      "/bundles/11.0.4-linux-x86_64-en-US",
+		},
+		{
+			Dst: filepath.Join(testRelativeBundlePath, bundleDataPath, "Tor"),
+			Src: filepath.Join("/instance", testRelativeBundlePath, bundleDataPath, "Tor"),
+		},
+		{
+			Dst:      filepath.Join(testRelativeBundlePath, bundleDistributionPath),
+			ReadOnly: true,
+			Src:      filepath.Join("/instance", testRelativeBundlePath, bundleDistributionPath),
+		},
+	}
+
+	assert.Equal(t, []bindMountSpec{
+		{
+			Dst: "/staging/2",
+			Src: filepath.Join("/instance", testRelativeBundlePath, bundleDataPath, "Tor"),
+		},
+		{
+			Dst: "/staging/3",
+			Src: filepath.Join("/instance", testRelativeBundlePath, bundleDistributionPath),
+		},
+		{
+			Dst: "/instance/.cache/torbrowser",
+			Src: "/home/user/.cache/torbrowser",
+		},
+		{
+			Dst:      filepath.Join("/instance", testRelativeBundlePath),
+			ReadOnly: true,
+			Src:      "/bundles/11.0.4-linux-x86_64-en-US",
+		},
+		{
+			Dst: filepath.Join("/instance", testRelativeBundlePath, bundleDataPath, "Tor"),
+			Src: "/staging/2",
+		},
+		{
+			Dst:      filepath.Join("/instance", testRelativeBundlePath, bundleDistributionPath),
+			ReadOnly: true,
+			Src:      "/staging/3",
+		},
+	}, getBindMounts(binds, "/instance", "/staging"))
+}
+
+func TestSetUpInstanceBinds(t *testing.T) {
+	testCases := []struct {
+		desc string
+
+		cachePath string
+	}{
+		{
+			desc: "Cache outside of home directory",
+
+			cachePath: "tmp-cache",
+		},
+		{
+			desc: "Cache in home directory",
+
+			cachePath: "tmp-home/.cache",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			_, _, _, instanceDir, cleanUpEnvironment := setUpTestEnvironment(t)
+			defer cleanUpEnvironment()
+
+			assert.Equal(t, runtime.GOOS, "linux")
+			origHome := os.Getenv("HOME")
+			origCache := os.Getenv("XDG_CACHE_HOME")
+			defer func() {
+				os.Setenv("HOME", origHome)
+				os.Setenv("XDG_CACHE_HOME", origCache)
+			}()
+
+			tmpHome := filepath.Join(instanceDir, "tmp-home")
+			tmpCache := filepath.Join(instanceDir, tC.cachePath)
+			os.Setenv("HOME", tmpHome)
+			os.Setenv("XDG_CACHE_HOME", tmpCache)
+
+			nativeBinds, cleanUp, err := setUpInstanceBinds(bubblewrapSandbox{}, nil, instanceDir, os.Stdout, os.Stderr)
+			assert.NoError(t, err)
+			defer cleanUp()
+
+			assert.Equal(t, []sandboxBind{
+				{
+					Src: filepath.Join(tmpCache, "torbrowser"),
+					Dst: ".cache/torbrowser",
+				},
+				{
+					Src: filepath.Join(tmpHome, ".local/share/torbrowser/gnupg_homedir"),
+					Dst: ".local/share/torbrowser/gnupg_homedir",
+				},
+			}, nativeBinds)
+
+			for _, bind := range nativeBinds {
+				assert.DirExists(t, bind.Src)
+				assert.DirExists(t, filepath.Join(instanceDir, bind.Dst))
+			}
+		})
+	}
+}
+
+func TestStartInstanceManagedBundle(t *testing.T) {
+	config, profile, instance, instanceDir, cleanUpEnvironment := setUpTestEnvironment(t)
+	defer cleanUpEnvironment()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+
+	// The fake dbus-launch records the sandbox command instead of
+	// running it.
+	binDir := t.TempDir()
+	argsPath := filepath.Join(t.TempDir(), "args")
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "dbus-launch"), []byte("#!/bin/sh\nprintf '%s\\n' \"$@\" > "+argsPath+"\n"), uio.FileModeURWXGRWXO))
+	t.Setenv("PATH", binDir)
+
+	bundlePath := t.TempDir()
+	missingMirror := "file:///nonexistent"
+	version := "11.0.4"
+	config.BundlePath = &bundlePath
+	config.TorBrowser = &TorBrowserConfiguration{
+		Mirror:  &missingMirror,
+		Version: &version,
+	}
+	profile.Sandbox = SandboxBubblewrap
+	config.Profiles = []ProfileConfiguration{profile}
+
+	// The bundle is installed already, so nothing is downloaded.
+	sharedBundleDir := filepath.Join(bundlePath, "11.0.4-"+getBundleArchitecture()+"-en-US")
+	for path, content := range map[string]string{
+		"Browser/firefox": "binary",
+		"Browser/TorBrowser/Data/Browser/profile.default/shipped.js": "shipped",
+		"Browser/TorBrowser/Data/Tor/torrc-defaults":                 "defaults",
+	} {
+		fullPath := filepath.Join(sharedBundleDir, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fullPath), uio.FileModeURWXGRWXO))
+		require.NoError(t, os.WriteFile(fullPath, []byte(content), uio.FileModeURWGRWO))
+	}
+
+	exitCode, err := StartInstance(context.Background(), config, profile, instance, []ProfileInstance{instance}, "", nil, false, true, nil)
+	require.NoError(t, err)
+	assert.Equal(t, uint(0), exitCode)
+
+	// The files that the bundle ships are in the instance although the
+	// instance's profile was written to as well.
+	instanceProfileDir := filepath.Join(instanceDir, getRelativeProfilePath(testRelativeBundlePath))
+	assert.FileExists(t, filepath.Join(instanceProfileDir, "shipped.js"))
+	assert.FileExists(t, filepath.Join(instanceProfileDir, "user.js"))
+	assert.FileExists(t, filepath.Join(instanceDir, testRelativeBundlePath, bundleDataPath, "Tor/torrc-defaults"))
+
+	settings, err := os.ReadFile(filepath.Join(instanceDir, tblSettingsPath))
+	assert.NoError(t, err)
+	assert.Contains(t, string(settings), `"installed":true`)
+
+	argsBytes, err := os.ReadFile(argsPath)
+	require.NoError(t, err)
+	args := string(argsBytes)
+	assert.Contains(t, args, fmt.Sprintf("--ro-bind\n%s\n%s\n", sharedBundleDir, filepath.Join(home, testRelativeBundlePath)))
+	assert.Contains(t, args, fmt.Sprintf("--bind\n%s\n%s\n", instanceProfileDir, filepath.Join(home, getRelativeProfilePath(testRelativeBundlePath))))
+}
@@ -0,0 +1,342 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	uerror "t0ast.cc/tbml/util/error"
+)
+
+const (
+	SandboxBubblewrap = "bubblewrap"
+	SandboxFirejail   = "firejail"
+)
+
+const (
+	// NetworkHost shares the host's network namespace with the
+	// instance.
+	NetworkHost = "host"
+	// NetworkIsolated runs the instance in a network namespace of its
+	// own that only has outbound connectivity.
+	NetworkIsolated = "isolated"
+)
+
+const debugShellCommand = "fish"
+
+const tblCommand = "torbrowser-launcher"
+
+// sandboxBind is a directory of the host that is made available
+// inside the sandbox.
+type sandboxBind struct {
+	// Dst is the path inside the sandbox, relative to the home
+	// directory.
+	Dst      string
+	ReadOnly bool
+	// Src is the path on the host.
+	Src string
+}
+
+// sandboxSpec describes what the sandbox of an instance looks like,
+// independent of the tool that implements it.
+type sandboxSpec struct {
+	// Binds are bound into the sandbox by the sandbox itself. They
+	// are only set if the sandbox supports native binds.
+	Binds      []sandboxBind
+	DebugShell bool
+	// Home is the path of the user's home directory inside the
+	// sandbox.
+	Home string
+	// InstanceDir is mounted as the home directory inside the
+	// sandbox.
+	InstanceDir string
+	// IsolateNetwork runs the sandbox in a network namespace of its
+	// own.
+	IsolateNetwork bool
+	// NetworkInterface is the host's network interface that an
+	// isolated network namespace is connected through. Firejail
+	// requires it, for bubblewrap it's optional.
+	NetworkInterface string
+	// WaylandSocket is the path of the Wayland compositor's socket,
+	// if there is one.
+	WaylandSocket string
+	// XAuthority is the path of the X server's authorization file,
+	// if there is one.
+	XAuthority string
+}
+
+// sandbox is a tool that can run torbrowser-launcher (or a debug
+// shell) isolated from the rest of the system.
+type sandbox interface {
+	// command returns the argv that runs the sandbox.
+	command(spec sandboxSpec) []string
+	// nativeBinds reports whether the sandbox can bind directories
+	// of the host into itself. If it can't, the directories are
+	// mounted into the instance directory with bindfs before the
+	// sandbox is started.
+	nativeBinds() bool
+}
+
+type firejailSandbox struct {
+	// asRoot is true if firejail is run by root. Firejail only
+	// allows --bind for root.
+	asRoot bool
+}
+
+func (firejailSandbox) command(spec sandboxSpec) []string {
+	args := []string{
+		"firejail", fmt.Sprintf("--private=%s", spec.InstanceDir),
+	}
+	if spec.IsolateNetwork {
+		// The network namespace is connected to the host's LAN
+		// through a macvlan device with an address of its own, so
+		// firejail's default filter drops connections that the
+		// instance didn't initiate. The filter is given here
+		// instead of relying on the profile, which the debug shell
+		// doesn't use and the user can override.
+		args = append(args, fmt.Sprint("--net=", spec.NetworkInterface), "--netfilter")
+	}
+	for _, bind := range spec.Binds {
+		args = append(args, fmt.Sprintf("--bind=%s,%s", bind.Src, filepath.Join(spec.Home, bind.Dst)))
+	}
+	// Firejail can't bind read-only directly, so the binds are made
+	// read-only afterwards. Writable binds inside of read-only ones
+	// are made writable again.
+	for _, bind := range spec.Binds {
+		if bind.ReadOnly {
+			args = append(args, fmt.Sprint("--read-only=", filepath.Join(spec.Home, bind.Dst)))
+		}
+	}
+	for _, bind := range spec.Binds {
+		if !bind.ReadOnly && isInReadOnlyBind(bind, spec.Binds) {
+			args = append(args, fmt.Sprint("--read-write=", filepath.Join(spec.Home, bind.Dst)))
+		}
+	}
+	if spec.DebugShell {
+		return append(args, "--noprofile", debugShellCommand)
+	}
+	return append(args, fmt.Sprint("--profile=", filepath.Join(spec.InstanceDir, tblFirejailProfileFileName)), tblCommand)
+}
+
+func (sb firejailSandbox) nativeBinds() bool {
+	return sb.asRoot
+}
+
+func isInReadOnlyBind(bind sandboxBind, binds []sandboxBind) bool {
+	for _, other := range binds {
+		if other.ReadOnly && strings.HasPrefix(bind.Dst, other.Dst+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// bubblewrapEtcFiles are the files from /etc that are available in
+// the bubblewrap sandbox. This mirrors the "private-etc" directive of
+// the firejail profile.
+var bubblewrapEtcFiles = []string{
+	"alsa",
+	"alternatives",
+	"asound.conf",
+	"ca-certificates",
+	"crypto-policies",
+	"fonts",
+	"group",
+	"ld.so.cache",
+	"ld.so.conf",
+	"ld.so.conf.d",
+	"ld.so.preload",
+	"machine-id",
+	"passwd",
+	"pki",
+	"pulse",
+	"resolv.conf",
+	"ssl",
+}
+
+type bubblewrapSandbox struct{}
+
+func (bubblewrapSandbox) command(spec sandboxSpec) []string {
+	args := []string{}
+	if spec.IsolateNetwork {
+		// bubblewrap can only unshare the network namespace
+		// entirely, so pasta creates one with a userspace network
+		// stack that bubblewrap shares. Port forwarding in both
+		// directions is disabled, so the instance can't reach
+		// services on the host (like other instances' Tor ports) and
+		// vice versa.
+		args = append(args, "pasta", "--config-net", "--quiet", "-t", "none", "-u", "none", "-T", "none", "-U", "none")
+		if spec.NetworkInterface != "" {
+			args = append(args, "-i", spec.NetworkInterface)
+		}
+		args = append(args, "--")
+	}
+	args = append(args,
+		"bwrap",
+		"--unshare-all",
+		"--share-net",
+		"--die-with-parent",
+		"--new-session",
+		"--ro-bind", "/usr", "/usr",
+		"--ro-bind-try", "/lib", "/lib",
+		"--ro-bind-try", "/lib64", "/lib64",
+		"--ro-bind-try", "/bin", "/bin",
+		"--ro-bind-try", "/sbin", "/sbin",
+	)
+	for _, etcFile := range bubblewrapEtcFiles {
+		etcPath := filepath.Join("/etc", etcFile)
+		args = append(args, "--ro-bind-try", etcPath, etcPath)
+	}
+	args = append(args,
+		"--proc", "/proc",
+		"--dev", "/dev",
+		"--tmpfs", "/tmp",
+		"--ro-bind-try", "/tmp/.X11-unix", "/tmp/.X11-unix",
+		"--bind", spec.InstanceDir, spec.Home,
+	)
+	for _, bind := range spec.Binds {
+		bindArg := "--bind"
+		if bind.ReadOnly {
+			bindArg = "--ro-bind"
+		}
+		args = append(args, bindArg, bind.Src, filepath.Join(spec.Home, bind.Dst))
+	}
+	// The display files are bound to the same path, which the
+	// environment variables that point to them still hold inside of
+	// the sandbox.
+	for _, displayFile := range []string{spec.XAuthority, spec.WaylandSocket} {
+		if displayFile != "" {
+			args = append(args, "--ro-bind-try", displayFile, displayFile)
+		}
+	}
+	args = append(args,
+		"--setenv", "HOME", spec.Home,
+		"--chdir", spec.Home,
+	)
+	if spec.DebugShell {
+		return append(args, debugShellCommand)
+	}
+	return append(args, tblCommand)
+}
+
+func (bubblewrapSandbox) nativeBinds() bool {
+	return true
+}
+
+// getSandbox returns the sandbox that is configured for the profile.
+func getSandbox(profile ProfileConfiguration) (sandbox, error) {
+	switch profile.Sandbox {
+	case "", SandboxFirejail:
+		return firejailSandbox{
+			asRoot: os.Geteuid() == 0,
+		}, nil
+	case SandboxBubblewrap:
+		return bubblewrapSandbox{}, nil
+	default:
+		return nil, uerror.StackTracef("Profile %s: Unknown sandbox %q (expected \"%s\" or \"%s\")", profile.Label, profile.Sandbox, SandboxFirejail, SandboxBubblewrap)
+	}
+}
+
+// getNetworkIsolation returns whether the network of the profile's
+// instances is isolated and through which host interface.
+func getNetworkIsolation(profile ProfileConfiguration) (isolate bool, networkInterface string, err error) {
+	if profile.NetworkInterface != nil {
+		networkInterface = *profile.NetworkInterface
+	}
+	switch profile.Network {
+	case "", NetworkHost:
+		if profile.NetworkInterface != nil {
+			return false, "", uerror.StackTracef("Profile %s: NetworkInterface has no effect unless Network is \"%s\"", profile.Label, NetworkIsolated)
+		}
+		return false, "", nil
+	case NetworkIsolated:
+		if networkInterface == "" && (profile.Sandbox == "" || profile.Sandbox == SandboxFirejail) {
+			return false, "", uerror.StackTracef("Profile %s: The firejail sandbox needs a NetworkInterface to isolate the network", profile.Label)
+		}
+		return true, networkInterface, nil
+	default:
+		return false, "", uerror.StackTracef("Profile %s: Unknown network mode %q (expected \"%s\" or \"%s\")", profile.Label, profile.Network, NetworkHost, NetworkIsolated)
+	}
+}
+
+// getDisplayFiles returns the paths of the Wayland socket and the X
+// authorization file from the environment. They are empty if they
+// aren't set.
+func getDisplayFiles() (waylandSocket, xAuthority string) {
+	if waylandDisplay := os.Getenv("WAYLAND_DISPLAY"); waylandDisplay != "" {
+		waylandSocket = waylandDisplay
+		if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); !filepath.IsAbs(waylandSocket) && runtimeDir != "" {
+			waylandSocket = filepath.Join(runtimeDir, waylandDisplay)
+		}
+		if !filepath.IsAbs(waylandSocket) {
+			waylandSocket = ""
+		}
+	}
+	return waylandSocket, os.Getenv("XAUTHORITY")
+}
+
+// relativeGnupgHomedirPath is the path of torbrowser-launcher's gnupg
+// homedir, relative to the home directory.
+const relativeGnupgHomedirPath = ".local/share/torbrowser/gnupg_homedir"
+
+// getGnupgHomedir returns the path of torbrowser-launcher's gnupg
+// homedir on the host, which is shared with every instance.
+func getGnupgHomedir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	return filepath.Join(home, relativeGnupgHomedirPath), nil
+}
+
+// getInstanceBinds returns the directories of the host that are
+// shared with every instance.
+func getInstanceBinds() ([]sandboxBind, error) {
+	gnupgHomedir, err := getGnupgHomedir()
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	return []sandboxBind{
+		{
+			Src: filepath.Join(cache, "torbrowser"),
+			Dst: ".cache/torbrowser",
+		},
+		{
+			Src: gnupgHomedir,
+			Dst: relativeGnupgHomedirPath,
+		},
+	}, nil
+}
+
+// runSandbox runs the sandbox with a D-Bus session bus of its own.
+// The bus is only reachable from within a bubblewrap sandbox that
+// shares the host's network, because dbus-launch listens on an
+// abstract socket, which belongs to the network namespace, or in /tmp,
+// which bubblewrap replaces. The firejail profile blocks D-Bus
+// altogether. Tor Browser works without the bus.
+func runSandbox(ctx context.Context, sb sandbox, spec sandboxSpec, stdin io.Reader, stdout, stderr io.Writer) (uint, error) {
+	sandboxArgs := append([]string{"dbus-launch"}, sb.command(spec)...)
+
+	sandboxCmd := exec.CommandContext(ctx, sandboxArgs[0], sandboxArgs[1:]...)
+	sandboxCmd.Env = append(os.Environ(), "XDG_CACHE_HOME=")
+	sandboxCmd.Stdin = stdin
+	sandboxCmd.Stdout = stdout
+	sandboxCmd.Stderr = stderr
+
+	if err := sandboxCmd.Run(); err != nil {
+		if err, ok := err.(*exec.ExitError); ok {
+			return uint(err.ExitCode()), nil
+		}
+		return 0, uerror.WithStackTrace(err)
+	}
+
+	return 0, nil
+}
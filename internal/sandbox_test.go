@@ -0,0 +1,425 @@
+package internal
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSandboxCommand(t *testing.T) {
+	bubblewrapPrefix := []string{
+		"bwrap",
+		"--unshare-all",
+		"--share-net",
+		"--die-with-parent",
+		"--new-session",
+		"--ro-bind", "/usr", "/usr",
+		"--ro-bind-try", "/lib", "/lib",
+		"--ro-bind-try", "/lib64", "/lib64",
+		"--ro-bind-try", "/bin", "/bin",
+		"--ro-bind-try", "/sbin", "/sbin",
+		"--ro-bind-try", "/etc/alsa", "/etc/alsa",
+		"--ro-bind-try", "/etc/alternatives", "/etc/alternatives",
+		"--ro-bind-try", "/etc/asound.conf", "/etc/asound.conf",
+		"--ro-bind-try", "/etc/ca-certificates", "/etc/ca-certificates",
+		"--ro-bind-try", "/etc/crypto-policies", "/etc/crypto-policies",
+		"--ro-bind-try", "/etc/fonts", "/etc/fonts",
+		"--ro-bind-try", "/etc/group", "/etc/group",
+		"--ro-bind-try", "/etc/ld.so.cache", "/etc/ld.so.cache",
+		"--ro-bind-try", "/etc/ld.so.conf", "/etc/ld.so.conf",
+		"--ro-bind-try", "/etc/ld.so.conf.d", "/etc/ld.so.conf.d",
+		"--ro-bind-try", "/etc/ld.so.preload", "/etc/ld.so.preload",
+		"--ro-bind-try", "/etc/machine-id", "/etc/machine-id",
+		"--ro-bind-try", "/etc/passwd", "/etc/passwd",
+		"--ro-bind-try", "/etc/pki", "/etc/pki",
+		"--ro-bind-try", "/etc/pulse", "/etc/pulse",
+		"--ro-bind-try", "/etc/resolv.conf", "/etc/resolv.conf",
+		"--ro-bind-try", "/etc/ssl", "/etc/ssl",
+		"--proc", "/proc",
+		"--dev", "/dev",
+		"--tmpfs", "/tmp",
+		"--ro-bind-try", "/tmp/.X11-unix", "/tmp/.X11-unix",
+		"--bind", "/profiles/1", "/home/user",
+	}
+	bubblewrapSuffix := []string{
+		"--setenv", "HOME", "/home/user",
+		"--chdir", "/home/user",
+	}
+	binds := []sandboxBind{
+		{
+			Src: "/home/user/.cache/torbrowser",
+			Dst: ".cache/torbrowser",
+		},
+		{
+			Src: "/home/user/.local/share/torbrowser/gnupg_homedir",
+			Dst: ".local/share/torbrowser/gnupg_homedir",
+		},
+	}
+	readOnlyBinds := []sandboxBind{
+		{
+			Dst:      "tbb",
+			ReadOnly: true,
+			Src:      "/bundles/11.0.4",
+		},
+		{
+			Dst: "tbb/Data",
+			Src: "/profiles/1/tbb/Data",
+		},
+	}
+	bubblewrapCommand := func(binds []string, command string) []string {
+		args := append([]string{}, bubblewrapPrefix...)
+		args = append(args, binds...)
+		args = append(args, bubblewrapSuffix...)
+		return append(args, command)
+	}
+
+	type test struct {
+		desc     string
+		sandbox  sandbox
+		spec     sandboxSpec
+		expected []string
+	}
+	tests := []test{
+		{
+			desc:    "firejail",
+			sandbox: firejailSandbox{},
+			spec: sandboxSpec{
+				Home:        "/home/user",
+				InstanceDir: "/profiles/1",
+			},
+			expected: []string{"firejail", "--private=/profiles/1", "--profile=/profiles/1/torbrowser-launcher.profile", "torbrowser-launcher"},
+		},
+		{
+			desc:    "firejail debug shell",
+			sandbox: firejailSandbox{},
+			spec: sandboxSpec{
+				DebugShell:  true,
+				Home:        "/home/user",
+				InstanceDir: "/profiles/1",
+			},
+			expected: []string{"firejail", "--private=/profiles/1", "--noprofile", "fish"},
+		},
+		{
+			desc:    "firejail with isolated network",
+			sandbox: firejailSandbox{},
+			spec: sandboxSpec{
+				Home:             "/home/user",
+				InstanceDir:      "/profiles/1",
+				IsolateNetwork:   true,
+				NetworkInterface: "eth0",
+			},
+			expected: []string{"firejail", "--private=/profiles/1", "--net=eth0", "--netfilter", "--profile=/profiles/1/torbrowser-launcher.profile", "torbrowser-launcher"},
+		},
+		{
+			desc:    "firejail debug shell with isolated network",
+			sandbox: firejailSandbox{},
+			spec: sandboxSpec{
+				DebugShell:       true,
+				Home:             "/home/user",
+				InstanceDir:      "/profiles/1",
+				IsolateNetwork:   true,
+				NetworkInterface: "eth0",
+			},
+			expected: []string{"firejail", "--private=/profiles/1", "--net=eth0", "--netfilter", "--noprofile", "fish"},
+		},
+		{
+			desc:    "firejail with binds",
+			sandbox: firejailSandbox{asRoot: true},
+			spec: sandboxSpec{
+				Binds:       binds,
+				Home:        "/home/user",
+				InstanceDir: "/profiles/1",
+			},
+			expected: []string{
+				"firejail", "--private=/profiles/1",
+				"--bind=/home/user/.cache/torbrowser,/home/user/.cache/torbrowser",
+				"--bind=/home/user/.local/share/torbrowser/gnupg_homedir,/home/user/.local/share/torbrowser/gnupg_homedir",
+				"--profile=/profiles/1/torbrowser-launcher.profile", "torbrowser-launcher",
+			},
+		},
+		{
+			desc:    "firejail with read-only binds",
+			sandbox: firejailSandbox{asRoot: true},
+			spec: sandboxSpec{
+				Binds:       readOnlyBinds,
+				Home:        "/home/user",
+				InstanceDir: "/profiles/1",
+			},
+			expected: []string{
+				"firejail", "--private=/profiles/1",
+				"--bind=/bundles/11.0.4,/home/user/tbb",
+				"--bind=/profiles/1/tbb/Data,/home/user/tbb/Data",
+				"--read-only=/home/user/tbb",
+				"--read-write=/home/user/tbb/Data",
+				"--profile=/profiles/1/torbrowser-launcher.profile", "torbrowser-launcher",
+			},
+		},
+		{
+			desc:    "bubblewrap",
+			sandbox: bubblewrapSandbox{},
+			spec: sandboxSpec{
+				Home:        "/home/user",
+				InstanceDir: "/profiles/1",
+			},
+			expected: bubblewrapCommand(nil, "torbrowser-launcher"),
+		},
+		{
+			desc:    "bubblewrap with binds",
+			sandbox: bubblewrapSandbox{},
+			spec: sandboxSpec{
+				Binds:       binds,
+				Home:        "/home/user",
+				InstanceDir: "/profiles/1",
+			},
+			expected: bubblewrapCommand([]string{
+				"--bind", "/home/user/.cache/torbrowser", "/home/user/.cache/torbrowser",
+				"--bind", "/home/user/.local/share/torbrowser/gnupg_homedir", "/home/user/.local/share/torbrowser/gnupg_homedir",
+			}, "torbrowser-launcher"),
+		},
+		{
+			desc:    "bubblewrap with read-only binds",
+			sandbox: bubblewrapSandbox{},
+			spec: sandboxSpec{
+				Binds:       readOnlyBinds,
+				Home:        "/home/user",
+				InstanceDir: "/profiles/1",
+			},
+			expected: bubblewrapCommand([]string{
+				"--ro-bind", "/bundles/11.0.4", "/home/user/tbb",
+				"--bind", "/profiles/1/tbb/Data", "/home/user/tbb/Data",
+			}, "torbrowser-launcher"),
+		},
+		{
+			desc:    "bubblewrap with display files",
+			sandbox: bubblewrapSandbox{},
+			spec: sandboxSpec{
+				Home:          "/home/user",
+				InstanceDir:   "/profiles/1",
+				WaylandSocket: "/run/user/1000/wayland-0",
+				XAuthority:    "/home/user/.Xauthority",
+			},
+			expected: bubblewrapCommand([]string{
+				"--ro-bind-try", "/home/user/.Xauthority", "/home/user/.Xauthority",
+				"--ro-bind-try", "/run/user/1000/wayland-0", "/run/user/1000/wayland-0",
+			}, "torbrowser-launcher"),
+		},
+		{
+			desc:    "bubblewrap with isolated network",
+			sandbox: bubblewrapSandbox{},
+			spec: sandboxSpec{
+				Home:           "/home/user",
+				InstanceDir:    "/profiles/1",
+				IsolateNetwork: true,
+			},
+			expected: append([]string{"pasta", "--config-net", "--quiet", "-t", "none", "-u", "none", "-T", "none", "-U", "none", "--"}, bubblewrapCommand(nil, "torbrowser-launcher")...),
+		},
+		{
+			desc:    "bubblewrap with isolated network through interface",
+			sandbox: bubblewrapSandbox{},
+			spec: sandboxSpec{
+				Home:             "/home/user",
+				InstanceDir:      "/profiles/1",
+				IsolateNetwork:   true,
+				NetworkInterface: "wlan0",
+			},
+			expected: append([]string{"pasta", "--config-net", "--quiet", "-t", "none", "-u", "none", "-T", "none", "-U", "none", "-i", "wlan0", "--"}, bubblewrapCommand(nil, "torbrowser-launcher")...),
+		},
+		{
+			desc:    "bubblewrap debug shell",
+			sandbox: bubblewrapSandbox{},
+			spec: sandboxSpec{
+				DebugShell:  true,
+				Home:        "/home/user",
+				InstanceDir: "/profiles/1",
+			},
+			expected: bubblewrapCommand(nil, "fish"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.sandbox.command(tt.spec))
+		})
+	}
+}
+
+func TestGetSandbox(t *testing.T) {
+	type test struct {
+		desc     string
+		sandbox  string
+		expected sandbox
+	}
+	tests := []test{
+		{
+			desc:     "Default",
+			sandbox:  "",
+			expected: firejailSandbox{asRoot: os.Geteuid() == 0},
+		},
+		{
+			desc:     "firejail",
+			sandbox:  SandboxFirejail,
+			expected: firejailSandbox{asRoot: os.Geteuid() == 0},
+		},
+		{
+			desc:     "bubblewrap",
+			sandbox:  SandboxBubblewrap,
+			expected: bubblewrapSandbox{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			actual, err := getSandbox(ProfileConfiguration{
+				Label:   "test",
+				Sandbox: tt.sandbox,
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, actual)
+		})
+	}
+
+	t.Run("Unknown", func(t *testing.T) {
+		_, err := getSandbox(ProfileConfiguration{
+			Label:   "test",
+			Sandbox: "chroot",
+		})
+		assert.EqualError(t, err, "Profile test: Unknown sandbox \"chroot\" (expected \"firejail\" or \"bubblewrap\")")
+	})
+}
+
+func TestGetDisplayFiles(t *testing.T) {
+	type test struct {
+		desc                  string
+		env                   map[string]string
+		expectedWaylandSocket string
+		expectedXAuthority    string
+	}
+	tests := []test{
+		{
+			desc: "None",
+		},
+		{
+			desc: "Wayland",
+			env: map[string]string{
+				"WAYLAND_DISPLAY": "wayland-0",
+				"XDG_RUNTIME_DIR": "/run/user/1000",
+			},
+			expectedWaylandSocket: "/run/user/1000/wayland-0",
+		},
+		{
+			desc: "Wayland with absolute socket path",
+			env: map[string]string{
+				"WAYLAND_DISPLAY": "/tmp/wayland-1",
+			},
+			expectedWaylandSocket: "/tmp/wayland-1",
+		},
+		{
+			desc: "Wayland without runtime directory",
+			env: map[string]string{
+				"WAYLAND_DISPLAY": "wayland-0",
+			},
+		},
+		{
+			desc: "X authorization",
+			env: map[string]string{
+				"XAUTHORITY": "/home/user/.Xauthority",
+			},
+			expectedXAuthority: "/home/user/.Xauthority",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			for _, k := range []string{"WAYLAND_DISPLAY", "XAUTHORITY", "XDG_RUNTIME_DIR"} {
+				t.Setenv(k, tt.env[k])
+			}
+			waylandSocket, xAuthority := getDisplayFiles()
+			assert.Equal(t, tt.expectedWaylandSocket, waylandSocket)
+			assert.Equal(t, tt.expectedXAuthority, xAuthority)
+		})
+	}
+}
+
+func TestGetNetworkIsolation(t *testing.T) {
+	eth0 := "eth0"
+
+	type test struct {
+		desc                     string
+		profile                  ProfileConfiguration
+		expectedIsolate          bool
+		expectedNetworkInterface string
+		expectedError            string
+	}
+	tests := []test{
+		{
+			desc: "Default",
+			profile: ProfileConfiguration{
+				Label: "test",
+			},
+		},
+		{
+			desc: "Host",
+			profile: ProfileConfiguration{
+				Label:   "test",
+				Network: NetworkHost,
+			},
+		},
+		{
+			desc: "Isolated with firejail",
+			profile: ProfileConfiguration{
+				Label:            "test",
+				Network:          NetworkIsolated,
+				NetworkInterface: &eth0,
+			},
+			expectedIsolate:          true,
+			expectedNetworkInterface: "eth0",
+		},
+		{
+			desc: "Isolated with bubblewrap",
+			profile: ProfileConfiguration{
+				Label:   "test",
+				Network: NetworkIsolated,
+				Sandbox: SandboxBubblewrap,
+			},
+			expectedIsolate: true,
+		},
+		{
+			desc: "Isolated with firejail without interface",
+			profile: ProfileConfiguration{
+				Label:   "test",
+				Network: NetworkIsolated,
+			},
+			expectedError: "Profile test: The firejail sandbox needs a NetworkInterface to isolate the network",
+		},
+		{
+			desc: "Interface without isolation",
+			profile: ProfileConfiguration{
+				Label:            "test",
+				NetworkInterface: &eth0,
+			},
+			expectedError: "Profile test: NetworkInterface has no effect unless Network is \"isolated\"",
+		},
+		{
+			desc: "Unknown",
+			profile: ProfileConfiguration{
+				Label:   "test",
+				Network: "vpn",
+			},
+			expectedError: "Profile test: Unknown network mode \"vpn\" (expected \"host\" or \"isolated\")",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			isolate, networkInterface, err := getNetworkIsolation(tt.profile)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedIsolate, isolate)
+			assert.Equal(t, tt.expectedNetworkInterface, networkInterface)
+		})
+	}
+}
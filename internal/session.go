@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"t0ast.cc/tbml/mothership-connector/com"
+	uerror "t0ast.cc/tbml/util/error"
+	uio "t0ast.cc/tbml/util/io"
+)
+
+const sessionFileName = "session.json"
+
+// Session is the tabs that were open when an instance that was used
+// for a topic exited. Profiles with SaveSession enabled save it in
+// the instance directory.
+type Session struct {
+	InstanceLabel string
+	Saved         time.Time
+	Tabs          []com.SessionTab
+	Topic         string
+}
+
+// StartURLs returns the URLs of the session's tabs.
+func (s Session) StartURLs() ([]*url.URL, error) {
+	startURLs := []*url.URL{}
+	for _, tab := range s.Tabs {
+		u, err := url.Parse(tab.URL)
+		if err != nil {
+			return nil, uerror.StackTracef("Session of topic %s: %w", s.Topic, err)
+		}
+		startURLs = append(startURLs, u)
+	}
+	return startURLs, nil
+}
+
+// sessionRecorder keeps the latest tabs that Mothership reported, so
+// that they can be saved once the instance exits.
+type sessionRecorder struct {
+	mutex sync.Mutex
+	tabs  []com.SessionTab
+}
+
+func (r *sessionRecorder) record(tabs []com.SessionTab) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.tabs = tabs
+}
+
+// save writes the recorded session into the instance directory. Nothing
+// is written if Mothership never reported the tabs, so that an earlier
+// session isn't lost if it didn't connect.
+func (r *sessionRecorder) save(instanceDir, instanceLabel, topic string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.tabs == nil {
+		return nil
+	}
+
+	sessionBytes, err := json.Marshal(Session{
+		InstanceLabel: instanceLabel,
+		Saved:         time.Now(),
+		Tabs:          r.tabs,
+		Topic:         topic,
+	})
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	return uerror.WithStackTrace(os.WriteFile(filepath.Join(instanceDir, sessionFileName), sessionBytes, uio.FileModeURWGRWO))
+}
+
+// FindSession returns the most recently saved session of the topic in
+// an instance of the profile, or nil if there is none or the most
+// recent session has no tabs to restore. An older session is never
+// returned in place of a more recent one without tabs, because its
+// tabs were closed since.
+func FindSession(config Configuration, instances []ProfileInstance, profileLabel, topic string) (*Session, error) {
+	var latest *Session
+	for _, instance := range instances {
+		if instance.ProfileLabel != profileLabel {
+			continue
+		}
+		sessionBytes, err := os.ReadFile(filepath.Join(getInstanceDir(config, instance), sessionFileName))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, uerror.WithStackTrace(err)
+		}
+		session := Session{}
+		if err := json.Unmarshal(sessionBytes, &session); err != nil {
+			return nil, uerror.StackTracef("Failed to unmarshal session of instance %s: %w", instance.InstanceLabel, err)
+		}
+		if session.Topic != topic {
+			continue
+		}
+		if latest == nil || session.Saved.After(latest.Saved) {
+			latest = &session
+		}
+	}
+	if latest == nil || len(latest.Tabs) == 0 {
+		return nil, nil
+	}
+	return latest, nil
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"t0ast.cc/tbml/mothership-connector/com"
+	uio "t0ast.cc/tbml/util/io"
+)
+
+func TestFindSession(t *testing.T) {
+	tabs := []com.SessionTab{
+		{Title: "Example", URL: "https://example.com/"},
+	}
+	now := time.Now()
+
+	testCases := []struct {
+		desc string
+
+		expected *Session
+		sessions map[string]Session
+	}{
+		{
+			desc: "No session",
+
+			sessions: map[string]Session{},
+		},
+		{
+			desc: "Other topic",
+
+			sessions: map[string]Session{
+				"a-1": {InstanceLabel: "a-1", Saved: now, Tabs: tabs, Topic: "other"},
+			},
+		},
+		{
+			desc: "Session without tabs",
+
+			sessions: map[string]Session{
+				"a-1": {InstanceLabel: "a-1", Saved: now, Tabs: []com.SessionTab{}, Topic: "topic"},
+			},
+		},
+		{
+			desc: "Latest session without tabs",
+
+			sessions: map[string]Session{
+				"a-1": {InstanceLabel: "a-1", Saved: now, Tabs: []com.SessionTab{}, Topic: "topic"},
+				"a-2": {InstanceLabel: "a-2", Saved: now.Add(-time.Hour), Tabs: tabs, Topic: "topic"},
+			},
+		},
+		{
+			desc: "Session of other profile",
+
+			expected: &Session{InstanceLabel: "a-2", Saved: now.Add(-time.Hour), Tabs: tabs, Topic: "topic"},
+			sessions: map[string]Session{
+				"a-2": {InstanceLabel: "a-2", Saved: now.Add(-time.Hour), Tabs: tabs, Topic: "topic"},
+				"b-1": {InstanceLabel: "b-1", Saved: now, Tabs: tabs, Topic: "topic"},
+			},
+		},
+		{
+			desc: "Latest session",
+
+			expected: &Session{InstanceLabel: "a-1", Saved: now, Tabs: tabs, Topic: "topic"},
+			sessions: map[string]Session{
+				"a-1": {InstanceLabel: "a-1", Saved: now, Tabs: tabs, Topic: "topic"},
+				"a-2": {InstanceLabel: "a-2", Saved: now.Add(-time.Hour), Tabs: tabs, Topic: "topic"},
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			config := Configuration{
+				ProfilePath: t.TempDir(),
+			}
+			instances := []ProfileInstance{
+				{InstanceLabel: "a-1", ProfileLabel: "a"},
+				{InstanceLabel: "a-2", ProfileLabel: "a"},
+				{InstanceLabel: "b-1", ProfileLabel: "b"},
+			}
+			for _, instance := range instances {
+				instanceDir := getInstanceDir(config, instance)
+				require.NoError(t, os.MkdirAll(instanceDir, uio.FileModeURWXGRWXO))
+				session, ok := tC.sessions[instance.InstanceLabel]
+				if !ok {
+					continue
+				}
+				sessionBytes, err := json.Marshal(session)
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(filepath.Join(instanceDir, sessionFileName), sessionBytes, uio.FileModeURWGRWO))
+			}
+
+			actual, err := FindSession(config, instances, "a", "topic")
+			require.NoError(t, err)
+			if tC.expected == nil {
+				assert.Nil(t, actual)
+				return
+			}
+			require.NotNil(t, actual)
+			assert.Equal(t, tC.expected.InstanceLabel, actual.InstanceLabel)
+			assert.Equal(t, tC.expected.Tabs, actual.Tabs)
+		})
+	}
+}
+
+func TestSessionRecorder(t *testing.T) {
+	config := Configuration{
+		ProfilePath: t.TempDir(),
+	}
+	instance := ProfileInstance{InstanceLabel: "a-1", ProfileLabel: "a"}
+	instanceDir := getInstanceDir(config, instance)
+	require.NoError(t, os.MkdirAll(instanceDir, uio.FileModeURWXGRWXO))
+
+	recorder := sessionRecorder{}
+	require.NoError(t, recorder.save(instanceDir, instance.InstanceLabel, "topic"))
+	exists, err := uio.FileExists(filepath.Join(instanceDir, sessionFileName))
+	require.NoError(t, err)
+	assert.False(t, exists, "A session must only be saved once Mothership reported one")
+
+	tabs := []com.SessionTab{
+		{Title: "Example", URL: "https://example.com/"},
+	}
+	recorder.record(tabs)
+	require.NoError(t, recorder.save(instanceDir, instance.InstanceLabel, "topic"))
+	session, err := FindSession(config, []ProfileInstance{instance}, "a", "topic")
+	require.NoError(t, err)
+	require.NotNil(t, session)
+	assert.Equal(t, tabs, session.Tabs)
+	startURLs, err := session.StartURLs()
+	require.NoError(t, err)
+	require.Len(t, startURLs, 1)
+	assert.Equal(t, "https://example.com/", startURLs[0].String())
+}
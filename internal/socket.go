@@ -3,17 +3,69 @@ package internal
 import (
 	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"net"
 	"net/url"
-	"os"
 	"path/filepath"
+	"time"
 
+	"t0ast.cc/tbml/mothership-connector/com"
 	uerror "t0ast.cc/tbml/util/error"
+	ulog "t0ast.cc/tbml/util/log"
 )
 
+const mothershipHello = "Hello from Mothership! :>"
+
+// requestTimeout is how long SendRequest waits for Mothership's
+// response.
+const requestTimeout = 10 * time.Second
+
+var ErrCommandFailed error = errors.New("Mothership command failed")
+
+// Command is a command that Mothership runs in the browser when it's
+// requested with SendRequest.
+type Command string
+
+const (
+	// CommandCloseTab closes a tab. It takes CloseTabArgs and has no
+	// result.
+	CommandCloseTab Command = "close-tab"
+	// CommandFocusWindow focuses a window. It takes FocusWindowArgs
+	// and has no result.
+	CommandFocusWindow Command = "focus-window"
+	// CommandGetVersion results in the Version of the browser and of
+	// Mothership.
+	CommandGetVersion Command = "get-version"
+	// CommandListTabs results in the open tabs as a []Tab.
+	CommandListTabs Command = "list-tabs"
+	// CommandPing results in "pong".
+	CommandPing Command = "ping"
+)
+
+type CloseTabArgs struct {
+	TabID int `json:"tabId"`
+}
+
+type FocusWindowArgs struct {
+	WindowID int `json:"windowId"`
+}
+
+type Tab struct {
+	Active   bool   `json:"active"`
+	ID       int    `json:"id"`
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+	WindowID int    `json:"windowId"`
+}
+
+type Version struct {
+	Browser   string `json:"browser"`
+	Extension string `json:"extension"`
+}
+
 type broadcastChannelOpenEvent struct {
 	connectionID int
 	channel      chan interface{}
@@ -27,40 +79,123 @@ type openTabBroadcast struct {
 	URL string
 }
 
-type openedStartURLBroadcast struct{}
+// requestBroadcast forwards a request to Mothership. The hub remembers
+// which connection the request came from, so that it can route the
+// response back to it.
+type requestBroadcast struct {
+	connectionID int
+	request      com.Request
+}
 
-type startURLBroadcast struct {
-	startURL *url.URL
+// responseBroadcast is only delivered to the connection that sent the
+// request.
+type responseBroadcast struct {
+	response com.Response
 }
 
-type socketMsgType string
+// openedTabBroadcast is only handled by the hub, which tells all
+// connections with an openedStartURLBroadcast if the tab is one of the
+// start URLs.
+type openedTabBroadcast struct {
+	URL string
+}
 
-const (
-	socketMsgTypeOpenedTab socketMsgType = "opened-tab"
-	socketMsgTypeOpenTab   socketMsgType = "open-tab"
-)
+// openedStartURLBroadcast carries the start URLs that haven't been
+// opened yet.
+type openedStartURLBroadcast struct {
+	startURLs []*url.URL
+}
+
+// sessionBroadcast is only handled by the hub, which passes the
+// session on to the onSession callback.
+type sessionBroadcast struct {
+	session com.SessionMsg
+}
+
+type bookmarksBroadcast struct {
+	bookmarks []com.Bookmark
+}
+
+type startURLBroadcast struct {
+	startURLs []*url.URL
+}
 
-func ListenOnExternalUnixSocket(ctx context.Context, listener *net.UnixListener, startURL *url.URL) {
+// ListenOnExternalUnixSocket accepts connections on the instance's
+// control socket until the listener is closed. Mothership is asked to
+// open the start URLs once it connects. If onSession is not nil,
+// Mothership is asked to report the open tabs whenever they change and
+// onSession is called with them. Mothership keeps the bookmarks it
+// receives from the bookmarks channel, which may be nil, in its
+// managed folder.
+func ListenOnExternalUnixSocket(ctx context.Context, listener *net.UnixListener, startURLs []*url.URL, onSession func(tabs []com.SessionTab), bookmarks <-chan []com.Bookmark) {
 	incomingBroadcasts := make(chan interface{})
 	newBroadcastChannels := make(chan broadcastChannelOpenEvent)
 	closedBroadcastChannels := make(chan broadcastChannelCloseEvent)
 	go func() {
 		outgoingBroadcasts := make(map[int]chan interface{})
-		startURL := startURL
+		pendingRequests := make(map[string]int)
+		startURLs := startURLs
+		var currentBookmarks []com.Bookmark
 		for {
 			select {
 			case newBC := <-newBroadcastChannels:
 				outgoingBroadcasts[newBC.connectionID] = newBC.channel
 				newBC.channel <- startURLBroadcast{
-					startURL: startURL,
+					startURLs: startURLs,
+				}
+				if currentBookmarks != nil {
+					newBC.channel <- bookmarksBroadcast{
+						bookmarks: currentBookmarks,
+					}
+				}
+			case newBookmarks := <-bookmarks:
+				currentBookmarks = newBookmarks
+				if currentBookmarks == nil {
+					currentBookmarks = []com.Bookmark{}
+				}
+				for _, bc := range outgoingBroadcasts {
+					bc <- bookmarksBroadcast{
+						bookmarks: currentBookmarks,
+					}
 				}
 			case closedBC := <-closedBroadcastChannels:
 				delete(outgoingBroadcasts, closedBC.connectionID)
+				for id, connectionID := range pendingRequests {
+					if connectionID == closedBC.connectionID {
+						delete(pendingRequests, id)
+					}
+				}
 
 			case broadcast := <-incomingBroadcasts:
-				switch broadcast.(type) {
-				case openedStartURLBroadcast:
-					startURL = nil
+				switch b := broadcast.(type) {
+				case openedTabBroadcast:
+					remainingStartURLs := []*url.URL{}
+					for _, startURL := range startURLs {
+						if startURL.String() != b.URL {
+							remainingStartURLs = append(remainingStartURLs, startURL)
+						}
+					}
+					if len(remainingStartURLs) == len(startURLs) {
+						continue
+					}
+					startURLs = remainingStartURLs
+					broadcast = openedStartURLBroadcast{
+						startURLs: startURLs,
+					}
+				case sessionBroadcast:
+					if onSession != nil {
+						onSession(b.session.Tabs)
+					}
+					continue
+				case requestBroadcast:
+					pendingRequests[b.request.ID] = b.connectionID
+				case responseBroadcast:
+					connectionID, ok := pendingRequests[b.response.ID]
+					delete(pendingRequests, b.response.ID)
+					if bc, ok2 := outgoingBroadcasts[connectionID]; ok && ok2 {
+						bc <- b
+					}
+					continue
 				}
 				for _, bc := range outgoingBroadcasts {
 					bc <- broadcast
@@ -86,7 +221,7 @@ func ListenOnExternalUnixSocket(ctx context.Context, listener *net.UnixListener,
 			if errors.Is(err, net.ErrClosed) {
 				break
 			}
-			fmt.Fprintln(os.Stderr, err)
+			ulog.Errorf("Failed to accept control socket connection: %v", err)
 			continue
 		}
 		outgoingBroadcasts := make(chan interface{})
@@ -96,39 +231,51 @@ func ListenOnExternalUnixSocket(ctx context.Context, listener *net.UnixListener,
 		}
 		go func() {
 			defer func() {
+				// Broadcasts are discarded until the hub knows that
+				// the connection is closed, so that it doesn't block.
+				go func() {
+					for range outgoingBroadcasts {
+					}
+				}()
 				closedBroadcastChannels <- broadcastChannelCloseEvent{
 					connectionID: connectionID,
 				}
 				close(outgoingBroadcasts)
 			}()
 			defer conn.Close()
-			if err := handleConnection(ctx, incomingBroadcasts, outgoingBroadcasts, conn); err != nil {
-				fmt.Fprintln(os.Stderr, err)
+			if err := handleConnection(ctx, connectionID, onSession != nil, incomingBroadcasts, outgoingBroadcasts, conn); err != nil {
+				ulog.Errorf("Control socket connection %d: %v", connectionID, err)
 			}
 		}()
 	}
 }
 
-func handleConnection(ctx context.Context, outgoingBroadcasts, incomingBroadcasts chan interface{}, conn *net.UnixConn) error {
+// handleConnection forwards messages from the connection to the hub
+// and broadcasts from the hub to the connection. Only the goroutine
+// reading from the connection sends to the hub, so that the hub never
+// waits on a connection that is waiting on the hub.
+func handleConnection(ctx context.Context, connectionID int, watchSession bool, outgoingBroadcasts, incomingBroadcasts chan interface{}, conn *net.UnixConn) error {
 	isMothershipConnector := false
-	var startURL *url.URL
+	var startURLs []*url.URL
+	var bookmarks []com.Bookmark
 
 	ctx, cancelProcessing := context.WithCancel(ctx)
-	incomingMsgs := make(chan interface{})
-	receiveErrs := make(chan error)
+	receivedHello := make(chan struct{})
+	receiveErrs := make(chan error, 1)
 
 	sc := bufio.NewScanner(conn)
+	sc.Buffer(nil, com.MaxOutgoingMessageSize)
 	go func() {
+		defer cancelProcessing()
 		for sc.Scan() {
-			var msg interface{}
-			if err := json.Unmarshal(sc.Bytes(), &msg); err != nil {
+			if err := forwardSocketMessage(connectionID, sc.Bytes(), outgoingBroadcasts, receivedHello); err != nil {
 				receiveErrs <- uerror.WithStackTrace(err)
+				return
 			}
-			incomingMsgs <- msg
 		}
-		close(incomingMsgs)
-		close(receiveErrs)
-		cancelProcessing()
+		if err := sc.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+			receiveErrs <- uerror.StackTracef("Failed to receive from control socket: %w", err)
+		}
 	}()
 
 EVENTS:
@@ -143,34 +290,49 @@ EVENTS:
 					}
 				}
 			case openedStartURLBroadcast:
-				startURL = nil
+				startURLs = broadcast.startURLs
+			case requestBroadcast:
+				if isMothershipConnector {
+					if err := sendMessageOverSocket(conn, broadcast.request); err != nil {
+						return uerror.WithStackTrace(err)
+					}
+				}
+			case responseBroadcast:
+				if err := sendMessageOverSocket(conn, broadcast.response); err != nil {
+					return uerror.WithStackTrace(err)
+				}
+			case bookmarksBroadcast:
+				bookmarks = broadcast.bookmarks
+				if isMothershipConnector {
+					if err := sendBookmarksMessage(conn, bookmarks); err != nil {
+						return uerror.WithStackTrace(err)
+					}
+				}
 			case startURLBroadcast:
-				startURL = broadcast.startURL
-				if err := openStartURLIfNecessary(conn, startURL, isMothershipConnector); err != nil {
+				startURLs = broadcast.startURLs
+				if err := openStartURLsIfNecessary(conn, startURLs, isMothershipConnector); err != nil {
 					return uerror.WithStackTrace(err)
 				}
 			}
 
-		case msg := <-incomingMsgs:
-			if msg == "Hello from Mothership! :>" {
-				isMothershipConnector = true
-				if err := openStartURLIfNecessary(conn, startURL, isMothershipConnector); err != nil {
+		case <-receivedHello:
+			isMothershipConnector = true
+			if watchSession {
+				if err := sendMessageOverSocket(conn, com.TBMLMsg{
+					Type: com.TBMLMsgTypeWatchSession,
+				}); err != nil {
 					return uerror.WithStackTrace(err)
 				}
-			} else if msg, ok := msg.(map[string]interface{}); ok {
-				switch msg["type"] {
-				case string(socketMsgTypeOpenTab):
-					url, _ := msg["url"].(string)
-					outgoingBroadcasts <- openTabBroadcast{
-						URL: url,
-					}
-				case string(socketMsgTypeOpenedTab):
-					url, _ := msg["url"].(string)
-					if startURL != nil && url == startURL.String() {
-						outgoingBroadcasts <- openedStartURLBroadcast{}
-					}
+			}
+			if bookmarks != nil {
+				if err := sendBookmarksMessage(conn, bookmarks); err != nil {
+					return uerror.WithStackTrace(err)
 				}
 			}
+			if err := openStartURLsIfNecessary(conn, startURLs, isMothershipConnector); err != nil {
+				return uerror.WithStackTrace(err)
+			}
+
 		case err := <-receiveErrs:
 			return uerror.WithStackTrace(err)
 
@@ -182,8 +344,83 @@ EVENTS:
 	return nil
 }
 
-func openStartURLIfNecessary(conn *net.UnixConn, startURL *url.URL, isMothershipConnector bool) error {
-	if isMothershipConnector && startURL != nil {
+// forwardSocketMessage passes a message received from a connection on
+// to the hub. Requests and responses are forwarded without looking at
+// their commands, so that new commands only need to be implemented in
+// Mothership.
+func forwardSocketMessage(connectionID int, msg []byte, outgoingBroadcasts chan<- interface{}, receivedHello chan<- struct{}) error {
+	var hello string
+	if err := json.Unmarshal(msg, &hello); err == nil && hello == mothershipHello {
+		receivedHello <- struct{}{}
+		return nil
+	}
+
+	if !json.Valid(msg) {
+		return uerror.StackTracef("Received invalid JSON: %s", msg)
+	}
+	var header com.TBMLMsg
+	if err := json.Unmarshal(msg, &header); err != nil {
+		// Messages other than objects have no meaning to tbml
+		return nil
+	}
+	switch header.Type {
+	case com.TBMLMsgTypeOpenTab:
+		var openTab com.OpenTabMsg
+		if err := json.Unmarshal(msg, &openTab); err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		outgoingBroadcasts <- openTabBroadcast{
+			URL: openTab.URL,
+		}
+	case com.TBMLMsgTypeOpenedTab:
+		var openedTab com.OpenTabMsg
+		if err := json.Unmarshal(msg, &openedTab); err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		outgoingBroadcasts <- openedTabBroadcast{
+			URL: openedTab.URL,
+		}
+	case com.TBMLMsgTypeRequest:
+		var request com.Request
+		if err := json.Unmarshal(msg, &request); err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		outgoingBroadcasts <- requestBroadcast{
+			connectionID: connectionID,
+			request:      request,
+		}
+	case com.TBMLMsgTypeSession:
+		var session com.SessionMsg
+		if err := json.Unmarshal(msg, &session); err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		outgoingBroadcasts <- sessionBroadcast{
+			session: session,
+		}
+	case com.TBMLMsgTypeResponse:
+		var response com.Response
+		if err := json.Unmarshal(msg, &response); err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		outgoingBroadcasts <- responseBroadcast{
+			response: response,
+		}
+	}
+	return nil
+}
+
+func sendBookmarksMessage(conn *net.UnixConn, bookmarks []com.Bookmark) error {
+	return sendMessageOverSocket(conn, com.BookmarksMsg{
+		Bookmarks: bookmarks,
+		Type:      com.TBMLMsgTypeBookmarks,
+	})
+}
+
+func openStartURLsIfNecessary(conn *net.UnixConn, startURLs []*url.URL, isMothershipConnector bool) error {
+	if !isMothershipConnector {
+		return nil
+	}
+	for _, startURL := range startURLs {
 		if err := SendOpenTabMessage(conn, startURL.String()); err != nil {
 			return uerror.WithStackTrace(err)
 		}
@@ -208,12 +445,62 @@ func ConnectToExternalUnixSocket(config Configuration, instance ProfileInstance)
 }
 
 func SendOpenTabMessage(conn *net.UnixConn, url string) error {
-	return sendMessageOverSocket(conn, map[string]interface{}{
-		"type": socketMsgTypeOpenTab,
-		"url":  url,
+	return sendMessageOverSocket(conn, com.OpenTabMsg{
+		Type: com.TBMLMsgTypeOpenTab,
+		URL:  url,
 	})
 }
 
+// SendRequest asks Mothership to run the command with the arguments
+// and unmarshals its result into result, which may be nil if the
+// command has no result or it's not needed.
+func SendRequest(conn *net.UnixConn, command Command, args, result interface{}) error {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	request := com.Request{
+		Command: string(command),
+		ID:      hex.EncodeToString(idBytes),
+		Type:    com.TBMLMsgTypeRequest,
+	}
+	if args != nil {
+		argsBytes, err := json.Marshal(args)
+		if err != nil {
+			return uerror.WithStackTrace(err)
+		}
+		request.Args = argsBytes
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(requestTimeout)); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	defer conn.SetReadDeadline(time.Time{})
+	if err := sendMessageOverSocket(conn, request); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+
+	sc := bufio.NewScanner(conn)
+	sc.Buffer(nil, com.MaxOutgoingMessageSize)
+	for sc.Scan() {
+		var response com.Response
+		if err := json.Unmarshal(sc.Bytes(), &response); err != nil || response.Type != com.TBMLMsgTypeResponse || response.ID != request.ID {
+			continue
+		}
+		if response.Error != "" {
+			return uerror.StackTracef("%w: %s: %s", ErrCommandFailed, command, response.Error)
+		}
+		if result == nil || len(response.Result) == 0 {
+			return nil
+		}
+		return uerror.WithStackTrace(json.Unmarshal(response.Result, result))
+	}
+	if err := sc.Err(); err != nil {
+		return uerror.StackTracef("Failed to receive response to %s: %w", command, err)
+	}
+	return uerror.StackTracef("Control socket was closed before Mothership responded to %s", command)
+}
+
 func resolveExternalUnixSocketAddr(instanceDir string) (*net.UnixAddr, error) {
 	addr, err := net.ResolveUnixAddr("unix", filepath.Join(instanceDir, "control-socket"))
 	if err != nil {
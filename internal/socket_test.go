@@ -0,0 +1,269 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"t0ast.cc/tbml/mothership-connector/com"
+)
+
+// largeBrowserVersion is larger than the default buffer of a
+// bufio.Scanner.
+var largeBrowserVersion = strings.Repeat("a", 2*bufio.MaxScanTokenSize)
+
+// runFakeMothership answers requests like Mothership does, with fixed
+// results.
+func runFakeMothership(t *testing.T, conn *net.UnixConn) {
+	sc := bufio.NewScanner(conn)
+	for sc.Scan() {
+		var request com.Request
+		require.NoError(t, json.Unmarshal(sc.Bytes(), &request))
+		if request.Type != com.TBMLMsgTypeRequest {
+			continue
+		}
+
+		response := com.Response{
+			ID:   request.ID,
+			Type: com.TBMLMsgTypeResponse,
+		}
+		switch Command(request.Command) {
+		case CommandPing:
+			response.Result = json.RawMessage(`"pong"`)
+		case CommandListTabs:
+			response.Result = json.RawMessage(`[{"active":true,"id":1,"title":"Example","url":"https://example.com/","windowId":2}]`)
+		case CommandGetVersion:
+			result, err := json.Marshal(Version{Browser: largeBrowserVersion, Extension: "1.0"})
+			require.NoError(t, err)
+			response.Result = result
+		case CommandCloseTab:
+			args := CloseTabArgs{}
+			require.NoError(t, json.Unmarshal(request.Args, &args))
+			if args.TabID != 1 {
+				response.Error = "Invalid tab ID"
+			}
+		default:
+			response.Error = "Unknown command"
+		}
+		require.NoError(t, sendMessageOverSocket(conn, response))
+	}
+}
+
+func TestSendRequest(t *testing.T) {
+	addr, err := resolveExternalUnixSocketAddr(t.TempDir())
+	require.NoError(t, err)
+	listener, err := net.ListenUnix("unix", addr)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startURL, err := url.Parse("https://example.com/")
+	require.NoError(t, err)
+	go ListenOnExternalUnixSocket(ctx, listener, []*url.URL{startURL}, nil, nil)
+
+	mothershipConn, err := net.DialUnix("unix", nil, addr)
+	require.NoError(t, err)
+	defer mothershipConn.Close()
+	require.NoError(t, sendMessageOverSocket(mothershipConn, mothershipHello))
+	// Once Mothership is asked to open the start URL, the hub knows
+	// that it's Mothership.
+	line, _, err := bufio.NewReader(mothershipConn).ReadLine()
+	require.NoError(t, err)
+	openTab := com.OpenTabMsg{}
+	require.NoError(t, json.Unmarshal(line, &openTab))
+	assert.Equal(t, com.TBMLMsgTypeOpenTab, openTab.Type)
+	go runFakeMothership(t, mothershipConn)
+
+	testCases := []struct {
+		desc string
+
+		args        interface{}
+		command     Command
+		expected    interface{}
+		expectedErr error
+		result      interface{}
+	}{
+		{
+			desc: "Ping",
+
+			command:  CommandPing,
+			expected: "pong",
+			result:   new(string),
+		},
+		{
+			desc: "List tabs",
+
+			command: CommandListTabs,
+			expected: []Tab{
+				{
+					Active:   true,
+					ID:       1,
+					Title:    "Example",
+					URL:      "https://example.com/",
+					WindowID: 2,
+				},
+			},
+			result: &[]Tab{},
+		},
+		{
+			desc: "Result larger than the default scanner buffer",
+
+			command:  CommandGetVersion,
+			expected: Version{Browser: largeBrowserVersion, Extension: "1.0"},
+			result:   &Version{},
+		},
+		{
+			desc: "Command without result",
+
+			args:    CloseTabArgs{TabID: 1},
+			command: CommandCloseTab,
+		},
+		{
+			desc: "Failing command",
+
+			args:        CloseTabArgs{TabID: 3},
+			command:     CommandCloseTab,
+			expectedErr: ErrCommandFailed,
+		},
+		{
+			desc: "Unknown command",
+
+			command:     Command("unknown"),
+			expectedErr: ErrCommandFailed,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			conn, err := net.DialUnix("unix", nil, addr)
+			require.NoError(t, err)
+			defer conn.Close()
+
+			err = SendRequest(conn, tC.command, tC.args, tC.result)
+			if tC.expectedErr != nil {
+				assert.ErrorIs(t, err, tC.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			if tC.expected != nil {
+				assert.Equal(t, tC.expected, reflect.ValueOf(tC.result).Elem().Interface())
+			}
+		})
+	}
+}
+
+func TestListenOnExternalUnixSocket(t *testing.T) {
+	addr, err := resolveExternalUnixSocketAddr(t.TempDir())
+	require.NoError(t, err)
+	listener, err := net.ListenUnix("unix", addr)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	startURLs := []*url.URL{}
+	for _, rawURL := range []string{"https://example.com/", "https://example.org/"} {
+		u, err := url.Parse(rawURL)
+		require.NoError(t, err)
+		startURLs = append(startURLs, u)
+	}
+	sessions := make(chan []com.SessionTab, 1)
+	go ListenOnExternalUnixSocket(ctx, listener, startURLs, func(tabs []com.SessionTab) {
+		sessions <- tabs
+	}, nil)
+
+	connectMothership := func() (*net.UnixConn, *bufio.Reader) {
+		conn, err := net.DialUnix("unix", nil, addr)
+		require.NoError(t, err)
+		require.NoError(t, sendMessageOverSocket(conn, mothershipHello))
+		return conn, bufio.NewReader(conn)
+	}
+	receive := func(r *bufio.Reader) com.OpenTabMsg {
+		line, _, err := r.ReadLine()
+		require.NoError(t, err)
+		msg := com.OpenTabMsg{}
+		require.NoError(t, json.Unmarshal(line, &msg))
+		return msg
+	}
+
+	conn, r := connectMothership()
+	assert.Equal(t, com.OpenTabMsg{Type: com.TBMLMsgTypeWatchSession}, receive(r))
+	assert.Equal(t, com.OpenTabMsg{Type: com.TBMLMsgTypeOpenTab, URL: "https://example.com/"}, receive(r))
+	assert.Equal(t, com.OpenTabMsg{Type: com.TBMLMsgTypeOpenTab, URL: "https://example.org/"}, receive(r))
+
+	tabs := []com.SessionTab{
+		{Title: "Example", URL: "https://example.com/"},
+	}
+	require.NoError(t, sendMessageOverSocket(conn, com.SessionMsg{
+		Tabs: tabs,
+		Type: com.TBMLMsgTypeSession,
+	}))
+	assert.Equal(t, tabs, <-sessions)
+
+	// Once a start URL is opened, Mothership isn't asked to open it
+	// again when it reconnects.
+	require.NoError(t, sendMessageOverSocket(conn, com.OpenTabMsg{
+		Type: com.TBMLMsgTypeOpenedTab,
+		URL:  "https://example.com/",
+	}))
+	// The hub handles the connection's messages in order, so the
+	// start URL is marked as opened once this session arrives.
+	require.NoError(t, sendMessageOverSocket(conn, com.SessionMsg{
+		Tabs: tabs,
+		Type: com.TBMLMsgTypeSession,
+	}))
+	<-sessions
+	require.NoError(t, conn.Close())
+
+	conn, r = connectMothership()
+	defer conn.Close()
+	assert.Equal(t, com.OpenTabMsg{Type: com.TBMLMsgTypeWatchSession}, receive(r))
+	assert.Equal(t, com.OpenTabMsg{Type: com.TBMLMsgTypeOpenTab, URL: "https://example.org/"}, receive(r))
+}
+
+func TestListenOnExternalUnixSocketBookmarks(t *testing.T) {
+	addr, err := resolveExternalUnixSocketAddr(t.TempDir())
+	require.NoError(t, err)
+	listener, err := net.ListenUnix("unix", addr)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bookmarks := make(chan []com.Bookmark)
+	go ListenOnExternalUnixSocket(ctx, listener, nil, nil, bookmarks)
+
+	initial := []com.Bookmark{
+		{Title: "Example", URL: "https://example.com/"},
+	}
+	bookmarks <- initial
+
+	conn, err := net.DialUnix("unix", nil, addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, sendMessageOverSocket(conn, mothershipHello))
+	r := bufio.NewReader(conn)
+	receive := func() com.BookmarksMsg {
+		line, _, err := r.ReadLine()
+		require.NoError(t, err)
+		msg := com.BookmarksMsg{}
+		require.NoError(t, json.Unmarshal(line, &msg))
+		return msg
+	}
+	assert.Equal(t, com.BookmarksMsg{Bookmarks: initial, Type: com.TBMLMsgTypeBookmarks}, receive())
+
+	// Bookmarks are sent again when the bookmarks file changes
+	changed := []com.Bookmark{
+		{Title: "Example", URL: "https://example.org/"},
+	}
+	bookmarks <- changed
+	assert.Equal(t, com.BookmarksMsg{Bookmarks: changed, Type: com.TBMLMsgTypeBookmarks}, receive())
+}
@@ -0,0 +1,472 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	uerror "t0ast.cc/tbml/util/error"
+	uio "t0ast.cc/tbml/util/io"
+	ulog "t0ast.cc/tbml/util/log"
+)
+
+const (
+	ChannelAlpha   = "alpha"
+	ChannelRelease = "release"
+)
+
+const defaultTorBrowserMirror = "https://dist.torproject.org/"
+
+// defaultTorBrowserDownloadProxy matches torbrowser-launcher's default
+// of downloading over the system's Tor.
+const defaultTorBrowserDownloadProxy = "socks5://127.0.0.1:9050"
+
+// torBrowserDownloadsURL is where the current version of a channel
+// is looked up. %s is the channel.
+const torBrowserDownloadsURL = "https://aus1.torproject.org/torbrowser/update_3/%s/downloads.json"
+
+// torBrowserLatestMaxAge is how long the looked up current version
+// of a channel is used before it's looked up again.
+const torBrowserLatestMaxAge = 24 * time.Hour
+
+// torBrowserDownloadOSes are the names of the architectures in Tor
+// Browser's downloads before version 13.0, by the names that
+// torbrowser-launcher uses for them. Since 13.0, they are named
+// "linux-" and the architecture.
+var torBrowserDownloadOSes = map[string]string{
+	"i686":   "linux32",
+	"x86_64": "linux64",
+}
+
+// torBrowserAllLocalesVersion is the first major version of Tor
+// Browser whose archives contain all locales. They are listed under
+// torBrowserAllLocales in the downloads.
+const torBrowserAllLocalesVersion = 13
+
+const torBrowserAllLocales = "ALL"
+
+// torBrowserVersionPattern matches the Tor Browser versions that can be
+// installed, like "12.5.1" or "13.0a5". The version is used in paths
+// and URLs, so nothing else is allowed.
+var torBrowserVersionPattern = regexp.MustCompile(`^\d+(\.\d+)*([ab]\d+)?$`)
+
+var ErrBundleSignature error = errors.New("Invalid signature of Tor Browser bundle")
+
+// torBrowserSigningKeyFingerprint is the fingerprint of the primary
+// key of the Tor Browser Developers signing key.
+var torBrowserSigningKeyFingerprint = "EF6E286DDA85EA2A4BA7DE684E2C6E8793298290"
+
+// torBrowserSigningKeyFiles are the files that the Tor Browser
+// Developers signing key is imported from into the gnupg homedir if
+// it isn't there already. This is where torbrowser-launcher installs
+// it.
+var torBrowserSigningKeyFiles = []string{
+	"/usr/share/torbrowser-launcher/tor-browser-developers.asc",
+}
+
+// torBrowserDownloads is the content of a channel's downloads.json.
+type torBrowserDownloads struct {
+	Downloads map[string]map[string]struct {
+		Binary string
+		Sig    string
+	}
+	Version string
+}
+
+// torBrowserLatest caches the current version of a channel in the
+// bundle store.
+type torBrowserLatest struct {
+	Checked time.Time
+	Version string
+}
+
+// getTorBrowserChannel returns the configured channel, defaulting to
+// the release channel.
+func getTorBrowserChannel(tbConfig TorBrowserConfiguration) (string, error) {
+	switch tbConfig.Channel {
+	case "", ChannelRelease:
+		return ChannelRelease, nil
+	case ChannelAlpha:
+		return ChannelAlpha, nil
+	default:
+		return "", uerror.StackTracef("Unknown Tor Browser channel %q (expected \"%s\" or \"%s\")", tbConfig.Channel, ChannelRelease, ChannelAlpha)
+	}
+}
+
+// torBrowserDownload is how the archive of a Tor Browser version is
+// published.
+type torBrowserDownload struct {
+	// ArchiveName is the file name of the archive on the mirror.
+	ArchiveName string
+	// Locale is the locale of the archive in the downloads, or
+	// torBrowserAllLocales.
+	Locale string
+	// OS is the name of the architecture in the downloads.
+	OS string
+}
+
+// getTorBrowserDownload returns how the archive of the version in the
+// locale is published for the machine's architecture. Before 13.0,
+// there is an archive per locale.
+func getTorBrowserDownload(version, locale string) (torBrowserDownload, error) {
+	arch := getLauncherArchitecture()
+	legacyOS, ok := torBrowserDownloadOSes[arch]
+	if !ok {
+		return torBrowserDownload{}, uerror.StackTracef("Tor Browser is not available for %s", arch)
+	}
+
+	if getTorBrowserMajorVersion(version) < torBrowserAllLocalesVersion {
+		return torBrowserDownload{
+			ArchiveName: fmt.Sprintf("tor-browser-%s-%s_%s.tar.xz", legacyOS, version, locale),
+			Locale:      locale,
+			OS:          legacyOS,
+		}, nil
+	}
+	downloadOS := "linux-" + arch
+	return torBrowserDownload{
+		ArchiveName: fmt.Sprintf("tor-browser-%s-%s.tar.xz", downloadOS, version),
+		Locale:      torBrowserAllLocales,
+		OS:          downloadOS,
+	}, nil
+}
+
+// getTorBrowserMajorVersion returns the major version of a version
+// that matches torBrowserVersionPattern.
+func getTorBrowserMajorVersion(version string) int {
+	end := strings.IndexFunc(version, func(r rune) bool {
+		return r < '0' || r > '9'
+	})
+	if end == -1 {
+		end = len(version)
+	}
+	major, _ := strconv.Atoi(version[:end])
+	return major
+}
+
+// checkTorBrowserVersion returns an error if the version isn't a valid
+// Tor Browser version.
+func checkTorBrowserVersion(version string) error {
+	if !torBrowserVersionPattern.MatchString(version) {
+		return uerror.StackTracef("Invalid Tor Browser version %q (expected a version like \"12.5.1\" or \"13.0a5\")", version)
+	}
+	return nil
+}
+
+// getTorBrowserBundleURLs returns the URLs of the bundle archive of
+// the version in the locale and its signature on the mirror.
+func getTorBrowserBundleURLs(tbConfig TorBrowserConfiguration, version, locale string) (archiveURL, sigURL string, err error) {
+	mirror := defaultTorBrowserMirror
+	if tbConfig.Mirror != nil {
+		mirror = *tbConfig.Mirror
+	}
+	base, err := url.Parse(strings.TrimSuffix(mirror, "/") + "/")
+	if err != nil {
+		return "", "", uerror.StackTracef("Invalid Tor Browser mirror %s: %w", mirror, err)
+	}
+	download, err := getTorBrowserDownload(version, locale)
+	if err != nil {
+		return "", "", uerror.WithStackTrace(err)
+	}
+	archive, err := base.Parse(fmt.Sprintf("torbrowser/%s/%s", url.PathEscape(version), download.ArchiveName))
+	if err != nil {
+		return "", "", uerror.WithStackTrace(err)
+	}
+	return archive.String(), archive.String() + ".asc", nil
+}
+
+// openTorBrowserURL opens an http(s) or file URL for reading.
+func openTorBrowserURL(ctx context.Context, tbConfig TorBrowserConfiguration, rawURL string) (io.ReadCloser, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+
+	if u.Scheme == "file" {
+		f, err := os.Open(u.Path)
+		return f, uerror.WithStackTrace(err)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	proxy := defaultTorBrowserDownloadProxy
+	if tbConfig.DownloadProxy != nil {
+		proxy = *tbConfig.DownloadProxy
+	}
+	if proxy != "" {
+		proxyURL, err := url.Parse(proxy)
+		if err != nil {
+			return nil, uerror.StackTracef("Invalid download proxy %s: %w", proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, uerror.StackTracef("Failed to download %s: %s", rawURL, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func downloadTorBrowserFile(ctx context.Context, tbConfig TorBrowserConfiguration, rawURL, dst string) error {
+	src, err := openTorBrowserURL(ctx, tbConfig, rawURL)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	defer src.Close()
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	defer dstFile.Close()
+	if _, err := io.Copy(dstFile, src); err != nil {
+		return uerror.StackTracef("Failed to download %s: %w", rawURL, err)
+	}
+	return uerror.WithStackTrace(dstFile.Close())
+}
+
+// getTorBrowserVersion returns the version to install: the pinned
+// version if there is one, otherwise the channel's current version.
+// The current version is looked up at most once per
+// torBrowserLatestMaxAge. If the lookup fails, the last known version
+// is used.
+func getTorBrowserVersion(ctx context.Context, config Configuration, locale string) (string, error) {
+	tbConfig := *config.TorBrowser
+	if tbConfig.Version != nil {
+		if err := checkTorBrowserVersion(*tbConfig.Version); err != nil {
+			return "", uerror.WithStackTrace(err)
+		}
+		return *tbConfig.Version, nil
+	}
+
+	channel, err := getTorBrowserChannel(tbConfig)
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+
+	latestPath := filepath.Join(*config.BundlePath, fmt.Sprintf(".latest-%s.json", channel))
+	latest := torBrowserLatest{}
+	if latestBytes, err := os.ReadFile(latestPath); err == nil {
+		_ = json.Unmarshal(latestBytes, &latest)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return "", uerror.WithStackTrace(err)
+	}
+	if latest.Version != "" && time.Since(latest.Checked) < torBrowserLatestMaxAge {
+		return latest.Version, nil
+	}
+
+	version, err := fetchTorBrowserVersion(ctx, tbConfig, channel, locale)
+	if err != nil {
+		if latest.Version == "" {
+			return "", uerror.StackTracef("Failed to look up the current Tor Browser version (pin a version for offline installs): %w", err)
+		}
+		ulog.Warnf("Failed to look up the current Tor Browser version, using %s: %v", latest.Version, err)
+		return latest.Version, nil
+	}
+
+	latestBytes, err := json.Marshal(torBrowserLatest{
+		Checked: time.Now(),
+		Version: version,
+	})
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	if err := os.WriteFile(latestPath, latestBytes, uio.FileModeURWGRWO); err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	return version, nil
+}
+
+func fetchTorBrowserVersion(ctx context.Context, tbConfig TorBrowserConfiguration, channel, locale string) (string, error) {
+	body, err := openTorBrowserURL(ctx, tbConfig, fmt.Sprintf(torBrowserDownloadsURL, channel))
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	defer body.Close()
+	return parseTorBrowserDownloads(body, locale)
+}
+
+func parseTorBrowserDownloads(r io.Reader, locale string) (string, error) {
+	downloads := torBrowserDownloads{}
+	if err := json.NewDecoder(r).Decode(&downloads); err != nil {
+		return "", uerror.StackTracef("Failed to parse Tor Browser downloads: %w", err)
+	}
+	if downloads.Version == "" {
+		return "", uerror.StackTracef("Tor Browser downloads don't contain a version")
+	}
+	if err := checkTorBrowserVersion(downloads.Version); err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	download, err := getTorBrowserDownload(downloads.Version, locale)
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	if _, ok := downloads.Downloads[download.OS][download.Locale]; !ok {
+		return "", uerror.StackTracef("Tor Browser %s is not available for %s in %s", downloads.Version, download.OS, download.Locale)
+	}
+	return downloads.Version, nil
+}
+
+// verifyTorBrowserSignature checks that the signature is a valid
+// signature of the file by the Tor Browser Developers signing key,
+// using the keyring in the gnupg homedir that torbrowser-launcher uses.
+func verifyTorBrowserSignature(gnupgHomedir, file, sig string) error {
+	if err := os.MkdirAll(gnupgHomedir, uio.FileModeURWXGO); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	for _, keyFile := range torBrowserSigningKeyFiles {
+		if exists, _ := uio.FileExists(keyFile); !exists {
+			continue
+		}
+		importCmd := exec.Command("gpg", "--batch", "--quiet", "--homedir", gnupgHomedir, "--import", keyFile)
+		if output, err := importCmd.CombinedOutput(); err != nil {
+			return uerror.StackTracef("Failed to import %s: %w\n%s", keyFile, err, output)
+		}
+	}
+
+	status := bytes.Buffer{}
+	verifyCmd := exec.Command("gpg", "--batch", "--homedir", gnupgHomedir, "--status-fd", "1", "--verify", sig, file)
+	verifyCmd.Stdout = &status
+	verifyErr := verifyCmd.Run()
+
+	scanner := bufio.NewScanner(&status)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[0] != "[GNUPG:]" || fields[1] != "VALIDSIG" {
+			continue
+		}
+		// The last field is the fingerprint of the primary key.
+		if verifyErr == nil && strings.EqualFold(fields[len(fields)-1], torBrowserSigningKeyFingerprint) {
+			return nil
+		}
+	}
+	if verifyErr != nil {
+		return uerror.StackTracef("%w: %s: %v", ErrBundleSignature, file, verifyErr)
+	}
+	return uerror.StackTracef("%w: %s is not signed by the Tor Browser Developers signing key", ErrBundleSignature, file)
+}
+
+// EnsureTorBrowserBundle makes sure the configured Tor Browser version
+// is installed in the locale in the bundle store and returns its name
+// there. New
+// versions are downloaded, verified and extracted into a staging
+// directory first and only moved into the store once complete, so
+// instances using other versions aren't affected.
+func EnsureTorBrowserBundle(ctx context.Context, config Configuration, locale string) (string, error) {
+	if config.TorBrowser == nil || config.BundlePath == nil {
+		return "", uerror.StackTracef("Managing the Tor Browser bundle requires TorBrowser and BundlePath to be configured")
+	}
+	tbConfig := *config.TorBrowser
+
+	channel, err := getTorBrowserChannel(tbConfig)
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	if err := os.MkdirAll(*config.BundlePath, uio.FileModeURWXGRWXO); err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	version, err := getTorBrowserVersion(ctx, config, locale)
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	download, err := getTorBrowserDownload(version, locale)
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+
+	// Bundles with all locales are shared by instances of all
+	// locales.
+	name := getSharedBundleName(tbbVersion{
+		Architecture: getBundleArchitecture(),
+		Channel:      channel,
+		Locale:       download.Locale,
+		Version:      version,
+	})
+	bundleDir := filepath.Join(*config.BundlePath, name)
+	exists, err := uio.DirExists(bundleDir)
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	if exists {
+		return name, nil
+	}
+
+	ulog.Infof("Installing Tor Browser %s", version)
+
+	archiveURL, sigURL, err := getTorBrowserBundleURLs(tbConfig, version, locale)
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+
+	stagingDir, err := os.MkdirTemp(*config.BundlePath, ".staging-")
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	defer os.RemoveAll(stagingDir)
+
+	archivePath := filepath.Join(stagingDir, "bundle.tar.xz")
+	sigPath := archivePath + ".asc"
+	if err := downloadTorBrowserFile(ctx, tbConfig, archiveURL, archivePath); err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	if err := downloadTorBrowserFile(ctx, tbConfig, sigURL, sigPath); err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+
+	gnupgHomedir, err := getGnupgHomedir()
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	if err := verifyTorBrowserSignature(gnupgHomedir, archivePath, sigPath); err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+
+	extractDir := filepath.Join(stagingDir, "extracted")
+	if err := os.Mkdir(extractDir, uio.FileModeURWXGRWXO); err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	tarCmd := exec.CommandContext(ctx, "tar", "-xJf", archivePath, "-C", extractDir)
+	if output, err := tarCmd.CombinedOutput(); err != nil {
+		return "", uerror.StackTracef("Failed to extract Tor Browser %s: %w\n%s", version, err, output)
+	}
+
+	// The archive contains a single directory (like "tor-browser",
+	// or "tor-browser_en-US" before 13.0) with the bundle.
+	entries, err := os.ReadDir(extractDir)
+	if err != nil {
+		return "", uerror.WithStackTrace(err)
+	}
+	if len(entries) != 1 || !entries[0].IsDir() {
+		return "", uerror.StackTracef("Unexpected layout of Tor Browser %s archive", version)
+	}
+
+	if err := os.Rename(filepath.Join(extractDir, entries[0].Name()), bundleDir); err != nil {
+		// Another tbml process might have installed the same
+		// version in the meantime.
+		if exists, _ := uio.DirExists(bundleDir); exists {
+			return name, nil
+		}
+		return "", uerror.WithStackTrace(err)
+	}
+	return name, nil
+}
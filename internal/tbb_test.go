@@ -0,0 +1,311 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	uio "t0ast.cc/tbml/util/io"
+)
+
+func TestGetTorBrowserBundleURLs(t *testing.T) {
+	httpMirror := "https://mirror.example/tor"
+	fileMirror := "file:///srv/mirror/"
+
+	testCases := []struct {
+		desc string
+
+		mirror          *string
+		version         string
+		expectedArchive string
+	}{
+		{
+			desc: "Default mirror",
+
+			expectedArchive: "https://dist.torproject.org/torbrowser/11.0.4/tor-browser-linux64-11.0.4_en-US.tar.xz",
+		},
+		{
+			desc: "HTTP mirror",
+
+			mirror:          &httpMirror,
+			expectedArchive: "https://mirror.example/tor/torbrowser/11.0.4/tor-browser-linux64-11.0.4_en-US.tar.xz",
+		},
+		{
+			desc: "File mirror",
+
+			mirror:          &fileMirror,
+			expectedArchive: "file:///srv/mirror/torbrowser/11.0.4/tor-browser-linux64-11.0.4_en-US.tar.xz",
+		},
+		{
+			desc: "Archive with all locales",
+
+			version:         "13.0.1",
+			expectedArchive: "https://dist.torproject.org/torbrowser/13.0.1/tor-browser-linux-x86_64-13.0.1.tar.xz",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			version := tC.version
+			if version == "" {
+				version = "11.0.4"
+			}
+			archiveURL, sigURL, err := getTorBrowserBundleURLs(TorBrowserConfiguration{Mirror: tC.mirror}, version, defaultLauncherLocale)
+			assert.NoError(t, err)
+			assert.Equal(t, tC.expectedArchive, archiveURL)
+			assert.Equal(t, tC.expectedArchive+".asc", sigURL)
+		})
+	}
+}
+
+func TestParseTorBrowserDownloads(t *testing.T) {
+	f, err := os.Open("testdata/tbb/downloads.json")
+	require.NoError(t, err)
+	defer f.Close()
+
+	version, err := parseTorBrowserDownloads(f, defaultLauncherLocale)
+	assert.NoError(t, err)
+	assert.Equal(t, "11.0.4", version)
+
+	f13, err := os.Open("testdata/tbb/downloads-13.json")
+	require.NoError(t, err)
+	defer f13.Close()
+
+	version, err = parseTorBrowserDownloads(f13, "de")
+	assert.NoError(t, err)
+	assert.Equal(t, "13.0.1", version)
+
+	_, err = parseTorBrowserDownloads(strings.NewReader(`{"version":"11.0.4","downloads":{}}`), defaultLauncherLocale)
+	assert.EqualError(t, err, "Tor Browser 11.0.4 is not available for linux64 in en-US")
+
+	_, err = parseTorBrowserDownloads(strings.NewReader(`{"version":"13.0.1","downloads":{"linux64":{"en-US":{}}}}`), defaultLauncherLocale)
+	assert.EqualError(t, err, "Tor Browser 13.0.1 is not available for linux-x86_64 in ALL")
+
+	_, err = parseTorBrowserDownloads(strings.NewReader(`{"version":"../11.0.4","downloads":{}}`), defaultLauncherLocale)
+	assert.EqualError(t, err, "Invalid Tor Browser version \"../11.0.4\" (expected a version like \"12.5.1\" or \"13.0a5\")")
+}
+
+func TestCheckTorBrowserVersion(t *testing.T) {
+	testCases := []struct {
+		desc string
+
+		version string
+		valid   bool
+	}{
+		{
+			desc: "Release",
+
+			version: "12.5.1",
+			valid:   true,
+		},
+		{
+			desc: "Alpha",
+
+			version: "13.0a5",
+			valid:   true,
+		},
+		{
+			desc: "Major version only",
+
+			version: "13",
+			valid:   true,
+		},
+		{
+			desc: "Empty",
+
+			version: "",
+		},
+		{
+			desc: "Path",
+
+			version: "../12.5.1",
+		},
+		{
+			desc: "Trailing dot",
+
+			version: "12.5.",
+		},
+		{
+			desc: "Query",
+
+			version: "12.5?x=1",
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			err := checkTorBrowserVersion(tC.version)
+			if tC.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func runGPG(t *testing.T, homedir string, args ...string) string {
+	cmd := exec.Command("gpg", append([]string{"--batch", "--homedir", homedir, "--passphrase", "", "--pinentry-mode", "loopback"}, args...)...)
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+	return string(output)
+}
+
+// setUpTorBrowserMirror creates a file mirror with a bundle of the
+// given version, signed by a newly generated key. The key is used as
+// the Tor Browser signing key for the duration of the test.
+func setUpTorBrowserMirror(t *testing.T, version string) (mirror string) {
+	mirrorDir := t.TempDir()
+	keyHomedir := t.TempDir()
+	t.Cleanup(func() {
+		_ = exec.Command("gpgconf", "--homedir", keyHomedir, "--kill", "gpg-agent").Run()
+	})
+
+	runGPG(t, keyHomedir, "--quick-gen-key", "Test Signing Key <test@example.com>", "default", "default", "never")
+	colons := runGPG(t, keyHomedir, "--with-colons", "--list-keys")
+	fingerprint := ""
+	for _, line := range strings.Split(colons, "\n") {
+		if fields := strings.Split(line, ":"); fields[0] == "fpr" {
+			fingerprint = fields[9]
+			break
+		}
+	}
+	require.NotEmpty(t, fingerprint)
+	keyFile := filepath.Join(keyHomedir, "key.asc")
+	runGPG(t, keyHomedir, "--armor", "--output", keyFile, "--export", fingerprint)
+
+	origFingerprint, origKeyFiles := torBrowserSigningKeyFingerprint, torBrowserSigningKeyFiles
+	torBrowserSigningKeyFingerprint, torBrowserSigningKeyFiles = fingerprint, []string{keyFile}
+	t.Cleanup(func() {
+		torBrowserSigningKeyFingerprint, torBrowserSigningKeyFiles = origFingerprint, origKeyFiles
+	})
+
+	download, err := getTorBrowserDownload(version, defaultLauncherLocale)
+	require.NoError(t, err)
+	// Archives with all locales contain an unlocalized directory.
+	bundleDirName := "tor-browser_" + download.Locale
+	if download.Locale == torBrowserAllLocales {
+		bundleDirName = "tor-browser"
+	}
+	bundleSrc := filepath.Join(t.TempDir(), bundleDirName)
+	for path, content := range map[string]string{
+		"Browser/firefox": "binary",
+		"Browser/TorBrowser/Data/Tor/torrc-defaults": "defaults",
+	} {
+		fullPath := filepath.Join(bundleSrc, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(fullPath), uio.FileModeURWXGRWXO))
+		require.NoError(t, os.WriteFile(fullPath, []byte(content), uio.FileModeURWGRWO))
+	}
+
+	versionDir := filepath.Join(mirrorDir, "torbrowser", version)
+	require.NoError(t, os.MkdirAll(versionDir, uio.FileModeURWXGRWXO))
+	archivePath := filepath.Join(versionDir, download.ArchiveName)
+	tarCmd := exec.Command("tar", "-cJf", archivePath, "-C", filepath.Dir(bundleSrc), bundleDirName)
+	output, err := tarCmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+	runGPG(t, keyHomedir, "--armor", "--local-user", fingerprint, "--output", archivePath+".asc", "--detach-sign", archivePath)
+
+	return "file://" + mirrorDir
+}
+
+func TestEnsureTorBrowserBundle(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+
+	origHome := os.Getenv("HOME")
+	os.Setenv("HOME", t.TempDir())
+	defer os.Setenv("HOME", origHome)
+
+	mirror := setUpTorBrowserMirror(t, "11.0.4")
+	bundlePath := t.TempDir()
+	version := "11.0.4"
+	config := Configuration{
+		BundlePath: &bundlePath,
+		TorBrowser: &TorBrowserConfiguration{
+			Mirror:  &mirror,
+			Version: &version,
+		},
+	}
+
+	name, err := EnsureTorBrowserBundle(context.Background(), config, defaultLauncherLocale)
+	require.NoError(t, err)
+	assert.Equal(t, "11.0.4-linux-x86_64-en-US", name)
+	assert.FileExists(t, filepath.Join(bundlePath, name, "Browser/firefox"))
+	assert.FileExists(t, filepath.Join(bundlePath, name, bundleDataPath, "Tor/torrc-defaults"))
+
+	entries, err := os.ReadDir(bundlePath)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "staging directory was not removed")
+
+	t.Run("Already installed", func(t *testing.T) {
+		// Removing the mirror makes sure nothing is downloaded.
+		missingMirror := "file:///nonexistent"
+		config.TorBrowser.Mirror = &missingMirror
+		defer func() {
+			config.TorBrowser.Mirror = &mirror
+		}()
+
+		name, err := EnsureTorBrowserBundle(context.Background(), config, defaultLauncherLocale)
+		assert.NoError(t, err)
+		assert.Equal(t, "11.0.4-linux-x86_64-en-US", name)
+	})
+
+	t.Run("Use in instance", func(t *testing.T) {
+		instanceDir := t.TempDir()
+		require.NoError(t, ensureExists(filepath.Join(instanceDir, tblSettingsPath), tblDefaultSettings))
+
+		require.NoError(t, useManagedBundle(instanceDir, name, testRelativeBundlePath))
+
+		binds, err := setUpSharedBundle(config, instanceDir, testRelativeBundlePath, defaultLauncherLocale)
+		assert.NoError(t, err)
+
+		settings, err := os.ReadFile(filepath.Join(instanceDir, tblSettingsPath))
+		assert.NoError(t, err)
+		assert.Contains(t, string(settings), `"installed":true`)
+		require.Len(t, binds, 1+len(bundleInstancePaths))
+		assert.Equal(t, sandboxBind{
+			Dst:      testRelativeBundlePath,
+			ReadOnly: true,
+			Src:      filepath.Join(bundlePath, name),
+		}, binds[0])
+		assert.Equal(t, sandboxBind{
+			Dst: filepath.Join(testRelativeBundlePath, bundleDataPath, "Tor"),
+			Src: filepath.Join(instanceDir, testRelativeBundlePath, bundleDataPath, "Tor"),
+		}, binds[3])
+		assert.FileExists(t, filepath.Join(instanceDir, testRelativeBundlePath, bundleDataPath, "Tor/torrc-defaults"))
+	})
+
+	t.Run("Archive with all locales", func(t *testing.T) {
+		allLocalesVersion := "13.0.1"
+		allLocalesMirror := setUpTorBrowserMirror(t, allLocalesVersion)
+		config.TorBrowser.Mirror = &allLocalesMirror
+		config.TorBrowser.Version = &allLocalesVersion
+
+		name, err := EnsureTorBrowserBundle(context.Background(), config, "de")
+		require.NoError(t, err)
+		assert.Equal(t, "13.0.1-"+getBundleArchitecture()+"-ALL", name)
+		assert.FileExists(t, filepath.Join(bundlePath, name, "Browser/firefox"))
+
+		sameName, err := EnsureTorBrowserBundle(context.Background(), config, defaultLauncherLocale)
+		require.NoError(t, err)
+		assert.Equal(t, name, sameName, "The bundle with all locales must be shared by all locales")
+	})
+
+	t.Run("Bad signature", func(t *testing.T) {
+		otherVersion := "11.0.5"
+		otherMirror := setUpTorBrowserMirror(t, otherVersion)
+		// Sign with a key that isn't the signing key.
+		torBrowserSigningKeyFingerprint = strings.Repeat("0", 40)
+
+		config.TorBrowser.Mirror = &otherMirror
+		config.TorBrowser.Version = &otherVersion
+		_, err := EnsureTorBrowserBundle(context.Background(), config, defaultLauncherLocale)
+		assert.ErrorIs(t, err, ErrBundleSignature)
+		assert.NoDirExists(t, filepath.Join(bundlePath, "11.0.5-linux-x86_64-en-US"))
+	})
+}
@@ -0,0 +1,2 @@
+include torbrowser-launcher.local
+blacklist ${HOME}/.local/share/torbrowser
@@ -0,0 +1,2 @@
+include torbrowser-launcher.local
+private-tmp
@@ -29,6 +29,9 @@ mkdir ${HOME}/.local/share/torbrowser
 whitelist ${DOWNLOADS}
 whitelist ${HOME}/.config/torbrowser
 whitelist ${HOME}/.local/share/torbrowser
+# tbml's control socket and Mothership connector
+whitelist ${HOME}/control-socket
+whitelist ${HOME}/mothership-connector
 include whitelist-common.inc
 include whitelist-var-common.inc
 
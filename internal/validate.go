@@ -0,0 +1,180 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	uio "t0ast.cc/tbml/util/io"
+)
+
+// ValidateConfiguration checks the configuration for problems that
+// would only show up when an instance is started, like missing files
+// or routing rules that refer to nonexistent profiles. It returns all
+// problems found.
+func ValidateConfiguration(config Configuration, configDir string) []error {
+	problems := []error{}
+
+	if config.BundlePath != nil {
+		rel, err := filepath.Rel(config.ProfilePath, *config.BundlePath)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			problems = append(problems, fmt.Errorf("Bundle path %s must not be inside of profile path %s", *config.BundlePath, config.ProfilePath))
+		}
+	}
+
+	if config.ExtensionCachePath != nil {
+		rel, err := filepath.Rel(config.ProfilePath, *config.ExtensionCachePath)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, "../") {
+			problems = append(problems, fmt.Errorf("Extension cache path %s must not be inside of profile path %s", *config.ExtensionCachePath, config.ProfilePath))
+		}
+	}
+
+	if config.TorBrowser != nil {
+		if config.BundlePath == nil {
+			problems = append(problems, fmt.Errorf("TorBrowser requires a BundlePath to install Tor Browser into"))
+		}
+		if _, err := getTorBrowserChannel(*config.TorBrowser); err != nil {
+			problems = append(problems, err)
+		}
+		if config.TorBrowser.Version != nil {
+			if err := checkTorBrowserVersion(*config.TorBrowser.Version); err != nil {
+				problems = append(problems, err)
+			}
+		}
+		if _, err := getTorBrowserDownload("0", defaultLauncherLocale); err != nil {
+			problems = append(problems, err)
+		} else if config.TorBrowser.Mirror != nil {
+			if _, _, err := getTorBrowserBundleURLs(*config.TorBrowser, "0", defaultLauncherLocale); err != nil {
+				problems = append(problems, err)
+			}
+		}
+	}
+
+	profileLabels := make(map[string]bool)
+	for i, profile := range config.Profiles {
+		if profile.Label == "" {
+			problems = append(problems, fmt.Errorf("Profile %d has no label", i+1))
+			continue
+		}
+		if profileLabels[profile.Label] {
+			problems = append(problems, fmt.Errorf("Profile label %s is used more than once", profile.Label))
+		}
+		profileLabels[profile.Label] = true
+
+		if _, err := getSandbox(profile); err != nil {
+			problems = append(problems, err)
+		}
+
+		// Where the bundle ends up is only known once an instance
+		// exists, so the path that torbrowser-launcher installs it to
+		// is checked.
+		defaultRelativeBundlePath := getRelativeBundlePath(getLauncherArchitecture(), getLauncherLocale(profile.LauncherSettings))
+
+		checkFile := func(what, path string) bool {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(configDir, path)
+			}
+			exists, err := uio.FileExists(path)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("Profile %s: Failed to check %s %s: %w", profile.Label, what, path, err))
+			} else if !exists {
+				problems = append(problems, fmt.Errorf("Profile %s: %s %s does not exist", profile.Label, what, path))
+			}
+			return err == nil && exists
+		}
+		if profile.UserChromeFile != nil {
+			checkFile("userChrome.css file", *profile.UserChromeFile)
+		}
+		if profile.UserJSFile != nil {
+			checkFile("user.js file", *profile.UserJSFile)
+		}
+		if profile.BookmarksFile != nil && checkFile("bookmarks file", *profile.BookmarksFile) {
+			if _, err := readBookmarksFile(getBookmarksFilePath(profile, configDir)); err != nil {
+				problems = append(problems, fmt.Errorf("Profile %s: %w", profile.Label, err))
+			}
+		}
+		extensionSources := make(map[string]string)
+		checkExtensionID := func(id, source string) {
+			if otherSource, ok := extensionSources[id]; ok {
+				problems = append(problems, fmt.Errorf("Profile %s: %s and %s are both extension %s", profile.Label, otherSource, source, id))
+			}
+			extensionSources[id] = source
+		}
+		for _, extensionFile := range profile.ExtensionFiles {
+			if !checkFile("extension file", extensionFile) {
+				continue
+			}
+			extensionPath := extensionFile
+			if !filepath.IsAbs(extensionPath) {
+				extensionPath = filepath.Join(configDir, extensionPath)
+			}
+			extension, err := readExtension(extensionPath)
+			if err != nil {
+				problems = append(problems, fmt.Errorf("Profile %s: %w", profile.Label, err))
+				continue
+			}
+			checkExtensionID(extension.ID, "extension file "+extensionFile)
+		}
+		if len(profile.Extensions) > 0 {
+			if cachePath, err := GetExtensionCachePath(config); err != nil {
+				problems = append(problems, err)
+			} else {
+				for _, reference := range profile.Extensions {
+					extension, err := resolveCachedExtension(cachePath, reference)
+					if err != nil {
+						problems = append(problems, fmt.Errorf("Profile %s: %w", profile.Label, err))
+						continue
+					}
+					checkExtensionID(extension.ID, "cached extension "+extension.SHA256)
+				}
+			}
+		}
+		switch profile.Downloads {
+		case "", DownloadsInstance, DownloadsShared, DownloadsTopic:
+		default:
+			problems = append(problems, fmt.Errorf("Profile %s: Unknown downloads policy %q (expected \"%s\", \"%s\" or \"%s\")", profile.Label, profile.Downloads, DownloadsInstance, DownloadsShared, DownloadsTopic))
+		}
+		if profile.DownloadsRoot != nil && profile.Downloads != DownloadsTopic {
+			problems = append(problems, fmt.Errorf("Profile %s: DownloadsRoot has no effect unless Downloads is \"%s\"", profile.Label, DownloadsTopic))
+		}
+		if _, err := renderPolicies(profile, "/", defaultRelativeBundlePath, false); err != nil {
+			problems = append(problems, err)
+		}
+		if _, _, err := getNetworkIsolation(profile); err != nil {
+			problems = append(problems, err)
+		}
+		if profile.FirejailProfileFile != nil {
+			checkFile("firejail profile file", *profile.FirejailProfileFile)
+		}
+
+		if sb, err := getSandbox(profile); err == nil {
+			if _, isFirejail := sb.(firejailSandbox); !isFirejail && (profile.FirejailProfileFile != nil || len(profile.FirejailDirectives) > 0) {
+				problems = append(problems, fmt.Errorf("Profile %s: Firejail overrides have no effect with the %s sandbox", profile.Label, profile.Sandbox))
+			}
+		}
+		if _, err := renderFirejailProfile(profile, configDir, defaultRelativeBundlePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			problems = append(problems, err)
+		}
+	}
+
+	exampleURL, _ := url.Parse("https://example.com/")
+	for i, rule := range config.RoutingRules {
+		if rule.Topic == "" {
+			problems = append(problems, fmt.Errorf("Routing rule %d has no topic", i+1))
+		}
+		if rule.Host == "" && rule.Pattern == "" {
+			problems = append(problems, fmt.Errorf("Routing rule %d has neither a host nor a pattern and never matches", i+1))
+		}
+		if rule.Profile != "" && !profileLabels[rule.Profile] {
+			problems = append(problems, fmt.Errorf("Routing rule %d refers to nonexistent profile %s", i+1, rule.Profile))
+		}
+		if _, err := rule.Matches(exampleURL); err != nil {
+			problems = append(problems, fmt.Errorf("Routing rule %d: %w", i+1, err))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,150 @@
+package internal_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"t0ast.cc/tbml/internal"
+)
+
+func TestValidateConfiguration(t *testing.T) {
+	config := getConfigurationFixtureWithMoreProfiles()
+	config.Profiles[0].ExtensionFiles = []string{"../ensure-extensions/extensions/foo@t0ast.cc.xpi"}
+	bookmarksFile := "../bookmarks/bookmarks.html"
+	config.Profiles[0].BookmarksFile = &bookmarksFile
+	config.RoutingRules = []internal.RoutingRule{
+		{
+			Host:    "*.example",
+			Profile: "test",
+			Topic:   "fine",
+		},
+	}
+
+	assert.Empty(t, internal.ValidateConfiguration(config, "testdata/ensure-files"))
+}
+
+func TestValidateConfigurationProblems(t *testing.T) {
+	missing := "missing.css"
+	relativeBookmarksFile := "../bookmarks/relative.json"
+	config := getConfigurationFixtureWithMoreProfiles()
+	bundlePath := filepath.Join(config.ProfilePath, "bundles")
+	config.BundlePath = &bundlePath
+	extensionCachePath := t.TempDir()
+	config.ExtensionCachePath = &extensionCachePath
+	config.Profiles = append(config.Profiles,
+		internal.ProfileConfiguration{
+			Label: "test",
+		},
+		internal.ProfileConfiguration{
+			FirejailDirectives: []string{"nosound"},
+			Label:              "bwrap",
+			Sandbox:            internal.SandboxBubblewrap,
+		},
+		internal.ProfileConfiguration{
+			Downloads:     "everywhere",
+			DownloadsRoot: &missing,
+			Label:         "downloads",
+		},
+		internal.ProfileConfiguration{
+			ExtensionFiles: []string{
+				"../ensure-extensions/extensions/unsigned.xpi",
+				"../ensure-extensions/extensions/no-id.xpi",
+				"../ensure-extensions/extensions/foo@t0ast.cc.xpi",
+				"../ensure-extensions/extensions/foo@t0ast.cc.xpi",
+			},
+			Extensions: []internal.Extension{
+				{
+					ID:      "bar@t0ast.cc",
+					SHA256:  "0000",
+					Version: "1.0",
+				},
+			},
+			Label: "extensions",
+		},
+		internal.ProfileConfiguration{
+			Label: "policies",
+			Policies: map[string]interface{}{
+				"ExtensionSettings": "none",
+			},
+		},
+		internal.ProfileConfiguration{
+			BookmarksFile: &relativeBookmarksFile,
+			Label:         "bookmarks",
+		},
+		internal.ProfileConfiguration{
+			Label:   "network",
+			Network: internal.NetworkIsolated,
+		},
+		internal.ProfileConfiguration{
+			FirejailDirectives: []string{"blacklist ${HOME}"},
+			Label:              "unsafe",
+		},
+		internal.ProfileConfiguration{
+			Label:          "broken",
+			Sandbox:        "chroot",
+			UserChromeFile: &missing,
+		},
+	)
+	config.RoutingRules = []internal.RoutingRule{
+		{
+			Host:    "*.example",
+			Profile: "nonexistent",
+			Topic:   "some-topic",
+		},
+		{
+			Pattern: "(",
+		},
+	}
+
+	problems := internal.ValidateConfiguration(config, "testdata/ensure-files")
+
+	messages := []string{}
+	for _, problem := range problems {
+		messages = append(messages, problem.Error())
+	}
+	assert.Len(t, messages, 19)
+	assert.Contains(t, messages, "Profile test: extension file testdata/ensure-files/extensions/foobar@t0ast.cc.xpi does not exist")
+	assert.Contains(t, messages, fmt.Sprintf("Bundle path %s must not be inside of profile path %s", bundlePath, config.ProfilePath))
+	assert.Contains(t, messages, "Profile downloads: Unknown downloads policy \"everywhere\" (expected \"instance\", \"shared\" or \"topic\")")
+	assert.Contains(t, messages, "Profile downloads: DownloadsRoot has no effect unless Downloads is \"topic\"")
+	assert.Contains(t, messages, "Profile extensions: Extension is not in the extension cache: bar@t0ast.cc 1.0 with SHA-256 0000; import it with \"tbml extensions import\"")
+	assert.Contains(t, messages, "Profile extensions: Extension is not signed: testdata/ensure-extensions/extensions/unsigned.xpi")
+	assert.Contains(t, messages, "Profile extensions: Extension has no add-on ID: testdata/ensure-extensions/extensions/no-id.xpi (browser_specific_settings.gecko.id is not set)")
+	assert.Contains(t, messages, "Profile extensions: extension file ../ensure-extensions/extensions/foo@t0ast.cc.xpi and extension file ../ensure-extensions/extensions/foo@t0ast.cc.xpi are both extension foo@t0ast.cc")
+	assert.Contains(t, messages, "Profile policies: The ExtensionSettings policy must be an object")
+	assert.Contains(t, messages, "Profile bookmarks: Invalid bookmarks file: testdata/bookmarks/relative.json: Bookmark URL /relative is not absolute")
+	assert.Contains(t, messages, "Profile network: The firejail sandbox needs a NetworkInterface to isolate the network")
+	assert.Contains(t, messages, "Profile bwrap: Firejail overrides have no effect with the bubblewrap sandbox")
+	assert.Contains(t, messages, "Profile unsafe: Forbidden firejail directive in line 66: \"blacklist ${HOME}\" would make ${HOME}/control-socket unusable")
+	assert.Contains(t, messages, "Profile label test is used more than once")
+	assert.Contains(t, messages, "Profile broken: Unknown sandbox \"chroot\" (expected \"firejail\" or \"bubblewrap\")")
+	assert.Contains(t, messages, "Profile broken: userChrome.css file testdata/ensure-files/missing.css does not exist")
+	assert.Contains(t, messages, "Routing rule 1 refers to nonexistent profile nonexistent")
+	assert.Contains(t, messages, "Routing rule 2 has no topic")
+	assert.Contains(t, messages, "Routing rule 2: Invalid pattern \"(\" in routing rule for topic : error parsing regexp: missing closing ): `(`")
+}
+
+func TestValidateConfigurationTorBrowser(t *testing.T) {
+	config := getConfigurationFixtureWithMoreProfiles()
+	config.Profiles[0].ExtensionFiles = []string{"../ensure-extensions/extensions/foo@t0ast.cc.xpi"}
+	version := "../11.0.4"
+	config.TorBrowser = &internal.TorBrowserConfiguration{
+		Channel: "nightly",
+		Version: &version,
+	}
+
+	problems := internal.ValidateConfiguration(config, "testdata/ensure-files")
+
+	messages := []string{}
+	for _, problem := range problems {
+		messages = append(messages, problem.Error())
+	}
+	assert.Equal(t, []string{
+		"TorBrowser requires a BundlePath to install Tor Browser into",
+		"Unknown Tor Browser channel \"nightly\" (expected \"release\" or \"alpha\")",
+		"Invalid Tor Browser version \"../11.0.4\" (expected a version like \"12.5.1\" or \"13.0a5\")",
+	}, messages)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	uio "t0ast.cc/tbml/util/io"
+)
+
+// runMainEnvVar makes the test binary run main with its arguments
+// instead of the tests, so that tests can check tbml's exit code.
+const runMainEnvVar = "TBML_TEST_RUN_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnvVar) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func TestExitCode(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.json")
+	require.NoError(t, os.WriteFile(configPath, []byte(`{
+		"ProfilePath": "profiles",
+		"Profiles": [
+			{
+				"FirejailProfileFile": "missing.profile",
+				"Label": "missing-file"
+			}
+		]
+	}`), uio.FileModeURWGRWO))
+
+	testCases := []struct {
+		desc string
+
+		args     []string
+		expected int
+	}{
+		{
+			desc: "Success",
+
+			args:     []string{"--config", configPath, "ls"},
+			expected: 0,
+		},
+		{
+			desc: "Invalid config",
+
+			args:     []string{"--config", configPath, "config", "check"},
+			expected: 11,
+		},
+		{
+			desc: "Missing config file",
+
+			args:     []string{"--config", filepath.Join(tmpDir, "missing.json"), "ls"},
+			expected: 10,
+		},
+		{
+			desc: "Missing file referenced by the config",
+
+			args:     []string{"--config", configPath, "open", "--topic", "test", "--profile", "missing-file", "--no-daemon", "--session", "discard"},
+			expected: 12,
+		},
+		{
+			desc: "Unknown profile",
+
+			args:     []string{"--config", configPath, "open", "--topic", "test", "--profile", "unknown", "--no-daemon"},
+			expected: 13,
+		},
+		{
+			desc: "Topic not open",
+
+			args:     []string{"--config", configPath, "remote", "tabs", "--topic", "test"},
+			expected: 15,
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], tC.args...)
+			cmd.Env = append(os.Environ(), runMainEnvVar+"=1", "HOME="+tmpDir, "XDG_CACHE_HOME="+filepath.Join(tmpDir, "cache"), "XDG_RUNTIME_DIR="+filepath.Join(tmpDir, "run"))
+			err := cmd.Run()
+
+			exitCode := 0
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				exitCode = exitErr.ExitCode()
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tC.expected, exitCode)
+		})
+	}
+}
@@ -1,5 +1,7 @@
 package com
 
+import "encoding/json"
+
 type MsgTypeIn string
 
 const (
@@ -17,6 +19,7 @@ type MsgTypeOut string
 const (
 	MsgTypeOutConnectorError MsgTypeOut = "connector-error"
 	MsgTypeOutConnectorLog   MsgTypeOut = "connector-log"
+	MsgTypeOutConnectorState MsgTypeOut = "connector-state"
 	MsgTypeOutTBML           MsgTypeOut = "tbml"
 )
 
@@ -24,3 +27,84 @@ type MsgOut struct {
 	Type MsgTypeOut  `json:"type"`
 	Data interface{} `json:"data"`
 }
+
+// ConnectorState is the data of a MsgTypeOutConnectorState message. It
+// tells the extension whether the connector is currently connected to
+// tbml's control socket.
+type ConnectorState string
+
+const (
+	ConnectorStateConnected    ConnectorState = "connected"
+	ConnectorStateDisconnected ConnectorState = "disconnected"
+)
+
+// TBMLMsgType is the type of a message between tbml and Mothership.
+// The connector forwards these messages as the data of MsgTypeInTBML
+// and MsgTypeOutTBML messages without looking into them.
+type TBMLMsgType string
+
+const (
+	// TBMLMsgTypeBookmarks carries the bookmarks that Mothership keeps
+	// in its managed folder.
+	TBMLMsgTypeBookmarks TBMLMsgType = "bookmarks"
+	TBMLMsgTypeOpenTab   TBMLMsgType = "open-tab"
+	TBMLMsgTypeOpenedTab TBMLMsgType = "opened-tab"
+	TBMLMsgTypeRequest   TBMLMsgType = "request"
+	TBMLMsgTypeResponse  TBMLMsgType = "response"
+	// TBMLMsgTypeSession carries the tabs that are open in the
+	// browser. Mothership sends it whenever they change after tbml
+	// sent TBMLMsgTypeWatchSession.
+	TBMLMsgTypeSession      TBMLMsgType = "session"
+	TBMLMsgTypeWatchSession TBMLMsgType = "watch-session"
+)
+
+type BookmarksMsg struct {
+	Bookmarks []Bookmark  `json:"bookmarks"`
+	Type      TBMLMsgType `json:"type"`
+}
+
+// Bookmark is a folder if it has no URL.
+type Bookmark struct {
+	Children []Bookmark `json:"children,omitempty"`
+	Title    string     `json:"title"`
+	URL      string     `json:"url,omitempty"`
+}
+
+// TBMLMsg is the part that all messages between tbml and Mothership
+// have in common.
+type TBMLMsg struct {
+	Type TBMLMsgType `json:"type"`
+}
+
+type OpenTabMsg struct {
+	Type TBMLMsgType `json:"type"`
+	URL  string      `json:"url"`
+}
+
+// Request asks Mothership to run a command. Mothership answers it with
+// a Response with the same ID.
+type Request struct {
+	Args    json.RawMessage `json:"args,omitempty"`
+	Command string          `json:"command"`
+	ID      string          `json:"id"`
+	Type    TBMLMsgType     `json:"type"`
+}
+
+// Response carries either the result of a command or the error it
+// failed with.
+type Response struct {
+	Error  string          `json:"error,omitempty"`
+	ID     string          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Type   TBMLMsgType     `json:"type"`
+}
+
+type SessionMsg struct {
+	Tabs []SessionTab `json:"tabs"`
+	Type TBMLMsgType  `json:"type"`
+}
+
+type SessionTab struct {
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
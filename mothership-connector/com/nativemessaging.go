@@ -1,6 +1,7 @@
 package com
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
@@ -10,6 +11,15 @@ import (
 	uerror "t0ast.cc/tbml/util/error"
 )
 
+// MaxOutgoingMessageSize is the maximum size of a message that Firefox
+// accepts from a native application. Messages that Firefox sends to a
+// native application may be up to 4 GB, which the uint32 length
+// prefix can't exceed anyway.
+const MaxOutgoingMessageSize = 1024 * 1024
+
+var ErrMessageTooLarge error = errors.New("Message is too large")
+var ErrInvalidMessage error = errors.New("Message is not valid JSON")
+
 type NativeMessagingPort struct {
 	byteOrder binary.ByteOrder
 	in        io.Reader
@@ -28,23 +38,30 @@ func NewNativeMessagingPort(in io.Reader, out io.Writer) (NativeMessagingPort, e
 	}, nil
 }
 
+// ReceiveMessage reads the next message from Firefox into v. It returns
+// io.EOF if the input ends before a message starts and
+// io.ErrUnexpectedEOF if it ends within a message. If the message
+// can't be unmarshalled into v, it returns ErrInvalidMessage and the
+// next message can still be received.
 func (p NativeMessagingPort) ReceiveMessage(v interface{}) error {
 	length, err := p.readUint32()
 	if err != nil {
 		return uerror.WithStackTrace(err)
 	}
 
-	msgBytes := make([]byte, length)
-	n, err := p.in.Read(msgBytes)
-	if err != nil {
-		return uerror.WithStackTrace(err)
-	}
-	if n != int(length) {
-		return uerror.StackTracef("Number of bytes read (%d) != length (%d)", n, length)
+	// The message is read into a growing buffer rather than one of the
+	// announced length, so that a corrupted length prefix doesn't
+	// allocate up to 4 GB before the input runs out.
+	msgBuf := bytes.Buffer{}
+	if _, err := io.CopyN(&msgBuf, p.in, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return uerror.StackTracef("Failed to read message of length %d: %w", length, err)
 	}
 
-	if err := json.Unmarshal(msgBytes, v); err != nil {
-		return uerror.WithStackTrace(err)
+	if err := json.Unmarshal(msgBuf.Bytes(), v); err != nil {
+		return uerror.StackTracef("%w: %v", ErrInvalidMessage, err)
 	}
 
 	return nil
@@ -55,6 +72,9 @@ func (p NativeMessagingPort) SendMessage(msg interface{}) error {
 	if err != nil {
 		return uerror.WithStackTrace(err)
 	}
+	if len(msgBytes) > MaxOutgoingMessageSize {
+		return uerror.StackTracef("%w: %d bytes, Firefox accepts at most %d", ErrMessageTooLarge, len(msgBytes), MaxOutgoingMessageSize)
+	}
 
 	if err := p.writeUint32(uint32(len(msgBytes))); err != nil {
 		return uerror.WithStackTrace(err)
@@ -74,13 +94,9 @@ func (p NativeMessagingPort) SendMessage(msg interface{}) error {
 func (p NativeMessagingPort) readUint32() (uint32, error) {
 	val := make([]byte, 4)
 
-	n, err := p.in.Read(val)
-	if err != nil {
+	if _, err := io.ReadFull(p.in, val); err != nil {
 		return 0, uerror.WithStackTrace(err)
 	}
-	if n != 4 {
-		return 0, uerror.StackTracef("Number of bytes read (%d) != 4", n)
-	}
 
 	return p.byteOrder.Uint32(val), nil
 }
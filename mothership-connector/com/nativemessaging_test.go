@@ -0,0 +1,198 @@
+package com
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func frame(t testing.TB, length uint32, body string) []byte {
+	byteOrder, err := getNativeByteOrder()
+	require.NoError(t, err)
+	out := make([]byte, 4)
+	byteOrder.PutUint32(out, length)
+	return append(out, body...)
+}
+
+func TestReceiveMessage(t *testing.T) {
+	testCases := []struct {
+		desc string
+
+		expected    interface{}
+		expectedErr error
+		in          func(t *testing.T) io.Reader
+	}{
+		{
+			desc: "Complete message",
+
+			expected: map[string]interface{}{"type": "hello"},
+			in: func(t *testing.T) io.Reader {
+				return bytes.NewReader(frame(t, 16, `{"type":"hello"}`))
+			},
+		},
+		{
+			desc: "Short reads",
+
+			expected: map[string]interface{}{"type": "hello"},
+			in: func(t *testing.T) io.Reader {
+				return iotest.OneByteReader(bytes.NewReader(frame(t, 16, `{"type":"hello"}`)))
+			},
+		},
+		{
+			desc: "No message",
+
+			expectedErr: io.EOF,
+			in: func(t *testing.T) io.Reader {
+				return bytes.NewReader(nil)
+			},
+		},
+		{
+			desc: "Truncated length",
+
+			expectedErr: io.ErrUnexpectedEOF,
+			in: func(t *testing.T) io.Reader {
+				return bytes.NewReader(frame(t, 16, "")[:2])
+			},
+		},
+		{
+			desc: "Truncated message",
+
+			expectedErr: io.ErrUnexpectedEOF,
+			in: func(t *testing.T) io.Reader {
+				return bytes.NewReader(frame(t, 16, `{"type":`))
+			},
+		},
+		{
+			desc: "Invalid message",
+
+			expectedErr: ErrInvalidMessage,
+			in: func(t *testing.T) io.Reader {
+				return bytes.NewReader(frame(t, 8, `{"type":`))
+			},
+		},
+		{
+			desc: "Maximum length without message",
+
+			expectedErr: io.ErrUnexpectedEOF,
+			in: func(t *testing.T) io.Reader {
+				return bytes.NewReader(frame(t, ^uint32(0), `{}`))
+			},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			port, err := NewNativeMessagingPort(tC.in(t), io.Discard)
+			require.NoError(t, err)
+
+			var msg interface{}
+			err = port.ReceiveMessage(&msg)
+			if tC.expectedErr != nil {
+				assert.ErrorIs(t, err, tC.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tC.expected, msg)
+		})
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	testCases := []struct {
+		desc string
+
+		expectedErr error
+		msg         string
+	}{
+		{
+			desc: "Small message",
+
+			msg: "hello",
+		},
+		{
+			desc: "Largest message",
+
+			msg: strings.Repeat("a", MaxOutgoingMessageSize-2),
+		},
+		{
+			desc: "Oversized message",
+
+			expectedErr: ErrMessageTooLarge,
+			msg:         strings.Repeat("a", MaxOutgoingMessageSize-1),
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			out := bytes.Buffer{}
+			port, err := NewNativeMessagingPort(bytes.NewReader(nil), &out)
+			require.NoError(t, err)
+
+			err = port.SendMessage(tC.msg)
+			if tC.expectedErr != nil {
+				assert.ErrorIs(t, err, tC.expectedErr)
+				assert.Zero(t, out.Len(), "Nothing must be written for a rejected message")
+				return
+			}
+			require.NoError(t, err)
+
+			in, err := NewNativeMessagingPort(&out, io.Discard)
+			require.NoError(t, err)
+			var msg string
+			require.NoError(t, in.ReceiveMessage(&msg))
+			assert.Equal(t, tC.msg, msg)
+		})
+	}
+}
+
+func FuzzReceiveMessage(f *testing.F) {
+	f.Add(frame(f, 2, `{}`))
+	f.Add(frame(f, 16, `{"type":"hello"}`))
+	f.Add(frame(f, 16, `{"type":`))
+	f.Add(frame(f, ^uint32(0), `{}`))
+	f.Add(frame(f, 0, "")[:3])
+	f.Fuzz(func(t *testing.T, in []byte) {
+		port, err := NewNativeMessagingPort(iotest.HalfReader(bytes.NewReader(in)), io.Discard)
+		require.NoError(t, err)
+
+		var msg interface{}
+		err = port.ReceiveMessage(&msg)
+
+		byteOrder, _ := getNativeByteOrder()
+		switch {
+		case len(in) == 0:
+			assert.ErrorIs(t, err, io.EOF)
+		case len(in) < 4 || uint64(len(in)-4) < uint64(byteOrder.Uint32(in)):
+			assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+		case err != nil:
+			assert.ErrorIs(t, err, ErrInvalidMessage, "A complete frame must only fail to unmarshal")
+		}
+	})
+}
+
+func FuzzSendMessage(f *testing.F) {
+	f.Add("hello")
+	f.Add(strings.Repeat("a", MaxOutgoingMessageSize))
+	f.Fuzz(func(t *testing.T, msg string) {
+		out := bytes.Buffer{}
+		port, err := NewNativeMessagingPort(bytes.NewReader(nil), &out)
+		require.NoError(t, err)
+
+		err = port.SendMessage(msg)
+		if errors.Is(err, ErrMessageTooLarge) {
+			assert.Zero(t, out.Len())
+			return
+		}
+		require.NoError(t, err)
+
+		byteOrder, err := getNativeByteOrder()
+		require.NoError(t, err)
+		length := byteOrder.Uint32(out.Bytes())
+		assert.LessOrEqual(t, int(length), MaxOutgoingMessageSize)
+		assert.Equal(t, int(length), out.Len()-4)
+	})
+}
@@ -7,76 +7,185 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"t0ast.cc/tbml/mothership-connector/com"
 	uerror "t0ast.cc/tbml/util/error"
+	ulog "t0ast.cc/tbml/util/log"
+)
+
+const (
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 5 * time.Second
 )
 
 var messaging com.NativeMessagingPort
+var messagingMutex sync.Mutex
 
 func main() {
 	// Uncomment for debugging; Should be commented in production to
 	// avoid writing unnecessary log information to disk.
 	// redirectStderr()
 
-	logger := log.Default()
+	ulog.ConfigureFromEnv()
+	ulog.Default().SetName("mothership-connector")
 
 	ctx, _ := signal.NotifyContext(context.Background(), syscall.SIGTERM)
 	go func() {
 		<-ctx.Done()
-		logger.Println("Got SIGTERM")
+		ulog.Debugf("Got SIGTERM")
 	}()
 
-	stdin := makeClosable(os.Stdin)
-	defer stdin.Close()
-
-	nm, err := com.NewNativeMessagingPort(stdin, os.Stdout)
+	nm, err := com.NewNativeMessagingPort(os.Stdin, os.Stdout)
 	uerror.ErrPanic(err)
 	messaging = nm
 
-	// Get control socket path
-	socketPathMsg, err := receiveMessage()
-	errPanicWithLog(err)
-	if socketPathMsg.Type != com.MsgTypeInInitControlSocketPath {
-		panicWithLog(fmt.Errorf("Initialization error: Wanted message \"%s\" but got \"%s\"", com.MsgTypeInInitControlSocketPath, socketPathMsg.Type))
+	if err := run(ctx); err != nil {
+		sendLogMessage(com.MsgTypeOutConnectorError, err)
+		ulog.Errorf("%v", err)
+		os.Exit(1)
 	}
-	controlSocketPath, ok := socketPathMsg.Data.(string)
-	if !ok {
-		panicWithLog(fmt.Errorf("\"%s\" data was not a string", com.MsgTypeInInitControlSocketPath))
+	ulog.Debugf("Exiting")
+}
+
+// run forwards messages between the extension and tbml's control
+// socket until the extension closes stdin or the connector receives
+// SIGTERM. If the control socket connection is lost, it reconnects.
+func run(ctx context.Context) error {
+	msgs, receiveErr := receiveMessages()
+
+	controlSocketPath, err := receiveControlSocketPath(ctx, msgs, receiveErr)
+	if err != nil || controlSocketPath == "" {
+		return uerror.WithStackTrace(err)
 	}
-	sendLogMessage(com.MsgTypeOutConnectorLog, fmt.Sprint("Got control socket path:", controlSocketPath))
+	sendMessage(com.MsgTypeOutConnectorLog, fmt.Sprint("Got control socket path: ", controlSocketPath))
 
-	// Connect to control socket
-	addr, err := net.ResolveUnixAddr("unix", controlSocketPath)
-	errPanicWithLog(err)
-	sendMessage(com.MsgTypeOutConnectorLog, "Resolved unix domain socket addresss")
-	socketConn, err := net.DialUnix("unix", nil, addr)
-	errPanicWithLog(err)
-	defer socketConn.Close()
-	sendMessage(com.MsgTypeOutConnectorLog, "Established unix connection")
+	reconnectDelay := minReconnectDelay
+	for {
+		conn, err := dialControlSocket(controlSocketPath)
+		if err != nil {
+			sendLogMessage(com.MsgTypeOutConnectorError, fmt.Errorf("Failed to connect to control socket, retrying in %s: %w", reconnectDelay, err))
+			done, err := waitForReconnect(ctx, reconnectDelay, msgs, receiveErr)
+			if done {
+				return uerror.WithStackTrace(err)
+			}
+			reconnectDelay *= 2
+			if reconnectDelay > maxReconnectDelay {
+				reconnectDelay = maxReconnectDelay
+			}
+			continue
+		}
+		reconnectDelay = minReconnectDelay
 
-	wg := sync.WaitGroup{}
-	wg.Add(2)
+		sendMessage(com.MsgTypeOutConnectorState, com.ConnectorStateConnected)
+		done, err := forwardMessages(ctx, conn, msgs, receiveErr)
+		sendMessage(com.MsgTypeOutConnectorState, com.ConnectorStateDisconnected)
+		if done {
+			return uerror.WithStackTrace(err)
+		}
+	}
+}
 
+// receiveMessages reads messages from stdin until it ends. Once the
+// returned channel is closed, receiveErr yields nil if stdin ended
+// between two messages and an error otherwise.
+func receiveMessages() (msgs <-chan com.MsgIn, receiveErr <-chan error) {
+	msgChan := make(chan com.MsgIn)
+	errChan := make(chan error, 1)
 	go func() {
-		defer wg.Done()
-		sc := bufio.NewScanner(socketConn)
-	RECEIVE:
-		for sc.Scan() {
-			select {
-			case <-ctx.Done():
-				break RECEIVE
-			default:
+		defer close(msgChan)
+		for {
+			var msg com.MsgIn
+			err := messaging.ReceiveMessage(&msg)
+			if errors.Is(err, com.ErrInvalidMessage) {
+				sendLogMessage(com.MsgTypeOutConnectorError, err)
+				continue
 			}
+			if errors.Is(err, io.EOF) {
+				ulog.Debugf("Stdin was closed")
+				errChan <- nil
+				return
+			}
+			if err != nil {
+				errChan <- uerror.StackTracef("Failed to receive message: %w", err)
+				return
+			}
+			msgChan <- msg
+		}
+	}()
+	return msgChan, errChan
+}
 
-			logger.Println("Not exiting receive")
+// receiveControlSocketPath waits for the extension's first message,
+// which tells the path of the control socket. It returns an empty path
+// without error if the connector should exit before that.
+func receiveControlSocketPath(ctx context.Context, msgs <-chan com.MsgIn, receiveErr <-chan error) (string, error) {
+	select {
+	case <-ctx.Done():
+		return "", nil
+	case msg, ok := <-msgs:
+		if !ok {
+			return "", <-receiveErr
+		}
+		if msg.Type != com.MsgTypeInInitControlSocketPath {
+			return "", uerror.StackTracef("Initialization error: Wanted message \"%s\" but got \"%s\"", com.MsgTypeInInitControlSocketPath, msg.Type)
+		}
+		controlSocketPath, ok := msg.Data.(string)
+		if !ok {
+			return "", uerror.StackTracef("\"%s\" data was not a string", com.MsgTypeInInitControlSocketPath)
+		}
+		return controlSocketPath, nil
+	}
+}
 
+func dialControlSocket(controlSocketPath string) (*net.UnixConn, error) {
+	addr, err := net.ResolveUnixAddr("unix", controlSocketPath)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	conn, err := net.DialUnix("unix", nil, addr)
+	if err != nil {
+		return nil, uerror.WithStackTrace(err)
+	}
+	return conn, nil
+}
+
+// waitForReconnect waits for the delay to pass. Messages from the
+// extension can't be delivered in the meantime and are dropped. done
+// is true if the connector should exit instead of reconnecting.
+func waitForReconnect(ctx context.Context, delay time.Duration, msgs <-chan com.MsgIn, receiveErr <-chan error) (done bool, err error) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return false, nil
+		case <-ctx.Done():
+			return true, nil
+		case msg, ok := <-msgs:
+			if !ok {
+				return true, <-receiveErr
+			}
+			sendMessage(com.MsgTypeOutConnectorLog, fmt.Sprintf("WARNING: Dropping message with type \"%s\" while not connected to control socket", msg.Type))
+		}
+	}
+}
+
+// forwardMessages forwards messages between the extension and the
+// control socket connection until either side closes. done is true if
+// the connector should exit instead of reconnecting.
+func forwardMessages(ctx context.Context, conn *net.UnixConn, msgs <-chan com.MsgIn, receiveErr <-chan error) (done bool, err error) {
+	socketClosed := make(chan struct{})
+	go func() {
+		defer close(socketClosed)
+		sc := bufio.NewScanner(conn)
+		sc.Buffer(nil, com.MaxOutgoingMessageSize)
+		for sc.Scan() {
 			var tbmlMsg interface{}
 			if err := json.Unmarshal(sc.Bytes(), &tbmlMsg); err != nil {
 				sendLogMessage(com.MsgTypeOutConnectorError, fmt.Errorf("Failed to unmarshal incoming JSON message object: %w\n\t%s", err, sc.Bytes()))
@@ -84,53 +193,47 @@ func main() {
 			}
 			sendMessage(com.MsgTypeOutTBML, tbmlMsg)
 		}
-
-		logger.Println("Exiting receive")
+		if err := sc.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+			sendLogMessage(com.MsgTypeOutConnectorError, fmt.Errorf("Failed to receive from control socket: %w", err))
+		}
+	}()
+	defer func() {
+		_ = conn.Close()
+		<-socketClosed
 	}()
 
-	go func() {
-		defer wg.Done()
-	SEND:
-		for {
-			select {
-			case <-ctx.Done():
-				break SEND
-			default:
-			}
-
-			logger.Println("Not exiting send")
-
-			dataMsg, err := receiveMessage()
-			if err != nil {
-				if !errors.Is(err, os.ErrClosed) {
-					sendLogMessage(com.MsgTypeOutConnectorError, fmt.Errorf("Failed to receive outgoing message: %w", err))
-				}
-				continue
+	for {
+		select {
+		case <-ctx.Done():
+			return true, nil
+		case <-socketClosed:
+			sendMessage(com.MsgTypeOutConnectorLog, "Control socket connection was closed")
+			return false, nil
+		case msg, ok := <-msgs:
+			if !ok {
+				return true, <-receiveErr
 			}
-			if dataMsg.Type != com.MsgTypeInTBML {
-				sendMessage(com.MsgTypeOutConnectorLog, fmt.Sprintf("WARNING: Skipping message with type \"%s\" (only accepting \"tbml\")", dataMsg.Type))
+			if msg.Type != com.MsgTypeInTBML {
+				sendMessage(com.MsgTypeOutConnectorLog, fmt.Sprintf("WARNING: Skipping message with type \"%s\" (only accepting \"tbml\")", msg.Type))
 				continue
 			}
-
-			dataBytes, err := json.Marshal(dataMsg.Data)
-			errPanicWithLog(err)
-
-			n, err := socketConn.Write(dataBytes)
-			errPanicWithLog(err)
-			if n != len(dataBytes) {
-				panicWithLog(fmt.Errorf("Number of bytes written to Unix socket (%d) != length (%d)", n, len(dataBytes)))
+			if err := writeToControlSocket(conn, msg.Data); err != nil {
+				sendLogMessage(com.MsgTypeOutConnectorError, fmt.Errorf("Failed to write to control socket: %w", err))
+				return false, nil
 			}
-			_, err = socketConn.Write([]byte("\n"))
-			errPanicWithLog(err)
 		}
+	}
+}
 
-		logger.Println("Exiting send")
-	}()
-
-	<-ctx.Done()
-	_ = (socketConn.Close())
-	_ = (stdin.Close())
-	wg.Wait()
+func writeToControlSocket(conn *net.UnixConn, data interface{}) error {
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	if _, err := conn.Write(append(dataBytes, '\n')); err != nil {
+		return uerror.WithStackTrace(err)
+	}
+	return nil
 }
 
 func redirectStderr() {
@@ -139,54 +242,31 @@ func redirectStderr() {
 	uerror.ErrPanic(syscall.Dup2(int(f.Fd()), 2))
 }
 
-func makeClosable(reader io.Reader) io.ReadCloser {
-	pr, pw := io.Pipe()
-	go func() {
-		_, _ = io.Copy(pw, reader)
-	}()
-	return pr
-}
-
-func receiveMessage() (com.MsgIn, error) {
-	var msg com.MsgIn
-	return msg, messaging.ReceiveMessage(&msg)
-}
-
+// sendMessage sends a message to the extension. Since a message can't
+// be sent once the extension is gone, failures are only logged.
 func sendMessage(typ com.MsgTypeOut, data interface{}) {
-	uerror.ErrPanic(
-		messaging.SendMessage(com.MsgOut{
-			Type: typ,
-			Data: data,
-		}))
-}
-
-func errPanicWithLog(data interface{}) {
-	if data != nil {
-		panicWithLog(data)
+	messagingMutex.Lock()
+	defer messagingMutex.Unlock()
+	if err := messaging.SendMessage(com.MsgOut{
+		Type: typ,
+		Data: data,
+	}); err != nil {
+		ulog.Errorf("Failed to send %s message: %v", typ, err)
 	}
 }
 
-func panicWithLog(data interface{}) {
-	sendLogMessage(com.MsgTypeOutConnectorError, data)
-	panic(data)
-}
-
 func sendLogMessage(typ com.MsgTypeOut, data interface{}) {
-	switch typ := data.(type) {
-	case uerror.ErrorWithStackTrace:
-		var errStr string
-		if typ.Unwrap() != nil {
-			errStr = typ.Unwrap().Error()
-		}
-		data = struct {
-			Error      string
-			StackTrace string
-		}{
-			Error:      errStr,
-			StackTrace: typ.StackTrace,
+	if err, ok := data.(error); ok {
+		data = err.Error()
+		if stackTrace, ok := uerror.GetStackTrace(err); ok {
+			data = struct {
+				Error      string
+				StackTrace string
+			}{
+				Error:      err.Error(),
+				StackTrace: stackTrace,
+			}
 		}
-	case error:
-		data = typ.Error()
 	}
 	sendMessage(typ, data)
 }
@@ -85,7 +85,6 @@ func setUpMothershipConnector(t *testing.T) (connection connectionHandles, socke
 	}
 
 	handleUnixConnection := func() {
-		cleanupWaitGroup.Add(1)
 		defer cleanupWaitGroup.Done()
 
 		conn, err := listener.AcceptUnix()
@@ -94,8 +93,8 @@ func setUpMothershipConnector(t *testing.T) (connection connectionHandles, socke
 		}
 		defer conn.Close()
 
+		cleanupWaitGroup.Add(1)
 		go func() {
-			cleanupWaitGroup.Add(1)
 			defer cleanupWaitGroup.Done()
 
 			sc := bufio.NewScanner(conn)
@@ -146,12 +145,12 @@ func setUpMothershipConnector(t *testing.T) (connection connectionHandles, socke
 	}
 
 	waitForConnectorExit := func() {
-		cleanupWaitGroup.Add(1)
 		defer cleanupWaitGroup.Done()
 
 		assert.NoError(t, connectorCmd.Run())
 	}
 
+	cleanupWaitGroup.Add(2)
 	go handleUnixConnection()
 
 	go waitForConnectorExit()
@@ -174,10 +173,14 @@ func TestMothershipConnector(t *testing.T) {
 	require.NoError(t, err)
 
 	require.NoError(t, conn.sendToStdin(com.MsgTypeInInitControlSocketPath, socketPath))
-	for i := 0; i < 3; i++ {
+	for {
 		m, err := conn.receiveFromStdout()
-		assert.NoError(t, err)
+		require.NoError(t, err)
 		fmt.Println(m)
+		if m.Type == com.MsgTypeOutConnectorState {
+			assert.Equal(t, string(com.ConnectorStateConnected), m.Data)
+			break
+		}
 	}
 
 	msgForward := func(data interface{}) {
@@ -213,3 +216,76 @@ func TestMothershipConnector(t *testing.T) {
 	msgForward(true)
 	msgBack(false)
 }
+
+func TestMothershipConnectorReconnects(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "control-socket")
+
+	connectorCmd := exec.Command("../internal/mothership-connector")
+	connectorCmd.Stderr = uio.NewPrefixWriter(os.Stdout, "connector-stderr> ")
+	out, err := connectorCmd.StdoutPipe()
+	require.NoError(t, err)
+	in, err := connectorCmd.StdinPipe()
+	require.NoError(t, err)
+	messaging, err := com.NewNativeMessagingPort(out, in)
+	require.NoError(t, err)
+	require.NoError(t, connectorCmd.Start())
+	defer func() {
+		_ = connectorCmd.Process.Kill()
+	}()
+
+	receiveUntil := func(typ com.MsgTypeOut) com.MsgOut {
+		for {
+			var msg com.MsgOut
+			require.NoError(t, messaging.ReceiveMessage(&msg))
+			fmt.Println(msg)
+			if msg.Type == typ {
+				return msg
+			}
+		}
+	}
+
+	require.NoError(t, messaging.SendMessage(com.MsgIn{
+		Type: com.MsgTypeInInitControlSocketPath,
+		Data: socketPath,
+	}))
+	// The control socket doesn't exist yet
+	receiveUntil(com.MsgTypeOutConnectorError)
+
+	addr, err := net.ResolveUnixAddr("unix", socketPath)
+	require.NoError(t, err)
+	listener, err := net.ListenUnix("unix", addr)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	assert.Equal(t, string(com.ConnectorStateConnected), receiveUntil(com.MsgTypeOutConnectorState).Data)
+	socketConn, err := listener.AcceptUnix()
+	require.NoError(t, err)
+	require.NoError(t, socketConn.Close())
+	assert.Equal(t, string(com.ConnectorStateDisconnected), receiveUntil(com.MsgTypeOutConnectorState).Data)
+
+	assert.Equal(t, string(com.ConnectorStateConnected), receiveUntil(com.MsgTypeOutConnectorState).Data)
+	socketConn, err = listener.AcceptUnix()
+	require.NoError(t, err)
+	defer socketConn.Close()
+	require.NoError(t, messaging.SendMessage(com.MsgIn{
+		Type: com.MsgTypeInTBML,
+		Data: "Hello again",
+	}))
+	line, err := bufio.NewReader(socketConn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "\"Hello again\"\n", line)
+
+	// The connector exits cleanly once the browser closes stdin
+	require.NoError(t, in.Close())
+	assert.Equal(t, string(com.ConnectorStateDisconnected), receiveUntil(com.MsgTypeOutConnectorState).Data)
+	exited := make(chan error, 1)
+	go func() {
+		exited <- connectorCmd.Wait()
+	}()
+	select {
+	case err := <-exited:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "Timeout hit waiting for exit")
+	}
+}
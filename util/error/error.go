@@ -41,8 +41,59 @@ func GetExitCode(err error) (exitCode uint, hasExitCode bool) {
 	return 0, false
 }
 
-// ErrorWithStackTrace is an error that attaches a stack trace to its
-// message.
+// Code is a stable, machine-readable identifier for a kind of error
+// that users may run into.
+type Code string
+
+// UserError is an error that carries a message meant for users, an
+// optional hint on how to resolve it and a stable error code.
+type UserError struct {
+	Code    Code
+	Hint    string
+	Message string
+	Wrapped error
+}
+
+// Error returns the user-facing message of this error or, if there
+// is none, the message of the underlying error.
+func (e UserError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	if e.Wrapped == nil {
+		return string(e.Code)
+	}
+	return e.Wrapped.Error()
+}
+
+// Unwrap returns the underlying error of this error.
+func (e UserError) Unwrap() error {
+	return e.Wrapped
+}
+
+// WithUserMessage attaches an error code, a user-facing message and
+// a hint to the error. The message and hint may be empty.
+func WithUserMessage(code Code, message, hint string, err error) error {
+	return UserError{
+		Code:    code,
+		Hint:    hint,
+		Message: message,
+		Wrapped: err,
+	}
+}
+
+// GetUserError returns the outermost UserError in the error's chain.
+func GetUserError(err error) (UserError, bool) {
+	var userErr UserError
+	if errors.As(err, &userErr) {
+		return userErr, true
+	}
+	return UserError{}, false
+}
+
+// ErrorWithStackTrace is an error that carries the stack trace of
+// the point where it was created. The stack trace is not part of the
+// error's message; use GetStackTrace to retrieve it.
 type ErrorWithStackTrace struct {
 	StackTrace string
 	Wrapped    error
@@ -50,7 +101,7 @@ type ErrorWithStackTrace struct {
 
 // Error returns this error's message.
 func (s ErrorWithStackTrace) Error() string {
-	return fmt.Sprintf("%v\n\n%s\nEND OF StackTraceError", s.Wrapped, s.StackTrace)
+	return s.Wrapped.Error()
 }
 
 // Unwrap returns the underlying error of this error.
@@ -76,13 +127,20 @@ func WithStackTrace(err error) error {
 }
 
 func hasStackTrace(err error) bool {
+	_, ok := GetStackTrace(err)
+	return ok
+}
+
+// GetStackTrace returns the stack trace attached to the error or any
+// error it wraps.
+func GetStackTrace(err error) (stackTrace string, hasStackTrace bool) {
 	for err != nil {
-		if _, ok := err.(ErrorWithStackTrace); ok {
-			return true
+		if err, ok := err.(ErrorWithStackTrace); ok {
+			return err.StackTrace, true
 		}
 		err = errors.Unwrap(err)
 	}
-	return false
+	return "", false
 }
 
 func StackTracef(format string, a ...interface{}) error {
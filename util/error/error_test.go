@@ -0,0 +1,57 @@
+package error_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	uerror "t0ast.cc/tbml/util/error"
+)
+
+var errTest = errors.New("Something went wrong")
+
+func TestWithStackTrace(t *testing.T) {
+	err := uerror.WithStackTrace(errTest)
+
+	assert.Equal(t, "Something went wrong", err.Error())
+	assert.ErrorIs(t, err, errTest)
+
+	stackTrace, hasStackTrace := uerror.GetStackTrace(fmt.Errorf("Wrapped: %w", err))
+	assert.True(t, hasStackTrace)
+	assert.Contains(t, stackTrace, "TestWithStackTrace")
+
+	assert.Equal(t, err, uerror.WithStackTrace(err))
+	assert.Nil(t, uerror.WithStackTrace(nil))
+
+	_, hasStackTrace = uerror.GetStackTrace(errTest)
+	assert.False(t, hasStackTrace)
+}
+
+func TestWithUserMessage(t *testing.T) {
+	err := uerror.WithExitCode(12, uerror.WithUserMessage("test-code", "Nice message", "Try again", uerror.WithStackTrace(errTest)))
+
+	assert.Equal(t, "Nice message", err.Error())
+	assert.ErrorIs(t, err, errTest)
+
+	userErr, isUserErr := uerror.GetUserError(fmt.Errorf("Wrapped: %w", err))
+	assert.True(t, isUserErr)
+	assert.Equal(t, uerror.Code("test-code"), userErr.Code)
+	assert.Equal(t, "Try again", userErr.Hint)
+
+	exitCode, hasExitCode := uerror.GetExitCode(err)
+	assert.True(t, hasExitCode)
+	assert.Equal(t, uint(12), exitCode)
+
+	_, hasStackTrace := uerror.GetStackTrace(err)
+	assert.True(t, hasStackTrace)
+
+	_, isUserErr = uerror.GetUserError(errTest)
+	assert.False(t, isUserErr)
+}
+
+func TestWithUserMessageWithoutMessage(t *testing.T) {
+	err := uerror.WithUserMessage("test-code", "", "", errTest)
+
+	assert.Equal(t, "Something went wrong", err.Error())
+}
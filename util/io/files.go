@@ -13,6 +13,10 @@ import (
 // `u=rw,g=rw,o=`.
 var FileModeURWGRWO os.FileMode = 0660
 
+// FileModeURWXGO is the bitmask for the Unix permission flags
+// `u=rwx,g=,o=`.
+var FileModeURWXGO os.FileMode = 0700
+
 // FileModeURWXGRWXO is the bitmask for the Unix permission flags
 // `u=rwx,g=rwx,o=`.
 var FileModeURWXGRWXO os.FileMode = 0770
@@ -70,6 +74,17 @@ func CopyFile(src, dst string) error {
 // CopyDir copies all files in the `src` directroy into `dst`,
 // preserving permissions.
 func CopyDir(src, dst string) error {
+	return copyDir(src, dst, true)
+}
+
+// CopyDirMissing copies the files in the `src` directory that `dst`
+// doesn't have yet into `dst`, preserving permissions. Files that
+// exist in `dst` are left as they are.
+func CopyDirMissing(src, dst string) error {
+	return copyDir(src, dst, false)
+}
+
+func copyDir(src, dst string, overwrite bool) error {
 	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -85,7 +100,16 @@ func CopyDir(src, dst string) error {
 			if err := os.MkdirAll(dstPath, fileInfo.Mode()); err != nil {
 				return err
 			}
-		} else if err := copyDirFile(path, dstPath, fileInfo); err != nil {
+			return nil
+		}
+		if !overwrite {
+			if _, err := os.Lstat(dstPath); err == nil {
+				return nil
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		if err := copyDirFile(path, dstPath, fileInfo); err != nil {
 			return err
 		}
 		return nil
@@ -108,3 +132,24 @@ func copyDirFile(path, dst string, fileInfo fs.FileInfo) error {
 	}
 	return nil
 }
+
+// DirSize returns the summed size of all regular files in the
+// directory tree at `name`. Symlinks are not followed.
+func DirSize(name string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(name, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fileInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += fileInfo.Size()
+		return nil
+	})
+	return size, err
+}
@@ -84,3 +84,26 @@ func TestCopyDir(t *testing.T) {
 	dir2 := readTestDir(t, "dir-2")
 	assert.Equal(t, dir1Before, dir2)
 }
+
+func TestCopyDirMissing(t *testing.T) {
+	dst := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dst, "a.txt"), []byte("changed"), uio.FileModeURWGRWO))
+
+	assert.NoError(t, uio.CopyDirMissing("testdata/dir-1", dst))
+
+	aContent, err := os.ReadFile(filepath.Join(dst, "a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "changed", string(aContent))
+
+	expectedCContent, err := os.ReadFile("testdata/dir-1/b/c.json")
+	assert.NoError(t, err)
+	cContent, err := os.ReadFile(filepath.Join(dst, "b/c.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, expectedCContent, cContent)
+}
+
+func TestDirSize(t *testing.T) {
+	size, err := uio.DirSize("testdata/dir-1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(18), size)
+}
@@ -0,0 +1,62 @@
+package io
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+// Follow copies the named file to the writer and then keeps copying
+// whatever is appended to it until the context is cancelled, similar
+// to `tail -f`. If the file is replaced (e.g. because it was
+// rotated) or truncated, it is reopened and copied from the start.
+func Follow(ctx context.Context, name string, w io.Writer, pollInterval time.Duration) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		file.Close()
+	}()
+
+	var offset int64
+	for {
+		n, err := io.Copy(w, file)
+		if err != nil {
+			return err
+		}
+		offset += n
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(pollInterval):
+		}
+
+		openStat, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		pathStat, err := os.Stat(name)
+		if err != nil {
+			// The file is being rotated; try again later.
+			continue
+		}
+		if !os.SameFile(openStat, pathStat) || pathStat.Size() < offset {
+			newFile, err := os.Open(name)
+			if err != nil {
+				continue
+			}
+			// Drain what was appended to the old file before it
+			// was replaced.
+			if _, err := io.Copy(w, file); err != nil {
+				newFile.Close()
+				return err
+			}
+			file.Close()
+			file = newFile
+			offset = 0
+		}
+	}
+}
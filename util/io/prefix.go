@@ -3,60 +3,53 @@ package io
 import (
 	"bytes"
 	"io"
+	"sync"
 )
 
 var lineBufInitialCapacity = 140
 var newline byte = 10
 
 // PrefixWriter is a writer that assumes to receive text input and
-// prefixes every line with a given prefix string.
+// prefixes every line with a given prefix string. It is safe for
+// concurrent use.
 type PrefixWriter struct {
 	Underlying       io.Writer
 	Prefix           string
 	lineBuffer       *bytes.Buffer
+	mutex            sync.Mutex
 	prefixOnNextChar bool
 }
 
 // NewPrefixWriter creates a new PrefixWriter. This is the only way
 // to create a PrefixWriter.
-func NewPrefixWriter(underlying io.Writer, prefix string) PrefixWriter {
-	pw := PrefixWriter{
+func NewPrefixWriter(underlying io.Writer, prefix string) *PrefixWriter {
+	return &PrefixWriter{
 		Underlying:       underlying,
 		Prefix:           prefix,
 		lineBuffer:       bytes.NewBuffer(make([]byte, 0, lineBufInitialCapacity)),
 		prefixOnNextChar: true,
 	}
-	return pw
 }
 
-func (w PrefixWriter) Write(inputBytes []byte) (int, error) {
-	nPrefixes := 0
-
-	if w.lineBuffer.Len() != 0 {
-		// Buffer is filled with initial prefix and writer has never
-		// written.
-		nPrefixes++
-	}
+// Write writes the input to the underlying writer, inserting the
+// prefix at the start of every line. The prefix of a line is written
+// together with its first character, so a trailing newline does not
+// cause a dangling prefix.
+func (w *PrefixWriter) Write(inputBytes []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
 
+	w.lineBuffer.Reset()
 	for _, b := range inputBytes {
 		if w.prefixOnNextChar {
-			if _, err := w.lineBuffer.WriteString(w.Prefix); err != nil {
-				return 0, err
-			}
-			nPrefixes++
-		}
-		if err := w.lineBuffer.WriteByte(b); err != nil {
-			return 0, err
+			w.lineBuffer.WriteString(w.Prefix)
 		}
+		w.lineBuffer.WriteByte(b)
 		w.prefixOnNextChar = b == newline
 	}
 
-	bufBytes := w.lineBuffer.Bytes()
-	bytesN, err := w.Underlying.Write(bufBytes)
-	if err != nil {
+	if _, err := w.Underlying.Write(w.lineBuffer.Bytes()); err != nil {
 		return 0, err
 	}
-	n := bytesN - nPrefixes*len(w.Prefix)
-	w.lineBuffer.Reset()
-	return n, nil
+	return len(inputBytes), nil
 }
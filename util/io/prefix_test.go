@@ -0,0 +1,57 @@
+package io_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	uio "t0ast.cc/tbml/util/io"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	testCases := []struct {
+		desc string
+
+		expected string
+		writes   []string
+	}{
+		{
+			desc: "Single line",
+
+			expected: "> foo\n",
+			writes:   []string{"foo\n"},
+		},
+		{
+			desc: "Multiple lines in one write",
+
+			expected: "> foo\n> bar\n",
+			writes:   []string{"foo\nbar\n"},
+		},
+		{
+			desc: "Line split across writes",
+
+			expected: "> foo bar\n> baz",
+			writes:   []string{"foo ", "bar\n", "baz"},
+		},
+		{
+			desc: "Empty lines",
+
+			expected: "> \n> \n",
+			writes:   []string{"\n", "\n"},
+		},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			sb := strings.Builder{}
+			w := uio.NewPrefixWriter(&sb, "> ")
+
+			for _, write := range tC.writes {
+				n, err := w.Write([]byte(write))
+				assert.NoError(t, err)
+				assert.Equal(t, len(write), n)
+			}
+
+			assert.Equal(t, tC.expected, sb.String())
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package io
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// RotatingFile is a writer that appends to a file and rotates it
+// once it would grow beyond a maximum size. Rotated files get the
+// suffixes ".1" (newest) to ".<backups>" (oldest); older files are
+// deleted. It is safe for concurrent use, and several RotatingFiles
+// may write to the same file: if another one rotated the file, it is
+// reopened before writing.
+type RotatingFile struct {
+	backups int
+	file    *os.File
+	maxSize int64
+	mutex   sync.Mutex
+	name    string
+	size    int64
+}
+
+// NewRotatingFile opens the named file for appending, creating it if
+// it does not exist.
+func NewRotatingFile(name string, maxSize int64, backups int) (*RotatingFile, error) {
+	rf := &RotatingFile{
+		backups: backups,
+		maxSize: maxSize,
+		name:    name,
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	if err := rf.reopenIfRotated(); err != nil {
+		return 0, err
+	}
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close closes the underlying file.
+func (rf *RotatingFile) Close() error {
+	rf.mutex.Lock()
+	defer rf.mutex.Unlock()
+
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, FileModeURWGRWO)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	rf.file = file
+	rf.size = stat.Size()
+	return nil
+}
+
+// reopenIfRotated reopens the file if it isn't the one at the name
+// anymore because another writer rotated it. Otherwise, the size is
+// updated with what other writers appended.
+func (rf *RotatingFile) reopenIfRotated() error {
+	stat, err := rf.file.Stat()
+	if err != nil {
+		return err
+	}
+	nameStat, err := os.Stat(rf.name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil && os.SameFile(stat, nameStat) {
+		rf.size = stat.Size()
+		return nil
+	}
+
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+	return rf.open()
+}
+
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	rf.file = nil
+
+	if rf.backups < 1 {
+		if err := os.Remove(rf.name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return rf.open()
+	}
+
+	if err := os.Remove(fmt.Sprintf("%s.%d", rf.name, rf.backups)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for i := rf.backups - 1; i >= 1; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", rf.name, i), fmt.Sprintf("%s.%d", rf.name, i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Rename(rf.name, fmt.Sprint(rf.name, ".1")); err != nil {
+		return err
+	}
+	return rf.open()
+}
@@ -0,0 +1,87 @@
+package io_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	uio "t0ast.cc/tbml/util/io"
+)
+
+func TestRotatingFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.log")
+
+	rf, err := uio.NewRotatingFile(name, 10, 2)
+	require.NoError(t, err)
+
+	write := func(str string) {
+		n, err := rf.Write([]byte(str))
+		assert.NoError(t, err)
+		assert.Equal(t, len(str), n)
+	}
+	assertContent := func(name, expected string) {
+		actual, err := os.ReadFile(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(actual))
+	}
+
+	write("aaaa\n")
+	write("bbbb\n")
+	assertContent(name, "aaaa\nbbbb\n")
+	assert.NoFileExists(t, name+".1")
+
+	write("cccc\n")
+	assertContent(name, "cccc\n")
+	assertContent(name+".1", "aaaa\nbbbb\n")
+
+	write("dddddddddddd\n")
+	write("eeee\n")
+	write("ffff\n")
+	assertContent(name, "eeee\nffff\n")
+	assertContent(name+".1", "dddddddddddd\n")
+	assertContent(name+".2", "cccc\n")
+	assert.NoFileExists(t, name+".3")
+
+	assert.NoError(t, rf.Close())
+
+	rf, err = uio.NewRotatingFile(name, 10, 2)
+	require.NoError(t, err)
+	write("gggg\n")
+	assertContent(name, "gggg\n")
+	assertContent(name+".1", "eeee\nffff\n")
+	assert.NoError(t, rf.Close())
+}
+
+func TestRotatingFileSharedName(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.log")
+
+	a, err := uio.NewRotatingFile(name, 10, 1)
+	require.NoError(t, err)
+	defer a.Close()
+	b, err := uio.NewRotatingFile(name, 10, 1)
+	require.NoError(t, err)
+	defer b.Close()
+
+	write := func(rf *uio.RotatingFile, str string) {
+		_, err := rf.Write([]byte(str))
+		assert.NoError(t, err)
+	}
+	assertContent := func(name, expected string) {
+		actual, err := os.ReadFile(name)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(actual))
+	}
+
+	write(a, "aaaa\n")
+	// b knows about what a wrote, so it rotates the file
+	write(b, "bbbbbbb\n")
+	assertContent(name, "bbbbbbb\n")
+	assertContent(name+".1", "aaaa\n")
+
+	// a writes to the new file instead of the rotated one
+	write(a, "c\n")
+	assertContent(name, "bbbbbbb\nc\n")
+	assertContent(name+".1", "aaaa\n")
+}
@@ -0,0 +1,216 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log message. Messages are only written
+// if their level is at most the level of the logger.
+type Level int
+
+const (
+	LevelError Level = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+// Format is the output format of a logger.
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatText Format = "text"
+)
+
+// LevelEnvVar and FormatEnvVar are the environment variables that
+// ConfigureFromEnv reads and ExportToEnv writes. They carry the log
+// configuration of tbml to the processes it starts.
+const (
+	LevelEnvVar  = "TBML_LOG_LEVEL"
+	FormatEnvVar = "TBML_LOG_FORMAT"
+)
+
+var levelNames = map[Level]string{
+	LevelError: "error",
+	LevelWarn:  "warn",
+	LevelInfo:  "info",
+	LevelDebug: "debug",
+}
+
+// Logger writes leveled log messages as text or JSON lines. It is
+// safe for concurrent use.
+type Logger struct {
+	format Format
+	level  Level
+	mutex  sync.Mutex
+	name   string
+	out    io.Writer
+}
+
+// New creates a logger that writes messages of the given level or
+// lower to out. The name, if not empty, is included in every message.
+func New(out io.Writer, name string, level Level, format Format) *Logger {
+	return &Logger{
+		format: format,
+		level:  level,
+		name:   name,
+		out:    out,
+	}
+}
+
+var std = New(os.Stderr, "", LevelWarn, FormatText)
+
+// Default returns the logger that is used by the package-level
+// functions.
+func Default() *Logger {
+	return std
+}
+
+func (l *Logger) SetFormat(format Format) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.format = format
+}
+
+func (l *Logger) SetLevel(level Level) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.level = level
+}
+
+func (l *Logger) SetName(name string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.name = name
+}
+
+func (l *Logger) SetOutput(out io.Writer) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.out = out
+}
+
+// Enabled returns if messages of the given level are written.
+func (l *Logger) Enabled(level Level) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return level <= l.level
+}
+
+// Logf writes a message with the given level.
+func (l *Logger) Logf(level Level, format string, a ...interface{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if level > l.level {
+		return
+	}
+	msg := fmt.Sprintf(format, a...)
+
+	switch l.format {
+	case FormatJSON:
+		entry := struct {
+			Time  time.Time `json:"time"`
+			Level string    `json:"level"`
+			Name  string    `json:"name,omitempty"`
+			Msg   string    `json:"msg"`
+		}{
+			Time:  time.Now(),
+			Level: levelNames[level],
+			Name:  l.name,
+			Msg:   msg,
+		}
+		entryBytes, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		_, _ = l.out.Write(append(entryBytes, '\n'))
+	default:
+		sb := strings.Builder{}
+		if l.name != "" {
+			sb.WriteString(l.name)
+			sb.WriteString(": ")
+		}
+		if level != LevelError {
+			sb.WriteString(levelNames[level])
+			sb.WriteString(": ")
+		}
+		sb.WriteString(msg)
+		if !strings.HasSuffix(msg, "\n") {
+			sb.WriteString("\n")
+		}
+		_, _ = io.WriteString(l.out, sb.String())
+	}
+}
+
+func (l *Logger) Errorf(format string, a ...interface{}) { l.Logf(LevelError, format, a...) }
+func (l *Logger) Warnf(format string, a ...interface{})  { l.Logf(LevelWarn, format, a...) }
+func (l *Logger) Infof(format string, a ...interface{})  { l.Logf(LevelInfo, format, a...) }
+func (l *Logger) Debugf(format string, a ...interface{}) { l.Logf(LevelDebug, format, a...) }
+
+func Errorf(format string, a ...interface{}) { std.Logf(LevelError, format, a...) }
+func Warnf(format string, a ...interface{})  { std.Logf(LevelWarn, format, a...) }
+func Infof(format string, a ...interface{})  { std.Logf(LevelInfo, format, a...) }
+func Debugf(format string, a ...interface{}) { std.Logf(LevelDebug, format, a...) }
+
+// Enabled returns if the default logger writes messages of the given
+// level.
+func Enabled(level Level) bool {
+	return std.Enabled(level)
+}
+
+// LevelFromVerbosity maps the number of "-v" flags (or -1 for
+// "--quiet") to a level.
+func LevelFromVerbosity(verbosity int) Level {
+	switch {
+	case verbosity < 0:
+		return LevelError
+	case verbosity == 0:
+		return LevelWarn
+	case verbosity == 1:
+		return LevelInfo
+	default:
+		return LevelDebug
+	}
+}
+
+// ParseLevel parses a level name like "debug".
+func ParseLevel(name string) (Level, bool) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(levelName, name) {
+			return level, true
+		}
+	}
+	return 0, false
+}
+
+// ConfigureFromEnv configures the default logger from the
+// environment variables set by ExportToEnv, if they are set.
+func ConfigureFromEnv() {
+	if level, ok := ParseLevel(os.Getenv(LevelEnvVar)); ok {
+		std.SetLevel(level)
+	}
+	switch Format(os.Getenv(FormatEnvVar)) {
+	case FormatJSON:
+		std.SetFormat(FormatJSON)
+	case FormatText:
+		std.SetFormat(FormatText)
+	}
+}
+
+// ExportToEnv writes the default logger's configuration to the
+// environment, so that processes started by tbml (like the Mothership
+// connector) log the same way.
+func ExportToEnv() {
+	std.mutex.Lock()
+	defer std.mutex.Unlock()
+	os.Setenv(LevelEnvVar, levelNames[std.level])
+	os.Setenv(FormatEnvVar, string(std.format))
+}
@@ -0,0 +1,66 @@
+package log_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ulog "t0ast.cc/tbml/util/log"
+)
+
+func TestLoggerText(t *testing.T) {
+	sb := strings.Builder{}
+	l := ulog.New(&sb, "", ulog.LevelInfo, ulog.FormatText)
+
+	l.Errorf("Something failed: %s", "reason")
+	l.Warnf("Careful")
+	l.Infof("Starting %d things", 2)
+	l.Debugf("This is not written")
+
+	assert.Equal(t, "Something failed: reason\nwarn: Careful\ninfo: Starting 2 things\n", sb.String())
+}
+
+func TestLoggerTextWithName(t *testing.T) {
+	sb := strings.Builder{}
+	l := ulog.New(&sb, "connector", ulog.LevelDebug, ulog.FormatText)
+
+	l.Debugf("Hello\n")
+
+	assert.Equal(t, "connector: debug: Hello\n", sb.String())
+}
+
+func TestLoggerJSON(t *testing.T) {
+	sb := strings.Builder{}
+	l := ulog.New(&sb, "connector", ulog.LevelWarn, ulog.FormatJSON)
+
+	l.Warnf("Careful")
+	l.Infof("This is not written")
+
+	lines := strings.Split(strings.TrimSuffix(sb.String(), "\n"), "\n")
+	assert.Len(t, lines, 1)
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "warn", entry["level"])
+	assert.Equal(t, "connector", entry["name"])
+	assert.Equal(t, "Careful", entry["msg"])
+	assert.NotEmpty(t, entry["time"])
+}
+
+func TestLevelFromVerbosity(t *testing.T) {
+	assert.Equal(t, ulog.LevelError, ulog.LevelFromVerbosity(-1))
+	assert.Equal(t, ulog.LevelWarn, ulog.LevelFromVerbosity(0))
+	assert.Equal(t, ulog.LevelInfo, ulog.LevelFromVerbosity(1))
+	assert.Equal(t, ulog.LevelDebug, ulog.LevelFromVerbosity(2))
+	assert.Equal(t, ulog.LevelDebug, ulog.LevelFromVerbosity(5))
+}
+
+func TestParseLevel(t *testing.T) {
+	level, ok := ulog.ParseLevel("DEBUG")
+	assert.True(t, ok)
+	assert.Equal(t, ulog.LevelDebug, level)
+
+	_, ok = ulog.ParseLevel("verbose")
+	assert.False(t, ok)
+}